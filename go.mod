@@ -5,4 +5,5 @@ go 1.16
 require (
 	github.com/dustin/go-humanize v1.0.1
 	github.com/mattn/go-sqlite3 v1.14.16
+	gopkg.in/yaml.v3 v3.0.1
 )
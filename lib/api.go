@@ -0,0 +1,743 @@
+// amt - program to access an SQLite database and lookup acronyms
+//
+// author:	Simon Rowe <simon@wiremoons.com>
+// license: open-source released under The MIT License (MIT).
+//
+// Package used to offer the acronym database over HTTP for
+// application 'amt'
+//
+// The HTTP server answers JSON requests to search, read, add, change,
+// tag and remove acronym records, to read their change history, and to
+// list the sources, tags and database statistics. Each request uses the same functions as the
+// command line, so both behave the same way. A record's ETag holds its
+// version, and a change sent with 'If-Match' is refused if someone else
+// has changed the record in the meantime.
+
+package lib
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"mime"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// serveDefaultAddress is the address the HTTP server listens on unless
+// another is given.
+const serveDefaultAddress = "localhost:8080"
+
+// The number of records returned by a search unless the request asks
+// for another number, and the most it may ask for.
+const (
+	apiDefaultLimit = 50
+	apiMaxLimit     = 500
+)
+
+// apiMaxBody is the largest request body accepted.
+const apiMaxBody = 1 << 20
+
+// apiRecordInput holds the values sent to add or change an acronym
+// record. Values not sent are left as nil - so a change leaves them as
+// they are.
+type apiRecordInput struct {
+	UUID        *string   `json:"uuid"`
+	Acronym     *string   `json:"acronym"`
+	Definition  *string   `json:"definition"`
+	Description *string   `json:"description"`
+	Source      *string   `json:"source"`
+	Tags        *[]string `json:"tags"`
+	Version     *int64    `json:"version"`
+}
+
+// apply copies the values held in 'in' to the record 'r'.
+func (in *apiRecordInput) apply(r *Record) {
+	for _, f := range []struct {
+		value *string
+		field *string
+	}{
+		{in.Acronym, &r.Acronym},
+		{in.Definition, &r.Definition},
+		{in.Description, &r.Description},
+		{in.Source, &r.Source},
+	} {
+		if f.value != nil {
+			*f.field = strings.TrimSpace(*f.value)
+		}
+	}
+	if in.Tags != nil {
+		r.Tags = SplitTags(strings.Join(*in.Tags, ","))
+	}
+}
+
+// apiSearchResult holds a page of the records found by a search.
+type apiSearchResult struct {
+	Total   int      `json:"total"`
+	Offset  int      `json:"offset"`
+	Limit   int      `json:"limit"`
+	Records []Record `json:"records"`
+}
+
+// newServeMux returns the handler for every path answered by the HTTP
+// server - the API under '/api/' and the web pages - each allowed to
+// API tokens with the roles given. If 'local' is set, requests are not
+// checked until a token has been issued.
+func newServeMux(local bool) *http.ServeMux {
+	auth := func(read, write string, h http.HandlerFunc) http.HandlerFunc {
+		return authorize(local, read, write, h)
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/acronyms", auth(RoleReader, RoleEditor, apiAcronyms))
+	mux.HandleFunc("/api/acronyms/", auth(RoleReader, RoleEditor, apiAcronym))
+	mux.HandleFunc("/api/sources", auth(RoleReader, RoleReader, apiGet(func() (interface{}, error) { return ListSources() })))
+	mux.HandleFunc("/api/tags", auth(RoleReader, RoleReader, apiGet(func() (interface{}, error) { return ListTags() })))
+	mux.HandleFunc("/api/stats", auth(RoleReader, RoleReader, apiGet(func() (interface{}, error) { return GetStats() })))
+	mux.HandleFunc("/api/trash", auth(RoleReader, RoleReader, apiGet(func() (interface{}, error) { return ListTrash() })))
+	mux.HandleFunc("/api/trash/", auth(RoleEditor, RoleEditor, apiRestore))
+	mux.HandleFunc("/api/tokens", auth(RoleAdmin, RoleAdmin, apiTokens))
+	mux.HandleFunc("/api/tokens/", auth(RoleAdmin, RoleAdmin, apiToken))
+	mux.HandleFunc("/api/", func(w http.ResponseWriter, r *http.Request) {
+		apiError(w, http.StatusNotFound, "no such API endpoint: "+r.URL.Path)
+	})
+	mux.HandleFunc("/", auth(RoleReader, RoleEditor, uiSearch))
+	mux.HandleFunc("/acronyms/", auth(RoleReader, RoleEditor, uiAcronym))
+	mux.HandleFunc("/ui/", uiAsset)
+	uiToken = NewUUID()
+	return mux
+}
+
+// Serve answers HTTP requests on 'address' - such as 'localhost:8080', or
+// ':8080' to accept requests from other computers - until the program is
+// interrupted with Ctrl-C.
+func Serve(address string) error {
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		return fmt.Errorf("ERROR: unable to start the HTTP server: %v", err)
+	}
+	local := isLoopback(listener.Addr())
+	issued, err := hasTokens()
+	if err != nil {
+		listener.Close()
+		return fmt.Errorf("ERROR: unable to read the API tokens: %v", err)
+	}
+	if !local && !issued {
+		listener.Close()
+		return fmt.Errorf("ERROR: issue an API token with '%s token issue <name>' before accepting requests from other computers", Appname)
+	}
+	var handler http.Handler = newServeMux(local)
+	if local {
+		handler = localOnly(handler)
+	}
+	srv := &http.Server{
+		Handler:           logRequests(handler),
+		ReadHeaderTimeout: 10 * time.Second,
+		ReadTimeout:       30 * time.Second,
+		WriteTimeout:      30 * time.Second,
+		IdleTimeout:       2 * time.Minute,
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	go func() {
+		<-ctx.Done()
+		shutdown, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		srv.Shutdown(shutdown)
+	}()
+
+	if issued {
+		fmt.Println("\nEvery request must carry an API token - list them with:  " + Appname + " token list")
+	} else {
+		fmt.Println("\nNo API tokens have been issued, so requests from this computer are not checked")
+	}
+	fmt.Printf("\nHTTP server listening on:  http://%s/  - press Ctrl-C to stop\n", listener.Addr())
+	if err = srv.Serve(listener); err != http.ErrServerClosed {
+		return fmt.Errorf("ERROR: HTTP server failed: %v", err)
+	}
+	fmt.Println("\nHTTP server stopped")
+	return nil
+}
+
+// isLoopback returns true if 'addr' is only reachable from this
+// computer.
+func isLoopback(addr net.Addr) bool {
+	tcp, ok := addr.(*net.TCPAddr)
+	return ok && tcp.IP.IsLoopback()
+}
+
+// localOnly refuses requests naming any host other than this computer,
+// so a web page can not reach a server listening on localhost by
+// pointing its own host name at this computer.
+func localOnly(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host := r.Host
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+		host = strings.Trim(host, "[]")
+		if ip := net.ParseIP(host); !strings.EqualFold(host, "localhost") && (ip == nil || !ip.IsLoopback()) {
+			apiError(w, http.StatusForbidden, "requests must be made to localhost")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// statusRecorder remembers the status code of a response, for logging.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+// WriteHeader records the status code before sending it.
+func (s *statusRecorder) WriteHeader(status int) {
+	s.status = status
+	s.ResponseWriter.WriteHeader(status)
+}
+
+// logRequests logs every request and the status of its response when
+// debug output is enabled.
+func logRequests(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		start := time.Now()
+		next.ServeHTTP(rec, r)
+		if DebugSwitch {
+			log.Printf("DEBUG: HTTP %s %s %s -> %d (%v)\n", r.RemoteAddr, r.Method, r.URL, rec.status, time.Since(start))
+		}
+	})
+}
+
+// apiAcronyms answers '/api/acronyms' - GET searches the records and
+// POST adds a new one.
+func apiAcronyms(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+		apiSearch(w, r)
+	case http.MethodPost:
+		apiCreate(w, r)
+	default:
+		apiNotAllowed(w, "GET, HEAD, POST")
+	}
+}
+
+// apiAcronym answers '/api/acronyms/<id|uuid>' - GET reads the record,
+// PUT or PATCH changes it, and DELETE removes it. The record's change
+// history and tags are answered below it, at '.../history' and
+// '.../tags'.
+func apiAcronym(w http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/api/acronyms/"), "/")
+	value := parts[0]
+	if (!isNumber(value) && !IsUUID(value)) || len(parts) > 2 {
+		apiError(w, http.StatusNotFound, "no such API endpoint: "+r.URL.Path)
+		return
+	}
+	if len(parts) == 2 {
+		switch parts[1] {
+		case "history":
+			apiRecordHistory(w, r, value)
+		case "tags":
+			apiRecordTags(w, r, value)
+		default:
+			apiError(w, http.StatusNotFound, "no such API endpoint: "+r.URL.Path)
+		}
+		return
+	}
+	rec, err := LookupRecord(value)
+	if err != nil {
+		apiFail(w, err)
+		return
+	}
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+		w.Header().Set("ETag", recordETag(rec))
+		if etagMatches(r.Header.Get("If-None-Match"), recordETag(rec)) {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		apiJSON(w, r, http.StatusOK, rec)
+	case http.MethodPut, http.MethodPatch:
+		apiUpdate(w, r, rec)
+	case http.MethodDelete:
+		apiDelete(w, r, rec)
+	default:
+		apiNotAllowed(w, "GET, HEAD, PUT, PATCH, DELETE")
+	}
+}
+
+// apiSearch answers a search for acronym records. The query parameters
+// match the command line search flags: 'q' the acronym, 'wild' any
+// similar matches, 'tags', 'added', 'changed' and 'author' - plus
+// 'source'. Results are returned a page at a time, set by 'limit' and
+// 'offset', with a 'Link' header to the next and previous pages.
+func apiSearch(w http.ResponseWriter, r *http.Request) {
+	params := r.URL.Query()
+	q := SearchQuery{
+		Term:   params.Get("q"),
+		Tags:   SplitTags(params.Get("tags")),
+		Author: params.Get("author"),
+		Source: params.Get("source"),
+	}
+	q.Wild, _ = strconv.ParseBool(params.Get("wild"))
+	limit, offset, err := parsePage(params)
+	if err != nil {
+		apiError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if v := params.Get("added"); v != "" {
+		if q.AddedSince, err = parseSinceParam(v); err != nil {
+			apiError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+	}
+	if v := params.Get("changed"); v != "" {
+		if q.ChangedSince, err = parseSinceParam(v); err != nil {
+			apiError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+	}
+
+	records, err := FindRecords(q)
+	if err != nil {
+		apiFail(w, err)
+		return
+	}
+
+	result := apiSearchResult{Total: len(records), Offset: offset, Limit: limit, Records: []Record{}}
+	if offset < len(records) {
+		end := offset + limit
+		if end > len(records) {
+			end = len(records)
+		}
+		result.Records = records[offset:end]
+	}
+	var links []string
+	if offset+limit < len(records) {
+		links = append(links, fmt.Sprintf("<%s>; rel=\"next\"", pageURL(r.URL, limit, offset+limit)))
+	}
+	if offset > 0 {
+		prev := offset - limit
+		if prev < 0 {
+			prev = 0
+		}
+		links = append(links, fmt.Sprintf("<%s>; rel=\"prev\"", pageURL(r.URL, limit, prev)))
+	}
+	if len(links) > 0 {
+		w.Header().Set("Link", strings.Join(links, ", "))
+	}
+	apiJSON(w, r, http.StatusOK, result)
+}
+
+// parseSinceParam reads the 'added' or 'changed' query parameter - a
+// time in RFC 3339 form, or an age or date as accepted on the command
+// line.
+func parseSinceParam(value string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	return ParseSince(value)
+}
+
+// parsePage reads the 'limit' and 'offset' query parameters that
+// choose a page of search results.
+func parsePage(params url.Values) (limit, offset int, err error) {
+	limit = apiDefaultLimit
+	if v := params.Get("limit"); v != "" {
+		if limit, err = strconv.Atoi(v); err != nil || limit < 1 || limit > apiMaxLimit {
+			return 0, 0, fmt.Errorf("ERROR: limit must be a number from 1 to %d", apiMaxLimit)
+		}
+	}
+	if v := params.Get("offset"); v != "" {
+		if offset, err = strconv.Atoi(v); err != nil || offset < 0 {
+			return 0, 0, fmt.Errorf("ERROR: offset must be a number of 0 or more")
+		}
+	}
+	return limit, offset, nil
+}
+
+// pageURL returns the address of the page of results at 'offset' of the
+// search 'u'.
+func pageURL(u *url.URL, limit, offset int) string {
+	params := u.Query()
+	params.Set("limit", strconv.Itoa(limit))
+	params.Set("offset", strconv.Itoa(offset))
+	page := url.URL{Path: u.Path, RawQuery: params.Encode()}
+	return page.String()
+}
+
+// apiCreate adds the acronym record sent in the request body, replying
+// with the new record and its location.
+func apiCreate(w http.ResponseWriter, r *http.Request) {
+	var in apiRecordInput
+	if !apiReadBody(w, r, &in) {
+		return
+	}
+	var rec Record
+	in.apply(&rec)
+	if in.UUID != nil {
+		rec.UUID = strings.TrimSpace(*in.UUID)
+	}
+	if err := ValidateRecord(&rec); err != nil {
+		apiError(w, http.StatusUnprocessableEntity, err.Error())
+		return
+	}
+	if err := InsertRecord(&rec, requestUser(r)); err != nil {
+		apiFail(w, err)
+		return
+	}
+	saved, err := GetRecord(rec.ID)
+	if err != nil {
+		apiFail(w, err)
+		return
+	}
+	w.Header().Set("Location", fmt.Sprintf("/api/acronyms/%d", saved.ID))
+	w.Header().Set("ETag", recordETag(saved))
+	apiJSON(w, r, http.StatusCreated, saved)
+}
+
+// apiUpdate changes the acronym record 'rec' using the values sent in
+// the request body - values not sent are left unchanged. The version
+// being changed must be given in an 'If-Match' header holding the
+// record's ETag, or as 'version' in the body.
+func apiUpdate(w http.ResponseWriter, r *http.Request, rec Record) {
+	var in apiRecordInput
+	if !apiReadBody(w, r, &in) {
+		return
+	}
+	version, ok := apiBaseVersion(w, r, rec, in.Version)
+	if !ok {
+		return
+	}
+	in.apply(&rec)
+	rec.Version = version
+	if err := ValidateRecord(&rec); err != nil {
+		apiError(w, http.StatusUnprocessableEntity, err.Error())
+		return
+	}
+	if err := UpdateRecord(&rec, requestUser(r)); err != nil {
+		apiFail(w, err)
+		return
+	}
+	saved, err := GetRecord(rec.ID)
+	if err != nil {
+		apiFail(w, err)
+		return
+	}
+	w.Header().Set("ETag", recordETag(saved))
+	apiJSON(w, r, http.StatusOK, saved)
+}
+
+// apiDelete removes the acronym record 'rec' to the trash. If the
+// request has an 'If-Match' header the record is only removed if it is
+// still at that version.
+func apiDelete(w http.ResponseWriter, r *http.Request, rec Record) {
+	check := r.Header.Get("If-Match") != ""
+	if check {
+		var ok bool
+		if rec.Version, ok = apiBaseVersion(w, r, rec, nil); !ok {
+			return
+		}
+	}
+	var trashID int64
+	var err error
+	if check {
+		trashID, err = DeleteRecordVersion(rec, requestUser(r))
+	} else {
+		trashID, err = DeleteRecord(rec.ID, requestUser(r))
+	}
+	if err != nil {
+		apiFail(w, err)
+		return
+	}
+	apiJSON(w, r, http.StatusOK, map[string]int64{"id": rec.ID, "trash_id": trashID})
+}
+
+// apiHistory holds the change history of an acronym record.
+type apiHistory struct {
+	ID      int64        `json:"id"`
+	History []AuditEntry `json:"history"`
+}
+
+// apiRecordHistory answers '/api/acronyms/<id|uuid>/history' - GET reads
+// every change made to the record, which may since have been removed.
+func apiRecordHistory(w http.ResponseWriter, r *http.Request, value string) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		apiNotAllowed(w, "GET, HEAD")
+		return
+	}
+	id, history, err := FindHistory(value)
+	if err != nil {
+		apiFail(w, err)
+		return
+	}
+	if history == nil {
+		history = []AuditEntry{}
+	}
+	apiJSON(w, r, http.StatusOK, apiHistory{ID: id, History: history})
+}
+
+// apiTagsInput holds the tags sent to be added to an acronym record.
+type apiTagsInput struct {
+	Tags []string `json:"tags"`
+}
+
+// apiTagsChanged holds the number of tags added to or removed from an
+// acronym record.
+type apiTagsChanged struct {
+	ID      int64 `json:"id"`
+	Changed int64 `json:"changed"`
+}
+
+// apiRecordTags answers '/api/acronyms/<id|uuid>/tags' - POST adds the
+// tags sent in the request body to the record, and DELETE removes those
+// given by the 'tags' query parameter.
+func apiRecordTags(w http.ResponseWriter, r *http.Request, value string) {
+	var tags []string
+	switch r.Method {
+	case http.MethodPost:
+		var in apiTagsInput
+		if !apiReadBody(w, r, &in) {
+			return
+		}
+		tags = SplitTags(strings.Join(in.Tags, ","))
+	case http.MethodDelete:
+		tags = SplitTags(r.URL.Query().Get("tags"))
+	default:
+		apiNotAllowed(w, "POST, DELETE")
+		return
+	}
+	if len(tags) == 0 {
+		apiError(w, http.StatusUnprocessableEntity, "no tags given")
+		return
+	}
+	for _, tag := range tags {
+		if err := ValidateTag(tag); err != nil {
+			apiError(w, http.StatusUnprocessableEntity, err.Error())
+			return
+		}
+	}
+	rec, err := LookupRecord(value)
+	if err != nil {
+		apiFail(w, err)
+		return
+	}
+	var changed int64
+	if r.Method == http.MethodPost {
+		changed, err = TagRecord(rec.ID, tags, requestUser(r))
+	} else {
+		changed, err = UntagRecord(rec.ID, tags, requestUser(r))
+	}
+	if err != nil {
+		apiFail(w, err)
+		return
+	}
+	apiJSON(w, r, http.StatusOK, apiTagsChanged{ID: rec.ID, Changed: changed})
+}
+
+// apiRestore answers '/api/trash/<trash id>/restore' - POST brings the
+// removed acronym record back from the trash.
+func apiRestore(w http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/api/trash/"), "/")
+	trashID, err := strconv.ParseInt(parts[0], 10, 64)
+	if len(parts) != 2 || parts[1] != "restore" || err != nil {
+		apiError(w, http.StatusNotFound, "no such API endpoint: "+r.URL.Path)
+		return
+	}
+	if r.Method != http.MethodPost {
+		apiNotAllowed(w, "POST")
+		return
+	}
+	rec, err := RestoreRecord(trashID, requestUser(r))
+	if errors.Is(err, sql.ErrNoRows) {
+		apiError(w, http.StatusNotFound, fmt.Sprintf("no item with trash ID '%d' found", trashID))
+		return
+	}
+	if err != nil {
+		apiFail(w, err)
+		return
+	}
+	w.Header().Set("ETag", recordETag(rec))
+	apiJSON(w, r, http.StatusOK, rec)
+}
+
+// apiBaseVersion returns the version of the record 'rec' a change was
+// made to - taken from the 'If-Match' header, or from 'version' if
+// that is not sent. An 'If-Match' of '*' accepts the current version.
+// The client is sent an error, and 'ok' is false, if neither is given
+// or the header does not name this record.
+func apiBaseVersion(w http.ResponseWriter, r *http.Request, rec Record, version *int64) (int64, bool) {
+	match := strings.TrimSpace(r.Header.Get("If-Match"))
+	switch {
+	case match == "*":
+		return rec.Version, true
+	case match != "":
+		for _, tag := range strings.Split(match, ",") {
+			if v, ok := parseRecordETag(strings.TrimSpace(tag), rec.UUID); ok {
+				return v, true
+			}
+		}
+		apiError(w, http.StatusPreconditionFailed, "the If-Match header does not match this record")
+		return 0, false
+	case version != nil:
+		return *version, true
+	}
+	apiError(w, http.StatusPreconditionRequired,
+		"send the record's ETag in an If-Match header, or its version, so changes made by others are not lost")
+	return 0, false
+}
+
+// recordETag returns the ETag of the acronym record 'r' - its UUID and
+// version.
+func recordETag(r Record) string {
+	return fmt.Sprintf("\"%s.%d\"", r.UUID, r.Version)
+}
+
+// parseRecordETag returns the version held in the ETag 'tag', if it is
+// an ETag for the record with the UUID 'uuid'.
+func parseRecordETag(tag, uuid string) (int64, bool) {
+	tag = strings.Trim(strings.TrimPrefix(tag, "W/"), "\"")
+	i := strings.LastIndex(tag, ".")
+	if i < 0 || !strings.EqualFold(tag[:i], uuid) {
+		return 0, false
+	}
+	v, err := strconv.ParseInt(tag[i+1:], 10, 64)
+	return v, err == nil
+}
+
+// etagMatches returns true if the 'If-None-Match' header 'header' holds
+// the ETag 'etag'.
+func etagMatches(header, etag string) bool {
+	for _, tag := range strings.Split(header, ",") {
+		tag = strings.TrimPrefix(strings.TrimSpace(tag), "W/")
+		if tag == "*" || tag == strings.TrimPrefix(etag, "W/") {
+			return true
+		}
+	}
+	return false
+}
+
+// apiGet returns a handler answering GET requests with the value
+// returned by 'read'.
+func apiGet(read func() (interface{}, error)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			apiNotAllowed(w, "GET, HEAD")
+			return
+		}
+		v, err := read()
+		if err != nil {
+			apiFail(w, err)
+			return
+		}
+		apiJSON(w, r, http.StatusOK, v)
+	}
+}
+
+// apiReadBody reads the JSON request body into 'v', replying with an
+// error and returning false if it can not be read. Only JSON bodies are
+// accepted, which a web page on another site can not send without the
+// browser first asking this server - and being refused.
+func apiReadBody(w http.ResponseWriter, r *http.Request, v interface{}) bool {
+	if mt, _, err := mime.ParseMediaType(r.Header.Get("Content-Type")); err != nil || mt != "application/json" {
+		apiError(w, http.StatusUnsupportedMediaType, "the request body must be JSON, sent as 'Content-Type: application/json'")
+		return false
+	}
+	dec := json.NewDecoder(http.MaxBytesReader(w, r.Body, apiMaxBody))
+	if err := dec.Decode(v); err != nil {
+		apiError(w, http.StatusBadRequest, "unable to read the request body: "+err.Error())
+		return false
+	}
+	return true
+}
+
+// apiJSON sends 'v' to the client as JSON with the status 'status'. A
+// successful reply to a GET request without its own ETag is given one
+// made from its content, so an unchanged reply can be answered with
+// '304 Not Modified'.
+func apiJSON(w http.ResponseWriter, r *http.Request, status int, v interface{}) {
+	body, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		apiError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	body = append(body, '\n')
+	h := w.Header()
+	if status == http.StatusOK && (r.Method == http.MethodGet || r.Method == http.MethodHead) {
+		if h.Get("ETag") == "" {
+			h.Set("ETag", fmt.Sprintf("W/\"%x\"", sha256.Sum256(body)))
+			if etagMatches(r.Header.Get("If-None-Match"), h.Get("ETag")) {
+				w.WriteHeader(http.StatusNotModified)
+				return
+			}
+		}
+		h.Set("Cache-Control", "no-cache")
+	}
+	h.Set("Content-Type", "application/json; charset=utf-8")
+	h.Set("Content-Length", strconv.Itoa(len(body)))
+	w.WriteHeader(status)
+	if r.Method != http.MethodHead {
+		w.Write(body)
+	}
+}
+
+// apiError sends the error message 'message' to the client as JSON with
+// the status 'status'.
+func apiError(w http.ResponseWriter, status int, message string) {
+	body, _ := json.Marshal(map[string]string{"error": strings.TrimPrefix(message, "ERROR: ")})
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(status)
+	w.Write(append(body, '\n'))
+}
+
+// apiFail sends the error 'err' returned by the database functions to
+// the client, with the status that best describes it.
+func apiFail(w http.ResponseWriter, err error) {
+	status, message := errorStatus(err)
+	var conflict *ConflictError
+	if errors.As(err, &conflict) {
+		// the record as it is now is sent too, so the client can
+		// combine the two changes
+		body, _ := json.Marshal(remoteError{Error: strings.TrimPrefix(message, "ERROR: "), Current: &conflict.Theirs})
+		w.Header().Set("ETag", recordETag(conflict.Theirs))
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		w.WriteHeader(status)
+		w.Write(append(body, '\n'))
+		return
+	}
+	apiError(w, status, message)
+}
+
+// errorStatus returns the HTTP status that best describes the error
+// 'err' returned by the database functions, and the message to show.
+func errorStatus(err error) (int, string) {
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		return http.StatusNotFound, "no acronym record found"
+	case errors.As(err, new(*ConflictError)):
+		return http.StatusPreconditionFailed, err.Error()
+	case errors.Is(err, ErrReadOnly):
+		return http.StatusForbidden, err.Error()
+	case errors.Is(err, ErrInterrupted), isBusy(err):
+		return http.StatusServiceUnavailable, err.Error()
+	}
+	log.Println(err)
+	return http.StatusInternalServerError, err.Error()
+}
+
+// apiNotAllowed replies that the request method can not be used, listing
+// those that can in 'allow'.
+func apiNotAllowed(w http.ResponseWriter, allow string) {
+	w.Header().Set("Allow", allow)
+	apiError(w, http.StatusMethodNotAllowed, "method not allowed - use: "+allow)
+}
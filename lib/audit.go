@@ -0,0 +1,236 @@
+// amt - program to access an SQLite database and lookup acronyms
+//
+// author:	Simon Rowe <simon@wiremoons.com>
+// license: open-source released under The MIT License (MIT).
+//
+// Package used to keep the change history of acronym records for
+// application 'amt'
+//
+// Every insert, update and delete made to an acronym record through
+// 'amt' is added to the 'AUDIT' table, with the values of the record
+// before and after the change, when it was made and by whom.
+
+package lib
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The actions recorded in the AUDIT table. A 'restore' brings a
+// record back from the trash, and an 'undo' reverses an earlier change
+// - which may itself insert, update or delete the record.
+const (
+	auditInsert  = "insert"
+	auditUpdate  = "update"
+	auditDelete  = "delete"
+	auditRestore = "restore"
+	auditUndo    = "undo"
+)
+
+// AuditEntry holds a single change made to an acronym record, as held
+// in the AUDIT table. 'Old' is nil for an insert and 'New' is nil for
+// a delete.
+type AuditEntry struct {
+	ID        int64     `json:"id"`
+	AcronymID int64     `json:"acronym_id"`
+	Action    string    `json:"action"`
+	Old       *Record   `json:"old"`
+	New       *Record   `json:"new"`
+	ChangedAt time.Time `json:"changed_at"`
+	ChangedBy string    `json:"changed_by"`
+}
+
+// auditChange adds a change made to the acronym record with the ID
+// 'id' to the AUDIT table, within the same transaction 'tx' as the
+// change itself. The record's values before the change are given by
+// 'old', and its values after the change are read back from the
+// database - unless the record no longer exists.
+func auditChange(tx *dbTx, action string, id int64, old *Record) error {
+	var oldValues, newValues sql.NullString
+	var err error
+	if old != nil {
+		if oldValues, err = recordJSON(old); err != nil {
+			return err
+		}
+	}
+	r, err := getRecord(tx, id)
+	switch {
+	case err == sql.ErrNoRows:
+		// record removed - so there are no new values
+	case err != nil:
+		return fmt.Errorf("ERROR: unable to read acronym ID '%d' for change history: %v", id, err)
+	default:
+		if newValues, err = recordJSON(&r); err != nil {
+			return err
+		}
+	}
+	// an undo is never itself undone - so it is marked as such
+	undone := action == auditUndo
+	_, err = tx.Exec(`insert into AUDIT(AcronymID, Action, OldValues, NewValues, ChangedAt, ChangedBy, Undone)
+		values(?,?,?,?,?,?,?)`, id, action, oldValues, newValues, dbTime(time.Now()), tx.author, undone)
+	if err != nil {
+		return fmt.Errorf("ERROR: unable to add change to history of acronym ID '%d': %v", id, err)
+	}
+	return nil
+}
+
+// recordJSON returns the acronym record 'r' as a JSON object, for
+// storing in the AUDIT table.
+func recordJSON(r *Record) (sql.NullString, error) {
+	data, err := json.Marshal(r)
+	if err != nil {
+		return sql.NullString{}, fmt.Errorf("ERROR: unable to encode acronym ID '%d' for change history: %v", r.ID, err)
+	}
+	return sql.NullString{String: string(data), Valid: true}, nil
+}
+
+// parseRecordJSON converts a JSON object read from the AUDIT table
+// back into an acronym record. A NULL value returns nil.
+func parseRecordJSON(value sql.NullString) (*Record, error) {
+	if !value.Valid {
+		return nil, nil
+	}
+	var r Record
+	if err := json.Unmarshal([]byte(value.String), &r); err != nil {
+		return nil, err
+	}
+	return &r, nil
+}
+
+// RecordHistory returns every change made to the acronym record with
+// the ID 'id', oldest first. The history is still available once
+// the record itself has been removed.
+func RecordHistory(id int64) ([]AuditEntry, error) {
+	rows, err := DB.Query(`select AuditID, AcronymID, Action, OldValues, NewValues, ChangedAt, ChangedBy
+		from AUDIT where AcronymID = ? order by AuditID;`, id)
+	if err != nil {
+		return nil, fmt.Errorf("ERROR: unable to read the history of acronym ID '%d': %v", id, err)
+	}
+	defer rows.Close()
+
+	var history []AuditEntry
+	for rows.Next() {
+		var e AuditEntry
+		var oldValues, newValues, changedAt sql.NullString
+		err = rows.Scan(&e.ID, &e.AcronymID, &e.Action, &oldValues, &newValues, &changedAt, &e.ChangedBy)
+		if err != nil {
+			return nil, fmt.Errorf("ERROR: reading history record: %v", err)
+		}
+		e.ChangedAt = parseDBTime(changedAt)
+		if e.Old, err = parseRecordJSON(oldValues); err != nil {
+			return nil, fmt.Errorf("ERROR: reading history record %d: %v", e.ID, err)
+		}
+		if e.New, err = parseRecordJSON(newValues); err != nil {
+			return nil, fmt.Errorf("ERROR: reading history record %d: %v", e.ID, err)
+		}
+		history = append(history, e)
+	}
+	return history, rows.Err()
+}
+
+// FindHistory returns the ID of the acronym record with the ID or UUID
+// 'value', and every change made to it, oldest first.
+func FindHistory(value string) (int64, []AuditEntry, error) {
+	if !IsUUID(value) {
+		id, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return 0, nil, fmt.Errorf("ERROR: acronym ID '%s' is not a valid number or UUID", value)
+		}
+		history, err := RecordHistory(id)
+		return id, history, err
+	}
+	id, err := HistoryID(value)
+	if err != nil {
+		return 0, nil, err
+	}
+	history, err := RecordHistory(id)
+	return id, history, err
+}
+
+// HistoryID returns the ID of the acronym record with the UUID 'uuid'.
+// A removed record is found from the values kept in its change
+// history, so its history can still be shown. If the UUID is not
+// known 'sql.ErrNoRows' is returned.
+func HistoryID(uuid string) (int64, error) {
+	if r, err := GetRecordByUUID(uuid); err == nil {
+		return r.ID, nil
+	} else if err != sql.ErrNoRows {
+		return 0, err
+	}
+	var id int64
+	err := DB.QueryRow(`select AcronymID from AUDIT
+		where json_extract(coalesce(NewValues, OldValues), '$.uuid') = ?
+		order by AuditID desc limit 1;`, strings.ToLower(uuid)).Scan(&id)
+	if err != nil && err != sql.ErrNoRows {
+		return 0, fmt.Errorf("ERROR: unable to find acronym UUID '%s' in the change history: %v", uuid, err)
+	}
+	return id, err
+}
+
+// ShowHistory displays the full timeline of changes made to the
+// acronym record with the ID or UUID 'value' on stdout, as read from
+// 'Storage'. Updates are shown as the values that changed.
+func ShowHistory(value string) error {
+	id, history, err := Storage.RecordHistory(value)
+	if err == sql.ErrNoRows {
+		return fmt.Errorf("ERROR: no changes have been recorded for acronym UUID: '%s'", value)
+	}
+	if err != nil {
+		return err
+	}
+	fmt.Printf("\n\nHISTORY OF ACRONYM RECORD\n¯¯¯¯¯¯¯¯¯¯¯¯¯¯¯¯¯¯¯¯¯¯¯¯¯\n")
+	if len(history) == 0 {
+		fmt.Printf("\nNo changes have been recorded for acronym ID: '%d'\n", id)
+		return nil
+	}
+	fmt.Printf("\n%d changes recorded for acronym ID: '%d'\n\n", len(history), id)
+	for _, e := range history {
+		fmt.Printf("%s  %-7s  by %s\n", e.ChangedAt.Local().Format("2006-01-02 15:04:05"), strings.ToUpper(e.Action), e.ChangedBy)
+		switch {
+		case e.Old == nil && e.New != nil:
+			printRecordValues(e.New)
+		case e.New == nil && e.Old != nil:
+			printRecordValues(e.Old)
+		case e.Old != nil && e.New != nil:
+			printRecordChanges(e.Old, e.New)
+		}
+		fmt.Println()
+	}
+	return nil
+}
+
+// recordFields returns the user visible values of the acronym record
+// 'r' in display order, paired with their names.
+func recordFields(r *Record) [][2]string {
+	return [][2]string{
+		{"ACRONYM", r.Acronym},
+		{"EXPANDED", r.Definition},
+		{"DESCRIPTION", r.Description},
+		{"SOURCE", r.Source},
+		{"TAGS", strings.Join(r.Tags, ", ")},
+	}
+}
+
+// printRecordValues shows every value of a record within a history
+// timeline.
+func printRecordValues(r *Record) {
+	for _, f := range recordFields(r) {
+		fmt.Printf("\t%s: %s\n", f[0], f[1])
+	}
+}
+
+// printRecordChanges shows only the values that differ between two
+// versions of a record within a history timeline.
+func printRecordChanges(old, new *Record) {
+	oldFields, newFields := recordFields(old), recordFields(new)
+	for i := range oldFields {
+		if oldFields[i][1] != newFields[i][1] {
+			fmt.Printf("\t%s: '%s' -> '%s'\n", oldFields[i][0], oldFields[i][1], newFields[i][1])
+		}
+	}
+}
@@ -0,0 +1,187 @@
+// amt - program to access an SQLite database and lookup acronyms
+//
+// author:	Simon Rowe <simon@wiremoons.com>
+// license: open-source released under The MIT License (MIT).
+//
+// Package used to check the API token sent with each request to the
+// HTTP server for application 'amt'
+//
+// Once any API token has been issued, every request must carry one -
+// as 'Authorization: Bearer <token>', or as the password of a web
+// browser login - and the token's role must allow what is asked. Until
+// then, a server listening only on this computer answers anyone on it,
+// as before. A server listening for other computers will not start
+// without a token. Changes are recorded as made by the token's name.
+
+package lib
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"net/http"
+	"strings"
+)
+
+// tokenKey is the request context key holding the API token a request
+// was made with.
+type tokenKey struct{}
+
+// requestToken returns the API token the request 'r' was made with, if
+// there was one.
+func requestToken(r *http.Request) (Token, bool) {
+	t, ok := r.Context().Value(tokenKey{}).(Token)
+	return t, ok
+}
+
+// requestUser returns the name recorded as the author of changes asked
+// for by the request 'r' - its API token's name, or the user running
+// the server if no token was needed.
+func requestUser(r *http.Request) string {
+	if t, ok := requestToken(r); ok {
+		return t.Name
+	}
+	return CurrentUser()
+}
+
+// tokenAllows returns true if the API token the request 'r' was made
+// with, if any, has at least the role 'role'.
+func tokenAllows(r *http.Request, role string) bool {
+	t, ok := requestToken(r)
+	return !ok || roleRank[t.Role] >= roleRank[role]
+}
+
+// requestSecret returns the API token sent with the request 'r' - as a
+// bearer token, or as the password of a browser login.
+func requestSecret(r *http.Request) string {
+	header := r.Header.Get("Authorization")
+	if len(header) > 7 && strings.EqualFold(header[:7], "Bearer ") {
+		return strings.TrimSpace(header[7:])
+	}
+	if _, password, ok := r.BasicAuth(); ok {
+		return password
+	}
+	return ""
+}
+
+// authorize returns a handler that passes a request on to 'next' only
+// if it carries an API token with the role 'read', for a GET or HEAD
+// request, or 'write' for any other. If 'local' is set, and no tokens
+// have been issued, every request is passed on.
+func authorize(local bool, read, write string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		need := write
+		if r.Method == http.MethodGet || r.Method == http.MethodHead {
+			need = read
+		}
+		secret := requestSecret(r)
+		if secret == "" {
+			issued, err := hasTokens()
+			if err != nil {
+				authFail(w, r, http.StatusInternalServerError, err.Error())
+				return
+			}
+			if local && !issued {
+				next(w, r)
+				return
+			}
+			authFail(w, r, http.StatusUnauthorized, "an API token is needed - send it in an 'Authorization: Bearer' header")
+			return
+		}
+		t, err := findToken(secret)
+		if errors.Is(err, sql.ErrNoRows) {
+			authFail(w, r, http.StatusUnauthorized, "the API token is not valid, or has been revoked")
+			return
+		}
+		if err != nil {
+			authFail(w, r, http.StatusInternalServerError, err.Error())
+			return
+		}
+		if roleRank[t.Role] < roleRank[need] {
+			authFail(w, r, http.StatusForbidden, "API token '"+t.Name+"' has the "+t.Role+" role - this needs the "+need+" role")
+			return
+		}
+		next(w, r.WithContext(context.WithValue(r.Context(), tokenKey{}, t)))
+	}
+}
+
+// authFail refuses the request 'r' with the status 'status' - as JSON
+// for the API, or as a web page otherwise. Without a valid token, a web
+// browser is asked to log in with the token as the password.
+func authFail(w http.ResponseWriter, r *http.Request, status int, message string) {
+	if strings.HasPrefix(r.URL.Path, "/api/") {
+		if status == http.StatusUnauthorized {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="`+Appname+`"`)
+		}
+		apiError(w, status, message)
+		return
+	}
+	if status == http.StatusUnauthorized {
+		w.Header().Set("WWW-Authenticate", `Basic realm="`+Appname+`", charset="UTF-8"`)
+		message = "Log in with any user name, and your API token as the password."
+	}
+	uiError(w, status, message)
+}
+
+// apiTokens answers '/api/tokens' - GET lists the API tokens and POST
+// issues a new one, given its 'name' and 'role'. The new token is only
+// ever sent in the reply to the POST. The first token must be issued
+// with 'amt token issue', as until then a local server answers requests
+// without one.
+func apiTokens(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+		tokens, err := ListTokens()
+		if err != nil {
+			apiFail(w, err)
+			return
+		}
+		if tokens == nil {
+			tokens = []Token{}
+		}
+		apiJSON(w, r, http.StatusOK, tokens)
+	case http.MethodPost:
+		if _, ok := requestToken(r); !ok {
+			apiError(w, http.StatusForbidden, "issue the first API token with '"+Appname+" token issue <name>'")
+			return
+		}
+		var in struct {
+			Name string `json:"name"`
+			Role string `json:"role"`
+		}
+		if !apiReadBody(w, r, &in) {
+			return
+		}
+		secret, err := IssueToken(in.Name, in.Role, requestUser(r))
+		if errors.Is(err, ErrReadOnly) || errors.Is(err, ErrInterrupted) {
+			apiFail(w, err)
+			return
+		}
+		if err != nil {
+			apiError(w, http.StatusUnprocessableEntity, err.Error())
+			return
+		}
+		apiJSON(w, r, http.StatusCreated, map[string]string{"name": strings.TrimSpace(in.Name), "role": in.Role, "token": secret})
+	default:
+		apiNotAllowed(w, "GET, HEAD, POST")
+	}
+}
+
+// apiToken answers '/api/tokens/<name>' - DELETE revokes the API token.
+func apiToken(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodDelete {
+		apiNotAllowed(w, "DELETE")
+		return
+	}
+	name := strings.TrimPrefix(r.URL.Path, "/api/tokens/")
+	err := RevokeToken(name, requestUser(r))
+	if errors.Is(err, sql.ErrNoRows) {
+		apiError(w, http.StatusNotFound, "no API token called '"+name+"' found")
+		return
+	}
+	if err != nil {
+		apiFail(w, err)
+		return
+	}
+	apiJSON(w, r, http.StatusOK, map[string]string{"revoked": name})
+}
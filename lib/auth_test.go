@@ -0,0 +1,117 @@
+// amt - program to access an SQLite database and lookup acronyms
+//
+// author:	Simon Rowe <simon@wiremoons.com>
+// license: open-source released under The MIT License (MIT).
+//
+// Package used to test the API token checks made by the HTTP server
+// for application 'amt'
+
+package lib
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// authorizeStatus makes a request with 'method' through a handler
+// wrapped by authorize, sending 'secret' as a bearer token if it is
+// set. It returns the status of the reply, and the name of the token
+// the request was passed on with.
+func authorizeStatus(local bool, read, write, method, secret string) (status int, user string) {
+	h := authorize(local, read, write, func(w http.ResponseWriter, r *http.Request) {
+		user = requestUser(r)
+		w.WriteHeader(http.StatusOK)
+	})
+	r := httptest.NewRequest(method, "/api/acronyms", nil)
+	if secret != "" {
+		r.Header.Set("Authorization", "Bearer "+secret)
+	}
+	w := httptest.NewRecorder()
+	h(w, r)
+	return w.Code, user
+}
+
+func TestAuthorizeNoTokens(t *testing.T) {
+	migrateTestDB(t)
+	// a server only this computer can reach needs no token until one
+	// has been issued - but any other server always does
+	if status, _ := authorizeStatus(true, RoleReader, RoleEditor, http.MethodPost, ""); status != http.StatusOK {
+		t.Errorf("local request without tokens issued = %d, want %d", status, http.StatusOK)
+	}
+	if status, _ := authorizeStatus(false, RoleReader, RoleEditor, http.MethodGet, ""); status != http.StatusUnauthorized {
+		t.Errorf("remote request without tokens issued = %d, want %d", status, http.StatusUnauthorized)
+	}
+}
+
+func TestAuthorizeRoles(t *testing.T) {
+	migrateTestDB(t)
+	secrets := make(map[string]string)
+	for _, role := range []string{RoleReader, RoleEditor, RoleAdmin} {
+		secret, err := IssueToken(role+"-token", role, "admin")
+		if err != nil {
+			t.Fatal(err)
+		}
+		secrets[role] = secret
+	}
+	revoked, err := IssueToken("revoked", RoleAdmin, "admin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = RevokeToken("revoked", "admin"); err != nil {
+		t.Fatal(err)
+	}
+	secrets["revoked"] = revoked
+	secrets["unknown"] = "not-a-token"
+
+	tests := []struct {
+		token       string
+		read, write string
+		method      string
+		want        int
+	}{
+		// once a token is issued, even a local request needs one
+		{token: "", read: RoleReader, write: RoleEditor, method: http.MethodGet, want: http.StatusUnauthorized},
+		{token: "unknown", read: RoleReader, write: RoleEditor, method: http.MethodGet, want: http.StatusUnauthorized},
+		{token: "revoked", read: RoleReader, write: RoleEditor, method: http.MethodGet, want: http.StatusUnauthorized},
+		{token: RoleReader, read: RoleReader, write: RoleEditor, method: http.MethodGet, want: http.StatusOK},
+		{token: RoleReader, read: RoleReader, write: RoleEditor, method: http.MethodHead, want: http.StatusOK},
+		{token: RoleReader, read: RoleReader, write: RoleEditor, method: http.MethodPost, want: http.StatusForbidden},
+		{token: RoleReader, read: RoleReader, write: RoleEditor, method: http.MethodDelete, want: http.StatusForbidden},
+		{token: RoleEditor, read: RoleReader, write: RoleEditor, method: http.MethodPut, want: http.StatusOK},
+		{token: RoleAdmin, read: RoleReader, write: RoleEditor, method: http.MethodDelete, want: http.StatusOK},
+		{token: RoleEditor, read: RoleAdmin, write: RoleAdmin, method: http.MethodGet, want: http.StatusForbidden},
+		{token: RoleAdmin, read: RoleAdmin, write: RoleAdmin, method: http.MethodPost, want: http.StatusOK},
+	}
+	for _, tt := range tests {
+		status, user := authorizeStatus(true, tt.read, tt.write, tt.method, secrets[tt.token])
+		if status != tt.want {
+			t.Errorf("%s with token %q needing %s/%s = %d, want %d", tt.method, tt.token, tt.read, tt.write, status, tt.want)
+		}
+		// changes are recorded against the name of the token used
+		if want := tt.token + "-token"; status == http.StatusOK && user != want {
+			t.Errorf("%s with token %q passed on as user %q, want %q", tt.method, tt.token, user, want)
+		}
+	}
+}
+
+func TestRequestSecret(t *testing.T) {
+	tests := []struct {
+		name   string
+		set    func(r *http.Request)
+		secret string
+	}{
+		{name: "bearer", set: func(r *http.Request) { r.Header.Set("Authorization", "Bearer abc123") }, secret: "abc123"},
+		{name: "bearer case", set: func(r *http.Request) { r.Header.Set("Authorization", "bearer  abc123 ") }, secret: "abc123"},
+		{name: "browser login", set: func(r *http.Request) { r.SetBasicAuth("anyone", "abc123") }, secret: "abc123"},
+		{name: "no token", set: func(r *http.Request) {}, secret: ""},
+		{name: "empty bearer", set: func(r *http.Request) { r.Header.Set("Authorization", "Bearer ") }, secret: ""},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		tt.set(r)
+		if got := requestSecret(r); got != tt.secret {
+			t.Errorf("%s: requestSecret() = %q, want %q", tt.name, got, tt.secret)
+		}
+	}
+}
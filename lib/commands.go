@@ -0,0 +1,636 @@
+// amt - program to access an SQLite database and lookup acronyms
+//
+// author:	Simon Rowe <simon@wiremoons.com>
+// license: open-source released under The MIT License (MIT).
+//
+// Package used to run the sub-commands for application 'amt'
+//
+// Sub-commands are given as plain words after any command line flags,
+// such as:
+//
+//	amt -f acronyms.db tag 14307 networking
+
+package lib
+
+import (
+	"database/sql"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dustin/go-humanize"
+)
+
+// command holds a single sub-command that can be run from the command
+// line, along with the help text shown for it by MyUsage.
+type command struct {
+	name        string
+	args        string
+	description string
+	run         func(args []string) error
+	// writes is set for commands that change the database, which are
+	// refused when it is open read-only
+	writes bool
+	// remote is set for commands that also work with the acronyms
+	// held by an amt server, as they only use 'Storage'
+	remote bool
+}
+
+// commands lists every sub-command available, in the order they are
+// shown in the help output.
+var commands = []command{
+	{
+		name:        "edit",
+		args:        "<acronym id|uuid>",
+		description: "change an existing acronym record",
+		run:         runEdit,
+		writes:      true,
+		remote:      true,
+	},
+	{
+		name:        "history",
+		args:        "<acronym id|uuid>",
+		description: "show every change made to an acronym record",
+		run:         runHistory,
+		remote:      true,
+	},
+	{
+		name:        "trash",
+		description: "list the removed acronym records held in the trash",
+		run:         runTrash,
+		remote:      true,
+	},
+	{
+		name:        "restore",
+		args:        "<trash id>",
+		description: "bring a removed acronym record back from the trash",
+		run:         runRestore,
+		writes:      true,
+		remote:      true,
+	},
+	{
+		name:        "purge",
+		args:        "<days>",
+		description: "permanently delete trash items older than days given",
+		run:         runPurge,
+		writes:      true,
+	},
+	{
+		name:        "undo",
+		description: "undo your last change to the acronym records",
+		run:         runUndo,
+		writes:      true,
+	},
+	{
+		name:        "tag",
+		args:        "<acronym id|uuid> <tag>...",
+		description: "add one or more tags to an acronym record",
+		run:         runTag,
+		writes:      true,
+		remote:      true,
+	},
+	{
+		name:        "untag",
+		args:        "<acronym id|uuid> <tag>...",
+		description: "remove one or more tags from an acronym record",
+		run:         runUntag,
+		writes:      true,
+		remote:      true,
+	},
+	{
+		name:        "tags",
+		description: "list all tags and the number of records using them",
+		run:         runTags,
+		remote:      true,
+	},
+	{
+		name:        "import",
+		args:        "[options] <file>",
+		description: "add acronym records from a CSV, TSV, JSON, YAML, TBX, LaTeX or wtf file - see: import -h",
+		run:         runImport,
+	},
+	{
+		name:        "export",
+		args:        "[options] <file>",
+		description: "write the whole glossary to a file - see: export -h",
+		run:         runExport,
+		remote:      true,
+	},
+	{
+		name:        "dictd",
+		args:        "[-listen address]",
+		description: "answer DICT protocol (RFC 2229) clients such as 'dict' - see: dictd -h",
+		run:         runDictd,
+	},
+	{
+		name:        "serve",
+		args:        "[-listen address]",
+		description: "answer JSON requests over HTTP to search and change the acronyms - see: serve -h",
+		run:         runServe,
+	},
+	{
+		name:        "lsp",
+		args:        "[-source name]",
+		description: "answer Language Server Protocol requests from an editor on stdin and stdout - see: lsp -h",
+		run:         runLsp,
+		remote:      true,
+	},
+	{
+		name:        "daemon",
+		description: "hold the acronyms in memory to answer searches instantly over a Unix socket",
+		run:         runDaemon,
+	},
+	{
+		name:        "token",
+		args:        "issue [-role role] <name> | revoke <name> | list",
+		description: "issue, revoke or list the API tokens accepted by serve - see: token -h",
+		run:         runToken,
+	},
+	{
+		name:        "stats",
+		description: "show record counts by source and by tag",
+		run:         runStats,
+		remote:      true,
+	},
+}
+
+// RunCommand runs the sub-command named by the first entry in 'args',
+// passing it the remaining entries as its own arguments. An error is
+// returned if the sub-command is unknown or fails.
+func RunCommand(args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("ERROR: no command provided")
+	}
+	for _, c := range commands {
+		if c.name == args[0] {
+			if DebugSwitch {
+				log.Printf("DEBUG: running command '%s' with arguments: %v\n", c.name, args[1:])
+			}
+			if Remote() && !c.remote {
+				return fmt.Errorf("ERROR: command '%s' is not available with -server - it works only on a local database, so run it where the database of the server at '%s' is held",
+					c.name, ServerURL)
+			}
+			if c.writes {
+				if err := requireWritable(); err != nil {
+					return fmt.Errorf("%v - command '%s' changes the database", err, c.name)
+				}
+			}
+			return c.run(args[1:])
+		}
+	}
+	return fmt.Errorf("ERROR: unknown command '%s'\nrun '%s -h' for a list of available commands", args[0], Appname)
+}
+
+// commandUsage returns the help text listing every sub-command.
+func commandUsage() string {
+	var b strings.Builder
+	b.WriteString("\nCommands:\n\n")
+	for _, c := range commands {
+		fmt.Fprintf(&b, "        %-35s %s\n", strings.TrimSpace(c.name+" "+c.args), c.description)
+	}
+	var local []string
+	for _, c := range commands {
+		if !c.remote {
+			local = append(local, c.name)
+		}
+	}
+	fmt.Fprintf(&b, "\nNot available with -server, as they work only on a local database: %s\n", strings.Join(local, ", "))
+	return b.String()
+}
+
+// parseRecordID converts the acronym ID or UUID typed by the user into
+// the record's ID, checking the record exists.
+func parseRecordID(value string) (int64, error) {
+	r, err := Storage.LookupRecord(value)
+	if err == sql.ErrNoRows {
+		return 0, fmt.Errorf("ERROR: no acronym with ID: '%s' found in the database", value)
+	} else if err != nil {
+		return 0, err
+	}
+	return r.ID, nil
+}
+
+// runEdit changes an existing acronym record.
+func runEdit(args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("ERROR: usage is: %s edit <acronym id>", Appname)
+	}
+	return EditRecord(args[0])
+}
+
+// runHistory shows the change history of an acronym record. The
+// record does not need to exist any more, so the history of a removed
+// record can still be seen.
+func runHistory(args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("ERROR: usage is: %s history <acronym id>", Appname)
+	}
+	return ShowHistory(args[0])
+}
+
+// runTrash lists the removed acronym records held in the trash.
+func runTrash(args []string) error {
+	items, err := Storage.ListTrash()
+	if err != nil {
+		return err
+	}
+	fmt.Printf("\n\nACRONYM RECORDS IN THE TRASH\n¯¯¯¯¯¯¯¯¯¯¯¯¯¯¯¯¯¯¯¯¯¯¯¯¯¯¯¯\n\n")
+	if len(items) == 0 {
+		fmt.Println("The trash is empty.")
+		return nil
+	}
+	for _, item := range items {
+		fmt.Printf("TRASH ID: %d  (removed %s by %s)\n", item.ID,
+			item.DeletedAt.Local().Format("2006-01-02 15:04"), item.DeletedBy)
+		printRecord(item.Record)
+	}
+	fmt.Printf("Restore a record with:  %s restore <trash id>\n", Appname)
+	return nil
+}
+
+// runRestore brings a removed acronym record back from the trash.
+func runRestore(args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("ERROR: usage is: %s restore <trash id>", Appname)
+	}
+	trashID, err := strconv.ParseInt(args[0], 10, 64)
+	if err != nil {
+		return fmt.Errorf("ERROR: trash ID '%s' is not a valid number", args[0])
+	}
+	r, err := Storage.RestoreRecord(trashID)
+	if err == sql.ErrNoRows {
+		return fmt.Errorf("ERROR: no item with trash ID: '%d' found - run '%s trash' to list them", trashID, Appname)
+	}
+	if err != nil {
+		return err
+	}
+	fmt.Printf("\nSUCCESS: record restored from the trash:\n\n")
+	printRecord(r)
+	return nil
+}
+
+// runPurge permanently deletes old items from the trash, once the
+// user has confirmed it.
+func runPurge(args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("ERROR: usage is: %s purge <days>", Appname)
+	}
+	days, err := strconv.Atoi(args[0])
+	if err != nil || days < 0 {
+		return fmt.Errorf("ERROR: '%s' is not a valid number of days", args[0])
+	}
+	fmt.Printf("\nPermanently delete all records removed more than %d days ago from the trash?  ", days)
+	if !CheckContinue() {
+		fmt.Println("Purge of the trash aborted at users request")
+		return nil
+	}
+	n, err := PurgeTrash(time.Duration(days) * 24 * time.Hour)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("SUCCESS: %d records purged from the trash\n", n)
+	return nil
+}
+
+// runUndo reverses the last change made by the current user.
+func runUndo(args []string) error {
+	done, err := UndoLast(CurrentUser())
+	if err != nil {
+		return err
+	}
+	fmt.Printf("\nSUCCESS: %s\n", done)
+	return nil
+}
+
+// runTag adds tags to an acronym record and shows the updated record.
+func runTag(args []string) error {
+	if len(args) < 2 {
+		return fmt.Errorf("ERROR: usage is: %s tag <acronym id> <tag>...", Appname)
+	}
+	id, err := parseRecordID(args[0])
+	if err != nil {
+		return err
+	}
+	n, err := Storage.TagRecord(id, SplitTags(strings.Join(args[1:], ",")))
+	if err != nil {
+		return err
+	}
+	return showTaggedRecord(id, fmt.Sprintf("%d tags added", n))
+}
+
+// runUntag removes tags from an acronym record and shows the updated
+// record.
+func runUntag(args []string) error {
+	if len(args) < 2 {
+		return fmt.Errorf("ERROR: usage is: %s untag <acronym id> <tag>...", Appname)
+	}
+	id, err := parseRecordID(args[0])
+	if err != nil {
+		return err
+	}
+	n, err := Storage.UntagRecord(id, SplitTags(strings.Join(args[1:], ",")))
+	if err != nil {
+		return err
+	}
+	return showTaggedRecord(id, fmt.Sprintf("%d tags removed", n))
+}
+
+// showTaggedRecord displays an acronym record after its tags have
+// been changed, along with a summary of the change made.
+func showTaggedRecord(id int64, changed string) error {
+	r, err := Storage.LookupRecord(strconv.FormatInt(id, 10))
+	if err != nil {
+		return fmt.Errorf("ERROR: unable to read acronym ID '%d': %v", id, err)
+	}
+	fmt.Printf("\nSUCCESS: %s - tags now held on record:\n\n", changed)
+	printRecord(r)
+	return nil
+}
+
+// runTags lists every tag and the number of records carrying it.
+func runTags(args []string) error {
+	tags, err := Storage.ListTags()
+	if err != nil {
+		return err
+	}
+	fmt.Printf("\nExisting %d acronym tags:\n\n", len(tags))
+	for _, t := range tags {
+		fmt.Printf("  %-30s %d\n", t.Name, t.Count)
+	}
+	return nil
+}
+
+// runImport adds the acronym records held in a file, as described by
+// the import options given on the command line.
+func runImport(args []string) error {
+	fs := flag.NewFlagSet(Appname+" import", flag.ContinueOnError)
+	format := fs.String("format", "", "file `format`: csv, tsv, json, yaml, tbx, tex or wtf - taken from the file name if not given")
+	onDuplicate := fs.String("on-duplicate", duplicateSkip, "for rows already held: skip, update or duplicate")
+	dryRun := fs.Bool("dry-run", false, "check every row and report what would happen, but save nothing")
+	batchSize := fs.Int("batch", 500, "number of `rows` saved in each transaction")
+	reportFile := fs.String("report", "", "write the outcome of every row to this CSV `file` instead of the screen")
+	columns := fs.String("map", "", "extra column headings, such as: 'long form=definition,abbrev=acronym'")
+	if err := fs.Parse(args); err != nil {
+		if err == flag.ErrHelp {
+			return nil
+		}
+		return err
+	}
+	if fs.NArg() != 1 {
+		return fmt.Errorf("ERROR: usage is: %s import [options] <file>\nrun '%s import -h' for the options", Appname, Appname)
+	}
+	// a dry run saves nothing, so it can check a read-only database
+	if !*dryRun {
+		if err := requireWritable(); err != nil {
+			return err
+		}
+	}
+	name := fs.Arg(0)
+
+	fromName := *format == ""
+	if fromName {
+		*format = strings.TrimPrefix(strings.ToLower(filepath.Ext(name)), ".")
+	}
+	var comma rune
+	switch *format {
+	case "csv", "txt":
+		comma = ','
+	case "tsv", "tab":
+		comma = '\t'
+	case "json", "yaml", "yml":
+		// a whole glossary written by the export command
+	case "tbx", "tex", "wtf":
+	default:
+		// the acronym files used by wtf(6) have no extension
+		if fromName && isWtfFile(name) {
+			*format = "wtf"
+			break
+		}
+		return fmt.Errorf("ERROR: unable to tell the format of import file '%s' - set one with: -format csv", name)
+	}
+	mapping, err := ParseColumnMapping(*columns)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.Open(name)
+	if err != nil {
+		return fmt.Errorf("ERROR: unable to open import file: %v", err)
+	}
+	defer f.Close()
+	var rows []ImportRow
+	var ignored []string
+	var glossary *Glossary
+	switch {
+	case comma != 0:
+		rows, ignored, err = ReadCSV(f, comma, mapping)
+	case *format == "wtf":
+		// records are given the name of the file as their source
+		rows, err = ReadWtf(f, filepath.Base(name))
+	case *format == "tbx":
+		rows, err = ReadTBX(f)
+	case *format == "tex":
+		rows, err = ReadLaTeX(f)
+	default:
+		glossary, err = ReadGlossary(f, *format)
+	}
+	if err != nil {
+		return err
+	}
+
+	opts := ImportOptions{
+		OnDuplicate: *onDuplicate,
+		DryRun:      *dryRun,
+		BatchSize:   *batchSize,
+		Progress:    os.Stderr,
+	}
+	fmt.Printf("\n\nIMPORT ACRONYM RECORDS\n¯¯¯¯¯¯¯¯¯¯¯¯¯¯¯¯¯¯¯¯¯¯\n\n")
+	var report *ImportReport
+	if glossary != nil {
+		fmt.Printf("Importing %s records, %d sources and %d tags from:  '%s'\n",
+			humanize.Comma(int64(len(glossary.Records))), len(glossary.Sources), len(glossary.Tags), name)
+		report, err = ImportGlossary(glossary, opts)
+	} else {
+		fmt.Printf("Importing %s rows from:  '%s'\n", humanize.Comma(int64(len(rows))), name)
+		if len(ignored) > 0 {
+			fmt.Printf("Columns not imported:  %s\n", strings.Join(ignored, ", "))
+		}
+		report, err = ImportRecords(rows, opts)
+	}
+	if report == nil {
+		return err
+	}
+	if *reportFile != "" {
+		if werr := writeReportFile(*reportFile, report); werr != nil {
+			return werr
+		}
+		fmt.Printf("\nThe outcome of every row has been written to:  '%s'\n", *reportFile)
+	}
+	printImportReport(report, *reportFile == "")
+	return err
+}
+
+// runExport writes the whole glossary to the file named on the command
+// line, in the format chosen by its extension or the -format option.
+func runExport(args []string) error {
+	fs := flag.NewFlagSet(Appname+" export", flag.ContinueOnError)
+	var opts ExportOptions
+	fs.StringVar(&opts.Format, "format", "", "file `format`: "+exportFormatNames()+" - taken from the file name if not given")
+	fs.Func("used", "only export acronyms used in this LaTeX `document` - can be given more than once", func(doc string) error {
+		opts.UsedIn = append(opts.UsedIn, doc)
+		return nil
+	})
+	if err := fs.Parse(args); err != nil {
+		if err == flag.ErrHelp {
+			fmt.Fprintf(fs.Output(), "\nExport formats:\n")
+			for _, f := range exportFormats {
+				fmt.Fprintf(fs.Output(), "  %-10s %s\n", f.name, f.description)
+			}
+			return nil
+		}
+		return err
+	}
+	if fs.NArg() != 1 {
+		return fmt.Errorf("ERROR: usage is: %s export [options] <file>\nrun '%s export -h' for the options", Appname, Appname)
+	}
+	name := fs.Arg(0)
+	count, err := ExportGlossary(name, opts)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("\nSUCCESS: %s acronym records exported to:  '%s'\n", humanize.Comma(int64(count)), name)
+	return nil
+}
+
+// writeReportFile writes the outcome of every row of an import to the
+// CSV file called 'name'.
+func writeReportFile(name string, report *ImportReport) error {
+	f, err := os.Create(name)
+	if err != nil {
+		return fmt.Errorf("ERROR: unable to create import report file: %v", err)
+	}
+	if err = WriteImportReport(f, report); err != nil {
+		f.Close()
+		return fmt.Errorf("ERROR: unable to write import report file '%s': %v", name, err)
+	}
+	return f.Close()
+}
+
+// runDictd runs a DICT protocol server on the address given on the
+// command line.
+func runDictd(args []string) error {
+	fs := flag.NewFlagSet(Appname+" dictd", flag.ContinueOnError)
+	address := fs.String("listen", dictDefaultAddress, "`address` to listen on - use ':2628' to accept clients from other computers")
+	if err := fs.Parse(args); err != nil {
+		if err == flag.ErrHelp {
+			return nil
+		}
+		return err
+	}
+	if fs.NArg() != 0 {
+		return fmt.Errorf("ERROR: usage is: %s dictd [-listen address]", Appname)
+	}
+	return ServeDict(*address)
+}
+
+// runServe answers HTTP requests for the acronym records until
+// interrupted.
+func runServe(args []string) error {
+	fs := flag.NewFlagSet(Appname+" serve", flag.ContinueOnError)
+	address := fs.String("listen", serveDefaultAddress, "`address` to listen on - use ':8080' to accept requests from other computers")
+	if err := fs.Parse(args); err != nil {
+		if err == flag.ErrHelp {
+			return nil
+		}
+		return err
+	}
+	if fs.NArg() != 0 {
+		return fmt.Errorf("ERROR: usage is: %s serve [-listen address]", Appname)
+	}
+	return Serve(*address)
+}
+
+// runDaemon answers searches from memory over a Unix socket until
+// interrupted.
+func runDaemon(args []string) error {
+	if len(args) != 0 {
+		return fmt.Errorf("ERROR: usage is: %s daemon", Appname)
+	}
+	return ServeDaemon()
+}
+
+// runToken issues, revokes or lists the API tokens accepted by the HTTP
+// server.
+func runToken(args []string) error {
+	usage := fmt.Errorf("ERROR: usage is: %s token issue [-role reader|editor|admin] <name> | revoke <name> | list", Appname)
+	if len(args) == 0 {
+		return usage
+	}
+	switch args[0] {
+	case "issue":
+		fs := flag.NewFlagSet(Appname+" token issue", flag.ContinueOnError)
+		role := fs.String("role", RoleReader, "`role` of the new token: reader, editor or admin")
+		if err := fs.Parse(args[1:]); err != nil {
+			if err == flag.ErrHelp {
+				return nil
+			}
+			return err
+		}
+		if fs.NArg() != 1 {
+			return usage
+		}
+		secret, err := IssueToken(fs.Arg(0), *role, CurrentUser())
+		if err != nil {
+			return err
+		}
+		fmt.Printf("\n\nISSUE AN API TOKEN\n¯¯¯¯¯¯¯¯¯¯¯¯¯¯¯¯¯¯\n")
+		fmt.Printf("SUCCESS: API token '%s' issued with the %s role:\n\n\t%s\n\n", strings.TrimSpace(fs.Arg(0)), *role, secret)
+		fmt.Println("Keep it safe - it can not be shown again. Send it with each request as:")
+		fmt.Println("\tAuthorization: Bearer <token>")
+		return nil
+	case "revoke":
+		if len(args) != 2 {
+			return usage
+		}
+		err := RevokeToken(args[1], CurrentUser())
+		if err == sql.ErrNoRows {
+			return fmt.Errorf("ERROR: no API token called '%s' found - run '%s token list' to list them", args[1], Appname)
+		}
+		if err != nil {
+			return err
+		}
+		fmt.Printf("\nSUCCESS: API token '%s' revoked\n", args[1])
+		return nil
+	case "list":
+		if len(args) != 1 {
+			return usage
+		}
+		tokens, err := ListTokens()
+		if err != nil {
+			return err
+		}
+		fmt.Printf("\n\nAPI TOKENS\n¯¯¯¯¯¯¯¯¯¯\n\n")
+		if len(tokens) == 0 {
+			fmt.Printf("No API tokens have been issued - add one with:  %s token issue <name>\n", Appname)
+			return nil
+		}
+		for _, t := range tokens {
+			fmt.Printf("%-24s %-7s issued %s by %s\n", t.Name, t.Role, t.CreatedAt.Local().Format("2006-01-02 15:04"), t.CreatedBy)
+		}
+		return nil
+	case "-h", "-help", "--help":
+		fmt.Println(strings.TrimPrefix(usage.Error(), "ERROR: "))
+		return nil
+	}
+	return usage
+}
+
+// runStats shows a summary of the database contents.
+func runStats(args []string) error {
+	return ShowStats()
+}
@@ -0,0 +1,169 @@
+// amt - program to access an SQLite database and lookup acronyms
+//
+// author:	Simon Rowe <simon@wiremoons.com>
+// license: open-source released under The MIT License (MIT).
+//
+// Package used to read the optional configuration file for
+// application 'amt'
+//
+// The configuration file holds one 'key = value' setting per line.
+// Blank lines and lines starting with '#' are ignored. For example:
+//
+//	# name recorded against new and changed acronym records
+//	author = Simon Rowe
+//	# journal mode for a database held on a network drive
+//	journal_mode = delete
+//	# open the database read-only: yes, no or immutable
+//	readonly = yes
+//	# use the acronyms held by an amt server, with this API token
+//	server = https://acronyms.example.com
+//	token = amt_0123...
+//
+// The file is read from the location given in the environment
+// variable AMTCONFIG, or otherwise from 'amt/amt.conf' in the users
+// standard configuration directory - such as '~/.config/amt/amt.conf'
+// on Linux.
+
+package lib
+
+import (
+	"bufio"
+	"fmt"
+	"log"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strings"
+)
+
+// Config holds the settings read from the configuration file.
+type Config struct {
+	// Author is the name recorded as the creator or last editor of
+	// acronym records. When empty the login name of the user is used.
+	Author string
+	// JournalMode is the SQLite journal mode used for the database.
+	// When empty 'wal' is used - but 'delete' should be set for a
+	// database held on a network drive, where WAL does not work.
+	JournalMode string
+	// ReadOnly opens the database read-only, so no changes can be made
+	// to it. Immutable also tells SQLite the database file never
+	// changes while it is open, so it is read without any locking.
+	ReadOnly  bool
+	Immutable bool
+	// Server is the address of an amt HTTP server to use in place of
+	// a local database, and Token the API token sent to it.
+	Server string
+	Token  string
+}
+
+// Settings holds the configuration in use by the program, as read by
+// LoadConfig.
+var Settings Config
+
+// ConfigFile returns the path and file name of the configuration
+// file, whether or not it exists.
+func ConfigFile() string {
+	if name := os.Getenv("AMTCONFIG"); name != "" {
+		return name
+	}
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "amt", "amt.conf")
+}
+
+// LoadConfig reads the configuration file into 'Settings'. A missing
+// configuration file is not an error - the default settings are used
+// instead. An error is returned if the file exists but can not be
+// read, or contains a line that is not a valid setting.
+func LoadConfig() error {
+	name := ConfigFile()
+	if name == "" {
+		return nil
+	}
+	f, err := os.Open(name)
+	if os.IsNotExist(err) {
+		if DebugSwitch {
+			log.Printf("DEBUG: no configuration file found at: '%s'\n", name)
+		}
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("ERROR: unable to read configuration file '%s': %v", name, err)
+	}
+	defer f.Close()
+
+	if DebugSwitch {
+		log.Printf("DEBUG: reading configuration file: '%s'\n", name)
+	}
+	scanner := bufio.NewScanner(f)
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		idx := strings.Index(line, "=")
+		if idx < 0 {
+			return fmt.Errorf("ERROR: configuration file '%s' line %d is not a 'key = value' setting", name, lineNo)
+		}
+		key := strings.ToLower(strings.TrimSpace(line[:idx]))
+		value := strings.TrimSpace(line[idx+1:])
+		if err = Settings.set(key, value); err != nil {
+			return fmt.Errorf("ERROR: configuration file '%s' line %d: %v", name, lineNo, err)
+		}
+	}
+	return scanner.Err()
+}
+
+// set stores a single setting read from the configuration file.
+func (c *Config) set(key, value string) error {
+	switch key {
+	case "author":
+		c.Author = value
+	case "journal_mode":
+		switch mode := strings.ToLower(value); mode {
+		case "wal", "delete", "truncate", "persist":
+			c.JournalMode = mode
+		default:
+			return fmt.Errorf("journal_mode '%s' must be one of: wal, delete, truncate or persist", value)
+		}
+	case "readonly":
+		switch strings.ToLower(value) {
+		case "yes", "true", "on":
+			c.ReadOnly, c.Immutable = true, false
+		case "no", "false", "off":
+			c.ReadOnly, c.Immutable = false, false
+		case "immutable":
+			c.ReadOnly, c.Immutable = true, true
+		default:
+			return fmt.Errorf("readonly '%s' must be one of: yes, no or immutable", value)
+		}
+	case "server":
+		c.Server = value
+	case "token":
+		c.Token = value
+	default:
+		return fmt.Errorf("unknown setting '%s'", key)
+	}
+	return nil
+}
+
+// CurrentUser returns the name recorded against changes made to the
+// database. This is the 'author' setting from the configuration file
+// if there is one, or otherwise the login name of the user running the
+// program.
+func CurrentUser() string {
+	if Settings.Author != "" {
+		return Settings.Author
+	}
+	for _, env := range []string{"USER", "USERNAME"} {
+		if name := os.Getenv(env); name != "" {
+			return name
+		}
+	}
+	if u, err := user.Current(); err == nil && u.Username != "" {
+		return u.Username
+	}
+	return "unknown"
+}
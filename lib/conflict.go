@@ -0,0 +1,145 @@
+// amt - program to access an SQLite database and lookup acronyms
+//
+// author:	Simon Rowe <simon@wiremoons.com>
+// license: open-source released under The MIT License (MIT).
+//
+// Package used to handle conflicting edits to acronym records for
+// application 'amt'
+//
+// Each acronym record carries a 'Version' counter that is increased by
+// every change made to it. An edit is only saved if the record is still
+// at the version the edit started from - otherwise another user has
+// changed it in the meantime, and the user is asked whether to merge
+// the two sets of changes, overwrite the other user's change, or abort.
+
+package lib
+
+import (
+	"fmt"
+	"strings"
+)
+
+// ConflictError is returned by UpdateRecord when the record being saved
+// has been changed by someone else since it was read. 'Yours' holds the
+// values being saved and 'Theirs' the values now held in the database.
+type ConflictError struct {
+	Yours  Record
+	Theirs Record
+}
+
+// Error describes the conflicting change.
+func (e *ConflictError) Error() string {
+	return fmt.Sprintf("ERROR: acronym ID '%d' has been changed by %s since you started (now version %d, your change was to version %d)",
+		e.Theirs.ID, e.Theirs.UpdatedBy, e.Theirs.Version, e.Yours.Version)
+}
+
+// editField is a single value of an acronym record that can be changed
+// by an edit, paired with the name shown to the user.
+type editField struct {
+	name  string
+	value *string
+}
+
+// editFields returns the values of the acronym record 'r' that can be
+// changed by an edit, in display order.
+func editFields(r *Record) []editField {
+	return []editField{
+		{"ACRONYM", &r.Acronym},
+		{"EXPANDED", &r.Definition},
+		{"DESCRIPTION", &r.Description},
+		{"SOURCE", &r.Source},
+	}
+}
+
+// mergeRecords combines the changes made in 'yours' and 'theirs', both
+// edited from the same earlier version 'base'. A value changed in only
+// one of them takes that change. The names of the values changed
+// differently in both are returned in 'clashes' - these keep the value
+// from 'yours' until the user chooses. The merged record carries the
+// version of 'theirs', so it can be saved over it.
+func mergeRecords(base, yours, theirs Record) (merged Record, clashes []string) {
+	merged = theirs
+	baseFields, yourFields, mergedFields := editFields(&base), editFields(&yours), editFields(&merged)
+	for i, f := range mergedFields {
+		mine, was := *yourFields[i].value, *baseFields[i].value
+		switch {
+		case mine == was || mine == *f.value:
+			// unchanged by you, or both made the same change
+		case *f.value == was:
+			*f.value = mine
+		default:
+			*f.value = mine
+			clashes = append(clashes, f.name)
+		}
+	}
+	return merged, clashes
+}
+
+// resolveConflict shows the user both versions of a record that was
+// changed by someone else while they were editing it, and asks how to
+// continue. 'base' is the version the edit started from. The record to
+// save is returned, or 'false' if the user chose to abort the edit.
+func resolveConflict(base Record, c *ConflictError) (Record, bool) {
+	fmt.Printf("\n%v\n\n", c)
+	saved := ""
+	if !c.Theirs.UpdatedAt.IsZero() {
+		saved = " " + c.Theirs.UpdatedAt.Local().Format("2006-01-02 15:04")
+	}
+	fmt.Printf("Their version - saved%s by %s:\n\n", saved, c.Theirs.UpdatedBy)
+	printRecord(c.Theirs)
+	fmt.Printf("Your version:\n\n")
+	for _, f := range editFields(&c.Yours) {
+		fmt.Printf("%s: %s\n", f.name, *f.value)
+	}
+
+	for {
+		answer, err := readInput("\nChoose to [m]erge both changes, [o]verwrite their change with yours, or [a]bort: ")
+		if err != nil {
+			return Record{}, false
+		}
+		switch strings.ToLower(strings.TrimSpace(answer)) {
+		case "m", "merge":
+			return mergeConflict(base, c), true
+		case "o", "overwrite":
+			// an edit does not change tags - so keep any they added
+			yours := c.Yours
+			yours.Version, yours.Tags = c.Theirs.Version, c.Theirs.Tags
+			return yours, true
+		case "a", "abort":
+			return Record{}, false
+		}
+		fmt.Println("Please enter one of: m, o or a")
+	}
+}
+
+// mergeConflict merges the two versions of a record held in 'c', asking
+// the user to pick a value wherever both changed the same one.
+func mergeConflict(base Record, c *ConflictError) Record {
+	merged, clashes := mergeRecords(base, c.Yours, c.Theirs)
+	mergedFields, theirFields := editFields(&merged), editFields(&c.Theirs)
+	for _, name := range clashes {
+		i := indexOfField(mergedFields, name)
+		yours, theirs := mergedFields[i].value, *theirFields[i].value
+		fmt.Printf("\n%s was changed by both of you:\n\t[y] yours:  %s\n\t[t] theirs: %s\n", name, *yours, theirs)
+		answer, _ := readInput("Keep which value? [y/t]: ")
+		if strings.HasPrefix(strings.ToLower(strings.TrimSpace(answer)), "t") {
+			*yours = theirs
+		}
+	}
+	fmt.Printf("\nMerged record to save:\n\n")
+	for _, f := range mergedFields {
+		fmt.Printf("%s: %s\n", f.name, *f.value)
+	}
+	return merged
+}
+
+// indexOfField returns the position of the field called 'name' in
+// 'fields'.
+func indexOfField(fields []editField, name string) int {
+	for i, f := range fields {
+		if f.name == name {
+			return i
+		}
+	}
+	return -1
+}
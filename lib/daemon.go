@@ -0,0 +1,596 @@
+// amt - program to access an SQLite database and lookup acronyms
+//
+// author:	Simon Rowe <simon@wiremoons.com>
+// license: open-source released under The MIT License (MIT).
+//
+// Package used to answer searches from memory over a Unix socket for
+// application 'amt'
+//
+// 'amt daemon' reads every acronym into memory, reads them again
+// whenever the database changes, and answers searches on a Unix socket
+// kept for each user and database. A search run from the command line
+// - or by 'wtf' - uses the daemon when one is running for its database,
+// so it does not need to open the database itself.
+//
+// Each request is a single line, answered with 'OK <count>' followed by
+// that many JSON acronym records, one per line, or with 'ERR <message>':
+//
+//	FIND <acronym>    acronyms matching, with the '%' and '_' wildcards
+//	WILD <text>       acronyms or expansions containing the text
+//	GET <id|uuid>     the acronym record with the ID or UUID
+//	STATS             the database and the number of acronyms held
+//	PING              check the daemon is running
+//	QUIT              close the connection
+
+package lib
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+	"unicode/utf8"
+
+	"github.com/dustin/go-humanize"
+)
+
+// daemonDialTimeout is the longest wait to connect to a daemon, before
+// the database is opened directly instead.
+const daemonDialTimeout = 250 * time.Millisecond
+
+// daemonTimeout is the longest wait for the daemon to answer a request.
+const daemonTimeout = 5 * time.Second
+
+// daemonPoll is how often the daemon checks if the database changed.
+const daemonPoll = time.Second
+
+// daemonIdleTimeout is how long a client may wait between requests
+// before the connection is closed.
+const daemonIdleTimeout = time.Minute
+
+// daemonMaxLine is the longest request accepted from a client.
+const daemonMaxLine = 4096
+
+// errDaemonSearches is returned for anything but a search when using a
+// daemon - only searches are sent to one.
+var errDaemonSearches = errors.New("ERROR: the amt daemon only answers searches")
+
+// daemonStats describes the acronyms held by a daemon.
+type daemonStats struct {
+	Database    string    `json:"database"`
+	Records     int64     `json:"records"`
+	LastAcronym string    `json:"last_acronym"`
+	SqlVersion  string    `json:"sqlite_version"`
+	LoadedAt    time.Time `json:"loaded_at"`
+}
+
+// daemonIndex holds every acronym record in the order FindRecords
+// returns them, along with their positions by acronym in upper case.
+type daemonIndex struct {
+	records   []Record
+	byAcronym map[string][]int
+	stats     daemonStats
+}
+
+// daemon holds the acronyms being served, and what was last seen of
+// the database they were read from.
+type daemon struct {
+	mu      sync.RWMutex
+	index   *daemonIndex
+	file    os.FileInfo
+	version int64
+}
+
+// daemonSocket returns the path of the Unix socket used by the daemon
+// for the database 'DbName', along with the database's full path. The
+// socket is kept in the user's runtime directory, or an 'amt' directory
+// in their cache directory - never a shared one, such as the temporary
+// directory, where another user could put a socket of their own.
+func daemonSocket() (socket, database string, err error) {
+	database, err = filepath.Abs(DbName)
+	if err != nil {
+		return "", "", fmt.Errorf("ERROR: unable to find the full path of database '%s': %v", DbName, err)
+	}
+	if real, err := filepath.EvalSymlinks(database); err == nil {
+		database = real
+	}
+	dir := os.Getenv("XDG_RUNTIME_DIR")
+	if dir == "" {
+		cache, err := os.UserCacheDir()
+		if err != nil {
+			return "", "", fmt.Errorf("ERROR: unable to find a private directory for the amt daemon socket: %v", err)
+		}
+		dir = filepath.Join(cache, Appname)
+	}
+	sum := sha256.Sum256([]byte(database))
+	return filepath.Join(dir, Appname+"-"+hex.EncodeToString(sum[:6])+".sock"), database, nil
+}
+
+// ServeDaemon answers searches for the acronyms in the open database
+// over a Unix socket until interrupted, reading them again whenever the
+// database changes.
+func ServeDaemon() error {
+	socket, database, err := daemonSocket()
+	if err != nil {
+		return err
+	}
+	if conn, err := net.DialTimeout("unix", socket, daemonDialTimeout); err == nil {
+		conn.Close()
+		return fmt.Errorf("ERROR: an amt daemon is already running for database '%s' on:  %s", database, socket)
+	}
+	if err = os.MkdirAll(filepath.Dir(socket), 0700); err != nil {
+		return fmt.Errorf("ERROR: unable to create the amt daemon socket directory: %v", err)
+	}
+	// remove the socket left by a daemon that did not stop cleanly
+	os.Remove(socket)
+
+	d := &daemon{}
+	if err = d.reload(database); err != nil {
+		return err
+	}
+	listener, err := net.Listen("unix", socket)
+	if err != nil {
+		return fmt.Errorf("ERROR: unable to start the amt daemon: %v", err)
+	}
+	if err = os.Chmod(socket, 0600); err != nil {
+		listener.Close()
+		return fmt.Errorf("ERROR: unable to protect the amt daemon socket: %v", err)
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	go func() {
+		<-ctx.Done()
+		listener.Close()
+	}()
+	go d.watch(ctx, database)
+
+	fmt.Printf("\namt daemon holding %s acronyms, listening on:  %s  - press Ctrl-C to stop\n",
+		humanize.Comma(int64(len(d.index.records))), socket)
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			if ctx.Err() != nil {
+				fmt.Println("\namt daemon stopped")
+				return nil
+			}
+			return fmt.Errorf("ERROR: amt daemon failed: %v", err)
+		}
+		go d.serve(conn)
+	}
+}
+
+// reload reads every acronym in the database into a new index, then
+// swaps it for the one being served.
+func (d *daemon) reload(database string) error {
+	records, err := FindRecords(SearchQuery{})
+	if err != nil {
+		return err
+	}
+	idx := &daemonIndex{
+		records:   records,
+		byAcronym: make(map[string][]int, len(records)),
+		stats: daemonStats{
+			Database:    database,
+			Records:     CheckCount(),
+			LastAcronym: LastAcronym(),
+			SqlVersion:  SqlVersion(),
+			LoadedAt:    time.Now().UTC().Truncate(time.Second),
+		},
+	}
+	for i, r := range records {
+		key := asciiUpper(r.Acronym)
+		idx.byAcronym[key] = append(idx.byAcronym[key], i)
+	}
+	d.mu.Lock()
+	d.index = idx
+	d.mu.Unlock()
+	if DebugSwitch {
+		log.Printf("DEBUG: amt daemon read %d acronyms\n", len(records))
+	}
+	return nil
+}
+
+// watch checks the database every 'daemonPoll' until 'ctx' is done,
+// reading the acronyms again once it has changed. A change saved by any
+// other connection alters SQLite's 'data_version', and a database file
+// replaced by another is opened again.
+func (d *daemon) watch(ctx context.Context, database string) {
+	ticker := time.NewTicker(daemonPoll)
+	defer ticker.Stop()
+	var conn *sql.Conn
+	defer func() {
+		if conn != nil {
+			conn.Close()
+		}
+	}()
+	for {
+		changed := false
+		fi, err := os.Stat(DbName)
+		if err == nil && d.file != nil && !os.SameFile(fi, d.file) {
+			if DebugSwitch {
+				log.Printf("DEBUG: amt daemon found database '%s' replaced - opening it again\n", DbName)
+			}
+			if conn != nil {
+				conn.Close()
+				conn = nil
+			}
+			CloseDataBase()
+			if err = OpenDataBase(); err != nil {
+				log.Println(err)
+				return
+			}
+			changed = true
+		}
+		if err == nil {
+			d.file = fi
+		}
+
+		if conn == nil {
+			// 'data_version' is only comparable on the same connection
+			if conn, err = DB.Conn(ctx); err != nil {
+				if ctx.Err() == nil {
+					log.Printf("ERROR: amt daemon unable to watch the database: %v\n", err)
+				}
+				return
+			}
+			d.version = -1
+		}
+		var version int64
+		if err = conn.QueryRowContext(ctx, "pragma data_version;").Scan(&version); err == nil {
+			if d.version >= 0 && version != d.version {
+				changed = true
+			}
+			d.version = version
+		}
+
+		if changed {
+			if err = d.reload(database); err != nil {
+				log.Println(err)
+			} else {
+				fmt.Printf("Database changed - acronyms read again:  %s held\n", humanize.Comma(int64(len(d.index.records))))
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// serve answers the requests sent on the connection 'conn' until the
+// client quits or goes quiet.
+func (d *daemon) serve(conn net.Conn) {
+	defer conn.Close()
+	in := bufio.NewScanner(conn)
+	in.Buffer(make([]byte, daemonMaxLine), daemonMaxLine)
+	out := bufio.NewWriter(conn)
+	for {
+		out.Flush()
+		conn.SetReadDeadline(time.Now().Add(daemonIdleTimeout))
+		if !in.Scan() {
+			return
+		}
+		line := strings.TrimSpace(in.Text())
+		if line == "" {
+			continue
+		}
+		name, arg := line, ""
+		if i := strings.IndexAny(line, " \t"); i >= 0 {
+			name, arg = line[:i], strings.TrimSpace(line[i+1:])
+		}
+		if DebugSwitch {
+			log.Printf("DEBUG: amt daemon request: %q\n", line)
+		}
+
+		d.mu.RLock()
+		idx := d.index
+		d.mu.RUnlock()
+		var results []interface{}
+		switch strings.ToUpper(name) {
+		case "FIND":
+			results = idx.find(arg, false)
+		case "WILD":
+			results = idx.find(arg, true)
+		case "GET":
+			results = idx.get(arg)
+		case "STATS":
+			results = []interface{}{idx.stats}
+		case "PING":
+		case "QUIT":
+			fmt.Fprintf(out, "OK 0\n")
+			out.Flush()
+			return
+		default:
+			fmt.Fprintf(out, "ERR unknown request '%s'\n", name)
+			continue
+		}
+
+		fmt.Fprintf(out, "OK %d\n", len(results))
+		for _, r := range results {
+			b, err := json.Marshal(r)
+			if err != nil {
+				b = []byte("{}")
+			}
+			out.Write(b)
+			out.WriteByte('\n')
+		}
+	}
+}
+
+// find returns the acronym records whose acronym matches 'term' in the
+// same way as FindRecords - or, if 'wild' is set, those whose acronym
+// or expansion contains it.
+func (idx *daemonIndex) find(term string, wild bool) []interface{} {
+	var results []interface{}
+	if term == "" {
+		term = "%"
+	}
+	if !wild && !strings.ContainsAny(term, "%_") {
+		for _, i := range idx.byAcronym[asciiUpper(term)] {
+			results = append(results, idx.records[i])
+		}
+		return results
+	}
+	pattern := asciiUpper(term)
+	if wild {
+		pattern = "%" + pattern + "%"
+	}
+	for _, r := range idx.records {
+		if likeMatch(pattern, asciiUpper(r.Acronym)) || (wild && likeMatch(pattern, asciiUpper(r.Definition))) {
+			results = append(results, r)
+		}
+	}
+	return results
+}
+
+// get returns the acronym record with the ID or UUID 'value', if held.
+func (idx *daemonIndex) get(value string) []interface{} {
+	id, err := strconv.ParseInt(value, 10, 64)
+	for _, r := range idx.records {
+		if (err == nil && r.ID == id) || (err != nil && strings.EqualFold(r.UUID, value)) {
+			return []interface{}{r}
+		}
+	}
+	return nil
+}
+
+// asciiUpper returns 's' with the letters 'a' to 'z' in upper case - as
+// SQLite's 'like' operator ignores case only for these.
+func asciiUpper(s string) string {
+	return strings.Map(func(c rune) rune {
+		if c >= 'a' && c <= 'z' {
+			return c - 'a' + 'A'
+		}
+		return c
+	}, s)
+}
+
+// likeMatch returns true if 's' matches the SQL 'like' pattern
+// 'pattern', where '%' matches any run of characters and '_' any single
+// character.
+func likeMatch(pattern, s string) bool {
+	// the positions to return to when a character after the last '%'
+	// does not match
+	star, retry := -1, 0
+	p, i := 0, 0
+	for i < len(s) {
+		c, size := utf8.DecodeRuneInString(s[i:])
+		if p < len(pattern) {
+			pc, psize := utf8.DecodeRuneInString(pattern[p:])
+			switch {
+			case pc == '%':
+				star, retry = p+psize, i
+				p += psize
+				continue
+			case pc == '_' || pc == c:
+				p += psize
+				i += size
+				continue
+			}
+		}
+		if star < 0 {
+			return false
+		}
+		_, size = utf8.DecodeRuneInString(s[retry:])
+		retry += size
+		p, i = star, retry
+	}
+	for p < len(pattern) && pattern[p] == '%' {
+		p++
+	}
+	return p == len(pattern)
+}
+
+// daemonStore answers searches from an amt daemon in place of the
+// local database.
+type daemonStore struct {
+	conn net.Conn
+	in   *bufio.Reader
+}
+
+// OpenDaemon connects to the amt daemon running for the database
+// 'DbName', if there is one, so 'Storage' sends searches to it rather
+// than the database being opened. The details of the acronyms it holds
+// are shown as OpenDataBase would. An error is returned if no daemon is
+// running, or it can not be used.
+func OpenDaemon() error {
+	socket, database, err := daemonSocket()
+	if err != nil {
+		return err
+	}
+	conn, err := net.DialTimeout("unix", socket, daemonDialTimeout)
+	if err != nil {
+		if DebugSwitch {
+			log.Printf("DEBUG: no amt daemon running on '%s': %v\n", socket, err)
+		}
+		return err
+	}
+	s := &daemonStore{conn: conn, in: bufio.NewReader(conn)}
+	var st daemonStats
+	lines, err := s.call("STATS")
+	if err == nil && (len(lines) != 1 || json.Unmarshal(lines[0], &st) != nil) {
+		err = fmt.Errorf("ERROR: the amt daemon sent an invalid reply to 'STATS'")
+	}
+	if err == nil && st.Database != database {
+		err = fmt.Errorf("ERROR: the amt daemon on '%s' holds database '%s', not '%s'", socket, st.Database, database)
+	}
+	if err != nil {
+		conn.Close()
+		if DebugSwitch {
+			log.Println("DEBUG:", err)
+		}
+		return err
+	}
+	if DebugSwitch {
+		log.Printf("DEBUG: using the amt daemon on '%s' for searches\n", socket)
+	}
+
+	Storage = s
+	RecCount = st.Records
+	if Quiet {
+		return nil
+	}
+	fmt.Println("Database connection status:  √  (amt daemon)")
+	fmt.Printf("SQLite3 Database Version:  %s\n", st.SqlVersion)
+	fmt.Printf("Current record count is:  %s\n", humanize.Comma(RecCount))
+	fmt.Printf("Last acronym entered was:  '%s'\n", st.LastAcronym)
+	return nil
+}
+
+// call sends the request 'request' to the daemon, returning the lines
+// of its reply.
+func (s *daemonStore) call(request string) ([]json.RawMessage, error) {
+	if strings.ContainsAny(request, "\r\n") {
+		return nil, fmt.Errorf("ERROR: an amt daemon request can not span several lines")
+	}
+	s.conn.SetDeadline(time.Now().Add(daemonTimeout))
+	if _, err := fmt.Fprintf(s.conn, "%s\n", request); err != nil {
+		return nil, fmt.Errorf("ERROR: unable to send request to the amt daemon: %v", err)
+	}
+	status, err := s.in.ReadString('\n')
+	if err != nil {
+		return nil, fmt.Errorf("ERROR: unable to read the reply from the amt daemon: %v", err)
+	}
+	status = strings.TrimSpace(status)
+	if strings.HasPrefix(status, "ERR ") {
+		return nil, fmt.Errorf("ERROR: the amt daemon refused the request: %s", status[4:])
+	}
+	n, err := strconv.Atoi(strings.TrimPrefix(status, "OK "))
+	if !strings.HasPrefix(status, "OK ") || err != nil || n < 0 {
+		return nil, fmt.Errorf("ERROR: the amt daemon sent an invalid reply: %q", status)
+	}
+	lines := make([]json.RawMessage, n)
+	for i := range lines {
+		line, err := s.in.ReadBytes('\n')
+		if err != nil {
+			return nil, fmt.Errorf("ERROR: unable to read the reply from the amt daemon: %v", err)
+		}
+		lines[i] = line
+	}
+	return lines, nil
+}
+
+// records sends the request 'request' to the daemon, returning the
+// acronym records in its reply.
+func (s *daemonStore) records(request string) ([]Record, error) {
+	lines, err := s.call(request)
+	if err != nil {
+		return nil, err
+	}
+	var records []Record
+	for _, line := range lines {
+		var r Record
+		if err := json.Unmarshal(line, &r); err != nil {
+			return nil, fmt.Errorf("ERROR: reading acronym record from the amt daemon: %v", err)
+		}
+		records = append(records, r)
+	}
+	return records, nil
+}
+
+// FindRecords returns every acronym record matching 'q'. The daemon
+// matches the term, and the records are then checked against the rest
+// of the query here.
+func (s *daemonStore) FindRecords(q SearchQuery) ([]Record, error) {
+	request := "FIND " + q.Term
+	if q.Wild {
+		request = "WILD " + q.Term
+	}
+	records, err := s.records(request)
+	if err != nil {
+		return nil, err
+	}
+	var found []Record
+	for _, r := range records {
+		if daemonMatches(q, r) {
+			found = append(found, r)
+		}
+	}
+	return found, nil
+}
+
+// daemonMatches returns true if the acronym record 'r' meets the parts
+// of the query 'q' other than its term, in the same way as FindRecords.
+func daemonMatches(q SearchQuery, r Record) bool {
+	for _, tag := range q.Tags {
+		held := false
+		for _, t := range r.Tags {
+			held = held || t == normaliseTag(tag)
+		}
+		if !held {
+			return false
+		}
+	}
+	switch {
+	case !q.AddedSince.IsZero() && r.CreatedAt.Before(q.AddedSince.Truncate(time.Second)),
+		!q.ChangedSince.IsZero() && r.UpdatedAt.Before(q.ChangedSince.Truncate(time.Second)),
+		q.Author != "" && r.CreatedBy != q.Author && r.UpdatedBy != q.Author,
+		q.Source != "" && !strings.EqualFold(r.Source, q.Source):
+		return false
+	}
+	return true
+}
+
+// LookupRecord returns the acronym record with the ID or UUID 'value'.
+func (s *daemonStore) LookupRecord(value string) (Record, error) {
+	records, err := s.records("GET " + value)
+	if err != nil {
+		return Record{}, err
+	}
+	if len(records) == 0 {
+		return Record{}, sql.ErrNoRows
+	}
+	return records[0], nil
+}
+
+// Only searches are sent to a daemon - everything else needs the
+// database to be opened.
+func (s *daemonStore) InsertRecord(r *Record) error         { return errDaemonSearches }
+func (s *daemonStore) UpdateRecord(r *Record) error         { return errDaemonSearches }
+func (s *daemonStore) DeleteRecord(id int64) (int64, error) { return 0, errDaemonSearches }
+func (s *daemonStore) ListTrash() ([]TrashItem, error)      { return nil, errDaemonSearches }
+func (s *daemonStore) RestoreRecord(int64) (Record, error)  { return Record{}, errDaemonSearches }
+func (s *daemonStore) RecordHistory(string) (int64, []AuditEntry, error) {
+	return 0, nil, errDaemonSearches
+}
+func (s *daemonStore) TagRecord(int64, []string) (int64, error)   { return 0, errDaemonSearches }
+func (s *daemonStore) UntagRecord(int64, []string) (int64, error) { return 0, errDaemonSearches }
+func (s *daemonStore) ListSources() ([]Source, error)             { return nil, errDaemonSearches }
+func (s *daemonStore) ListTags() ([]Tag, error)                   { return nil, errDaemonSearches }
+func (s *daemonStore) GetStats() (Stats, error)                   { return Stats{}, errDaemonSearches }
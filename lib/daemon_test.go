@@ -0,0 +1,62 @@
+// amt - program to access an SQLite database and lookup acronyms
+//
+// author:	Simon Rowe <simon@wiremoons.com>
+// license: open-source released under The MIT License (MIT).
+//
+// Package used to test the searches answered by the amt daemon for
+// application 'amt'
+
+package lib
+
+import "testing"
+
+func TestLikeMatch(t *testing.T) {
+	tests := []struct {
+		pattern, s string
+		want       bool
+	}{
+		{"", "", true},
+		{"", "SNI", false},
+		{"SNI", "SNI", true},
+		{"SNI", "SN", false},
+		{"SN", "SNI", false},
+		{"SNI", "sni", false},
+		{"%", "", true},
+		{"%", "SNI", true},
+		{"%%", "SNI", true},
+		{"S%", "SNI", true},
+		{"S%", "TLA", false},
+		{"%I", "SNI", true},
+		{"%N%", "SNI", true},
+		{"%X%", "SNI", false},
+		{"S%I", "SI", true},
+		{"A%B%C", "AXXBXXC", true},
+		{"A%B%C", "AXXBXX", false},
+		{"%AB", "AAB", true},
+		{"%ABC", "ABABC", true},
+		{"_", "", false},
+		{"_", "S", true},
+		{"S_I", "SNI", true},
+		{"S_", "SNI", false},
+		{"___", "SNI", true},
+		{"_%", "SNI", true},
+		{"%_", "", false},
+		// multi-byte characters are matched by '_' as a single character
+		{"_", "é", true},
+		{"__", "é", false},
+		{"CAF_", "CAFé", true},
+		{"%é", "CAFé", true},
+		{"CAF%", "CAFé", true},
+		{"日本語", "日本語", true},
+		{"日本%", "日本語", true},
+		{"_本_", "日本語", true},
+		{"%本", "日本語", false},
+		{"%語", "日本語", true},
+		{"é%é", "éxé", true},
+	}
+	for _, tt := range tests {
+		if got := likeMatch(tt.pattern, tt.s); got != tt.want {
+			t.Errorf("likeMatch(%q, %q) = %v, want %v", tt.pattern, tt.s, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,624 @@
+// amt - program to access an SQLite database and lookup acronyms
+//
+// author:	Simon Rowe <simon@wiremoons.com>
+// license: open-source released under The MIT License (MIT).
+//
+// Package used to answer DICT protocol clients for application 'amt'
+//
+// The DICT protocol (RFC 2229) is spoken by the 'dict' command and many
+// editor and desktop dictionary plugins. When run as a DICT server the
+// acronym database is offered as one DICT database for each source,
+// plus 'unsourced' for acronyms without one, so existing tools can look
+// up the shared glossary over the network.
+
+package lib
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"os/signal"
+	"strings"
+	"sync/atomic"
+	"time"
+	"unicode"
+
+	"github.com/dustin/go-humanize"
+)
+
+// dictDefaultAddress is the address the DICT server listens on unless
+// another is given - port 2628 is the one assigned to DICT.
+const dictDefaultAddress = "localhost:2628"
+
+// dictIdleTimeout is how long a client may wait between commands before
+// the connection is closed.
+const dictIdleTimeout = 10 * time.Minute
+
+// dictMaxLine is the longest command line accepted from a client.
+const dictMaxLine = 1024
+
+// dictNoSource is the name of the DICT database holding the acronyms
+// with no source.
+const dictNoSource = "unsourced"
+
+// dictDatabase holds a single source of acronyms, as offered to DICT
+// clients.
+type dictDatabase struct {
+	name        string
+	source      string
+	description string
+}
+
+// dictStrategy holds a single way of matching words offered to DICT
+// clients. 'pattern' returns the SQL 'like' pattern used to find the
+// candidate acronyms - an empty pattern reads every acronym - and
+// 'match' decides if each candidate matches 'word'.
+type dictStrategy struct {
+	name        string
+	description string
+	pattern     func(word string) string
+	match       func(word, acronym string) bool
+}
+
+// dictStrategies lists every match strategy offered, with the default
+// strategy - used for '.' - first.
+var dictStrategies = []dictStrategy{
+	{
+		name:        "lev",
+		description: "Match headwords within Levenshtein distance one",
+		pattern:     func(word string) string { return "" },
+		match: func(word, acronym string) bool {
+			return levenshtein(strings.ToUpper(word), strings.ToUpper(acronym)) <= 1
+		},
+	},
+	{
+		name:        "exact",
+		description: "Match headwords exactly",
+		pattern:     func(word string) string { return word },
+		match:       strings.EqualFold,
+	},
+	{
+		name:        "prefix",
+		description: "Match prefixes",
+		pattern:     func(word string) string { return word + "%" },
+		match: func(word, acronym string) bool {
+			return strings.HasPrefix(strings.ToUpper(acronym), strings.ToUpper(word))
+		},
+	},
+	{
+		name:        "substring",
+		description: "Match substring occurring anywhere in a headword",
+		pattern:     func(word string) string { return "%" + word + "%" },
+		match: func(word, acronym string) bool {
+			return strings.Contains(strings.ToUpper(acronym), strings.ToUpper(word))
+		},
+	},
+	{
+		name:        "soundex",
+		description: "Match using SOUNDEX algorithm",
+		pattern:     func(word string) string { return "" },
+		match: func(word, acronym string) bool {
+			code := soundex(word)
+			return code != "" && code == soundex(acronym)
+		},
+	},
+}
+
+// dictSession holds a single connection from a DICT client.
+type dictSession struct {
+	conn  net.Conn
+	in    *bufio.Scanner
+	out   *bufio.Writer
+	msgID string
+	mime  bool
+}
+
+// ServeDict answers DICT protocol clients connecting to 'address' - such
+// as 'localhost:2628', or ':2628' to accept clients from other
+// computers - until the program is interrupted with Ctrl-C.
+func ServeDict(address string) error {
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		return fmt.Errorf("ERROR: unable to start the DICT server: %v", err)
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	go func() {
+		<-ctx.Done()
+		listener.Close()
+	}()
+
+	fmt.Printf("\nDICT server listening on:  %s  - press Ctrl-C to stop\n", listener.Addr())
+	host, _ := os.Hostname()
+	var sessions int64
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			if ctx.Err() != nil {
+				fmt.Println("\nDICT server stopped")
+				return nil
+			}
+			return fmt.Errorf("ERROR: DICT server failed: %v", err)
+		}
+		id := atomic.AddInt64(&sessions, 1)
+		s := &dictSession{
+			conn:  conn,
+			in:    bufio.NewScanner(conn),
+			out:   bufio.NewWriter(conn),
+			msgID: fmt.Sprintf("<%d.%d@%s>", os.Getpid(), id, host),
+		}
+		s.in.Buffer(make([]byte, dictMaxLine), dictMaxLine)
+		go s.serve()
+	}
+}
+
+// serve answers the commands sent on the session's connection until the
+// client quits or goes quiet.
+func (s *dictSession) serve() {
+	defer s.conn.Close()
+	if DebugSwitch {
+		log.Printf("DEBUG: DICT client connected from %s\n", s.conn.RemoteAddr())
+	}
+	s.status(220, "%s acronym DICT server <mime> %s", Appname, s.msgID)
+	for {
+		s.out.Flush()
+		s.conn.SetReadDeadline(time.Now().Add(dictIdleTimeout))
+		if !s.in.Scan() {
+			return
+		}
+		args, err := dictParse(s.in.Text())
+		if err != nil {
+			s.status(501, "Syntax error, illegal parameters")
+			continue
+		}
+		if len(args) == 0 {
+			continue
+		}
+		if DebugSwitch {
+			log.Printf("DEBUG: DICT command from %s: %q\n", s.conn.RemoteAddr(), args)
+		}
+		if !s.command(args) {
+			s.out.Flush()
+			return
+		}
+	}
+}
+
+// command answers the single command 'args' - returning false if the
+// connection should be closed.
+func (s *dictSession) command(args []string) bool {
+	switch name := strings.ToUpper(args[0]); {
+	case name == "DEFINE" && len(args) == 3:
+		s.define(args[1], args[2])
+	case name == "MATCH" && len(args) == 4:
+		s.match(args[1], args[2], args[3])
+	case name == "SHOW" && len(args) >= 2:
+		s.show(args[1:])
+	case name == "CLIENT":
+		s.status(250, "ok")
+	case name == "OPTION" && len(args) == 2 && strings.EqualFold(args[1], "MIME"):
+		s.mime = true
+		s.status(250, "ok - using MIME headers")
+	case name == "STATUS":
+		s.status(210, "status ok")
+	case name == "HELP":
+		s.status(113, "help text follows")
+		s.text("DEFINE database word         -- look up word in database\n" +
+			"MATCH database strategy word -- match word in database using strategy\n" +
+			"SHOW DB                      -- list all accessible databases\n" +
+			"SHOW STRAT                   -- list available matching strategies\n" +
+			"SHOW INFO database           -- provide information about the database\n" +
+			"SHOW SERVER                  -- provide site-specific information\n" +
+			"OPTION MIME                  -- use MIME headers\n" +
+			"CLIENT info                  -- identify client to server\n" +
+			"STATUS                       -- display timing information\n" +
+			"HELP                         -- display this help information\n" +
+			"QUIT                         -- terminate connection")
+		s.status(250, "ok")
+	case name == "QUIT":
+		s.status(221, "bye")
+		return false
+	case name == "AUTH" || name == "SASLAUTH":
+		s.status(502, "Command not implemented")
+	case name == "DEFINE" || name == "MATCH" || name == "SHOW" || name == "OPTION":
+		s.status(501, "Syntax error, illegal parameters")
+	default:
+		s.status(500, "Syntax error, command not recognized")
+	}
+	return true
+}
+
+// define answers 'DEFINE database word' with every acronym exactly
+// matching 'word'.
+func (s *dictSession) define(database, word string) {
+	databases, first, ok := s.databases(database)
+	if !ok {
+		return
+	}
+	found, err := dictFind(databases, first, dictStrategies[1], word)
+	if err != nil {
+		s.serverError(err)
+		return
+	}
+	count := 0
+	for _, f := range found {
+		count += len(f.records)
+	}
+	if count == 0 {
+		s.status(552, "no match")
+		return
+	}
+	s.status(150, "%d definitions retrieved", count)
+	for _, f := range found {
+		for _, r := range f.records {
+			s.status(151, "%s %s %s", dictQuote(r.Acronym), f.db.name, dictQuote(f.db.description))
+			if s.mime {
+				s.text("Content-Type: text/plain; charset=utf-8\n\n" + dictArticle(r))
+			} else {
+				s.text(dictArticle(r))
+			}
+		}
+	}
+	s.status(250, "ok")
+}
+
+// match answers 'MATCH database strategy word' with the acronyms found
+// by the strategy named.
+func (s *dictSession) match(database, strategy, word string) {
+	var strat *dictStrategy
+	for i := range dictStrategies {
+		if strategy == "." || strings.EqualFold(strategy, dictStrategies[i].name) {
+			strat = &dictStrategies[i]
+			break
+		}
+	}
+	if strat == nil {
+		s.status(551, "invalid strategy, use \"SHOW STRAT\" for a list of strategies")
+		return
+	}
+	databases, first, ok := s.databases(database)
+	if !ok {
+		return
+	}
+	found, err := dictFind(databases, first, *strat, word)
+	if err != nil {
+		s.serverError(err)
+		return
+	}
+	var lines []string
+	for _, f := range found {
+		seen := make(map[string]bool)
+		for _, r := range f.records {
+			if !seen[r.Acronym] {
+				seen[r.Acronym] = true
+				lines = append(lines, f.db.name+" "+dictQuote(r.Acronym))
+			}
+		}
+	}
+	if len(lines) == 0 {
+		s.status(552, "no match")
+		return
+	}
+	s.status(152, "%d matches found", len(lines))
+	s.text(strings.Join(lines, "\n"))
+	s.status(250, "ok")
+}
+
+// show answers the SHOW commands.
+func (s *dictSession) show(args []string) {
+	switch what := strings.ToUpper(args[0]); {
+	case (what == "DB" || what == "DATABASES") && len(args) == 1:
+		databases, err := dictDatabases()
+		if err != nil {
+			s.serverError(err)
+			return
+		}
+		s.status(110, "%d databases present", len(databases))
+		var lines []string
+		for _, db := range databases {
+			lines = append(lines, db.name+" "+dictQuote(db.description))
+		}
+		s.text(strings.Join(lines, "\n"))
+		s.status(250, "ok")
+	case (what == "STRAT" || what == "STRATEGIES") && len(args) == 1:
+		s.status(111, "%d strategies present", len(dictStrategies))
+		var lines []string
+		for _, strat := range dictStrategies {
+			lines = append(lines, strat.name+" "+dictQuote(strat.description))
+		}
+		s.text(strings.Join(lines, "\n"))
+		s.status(250, "ok")
+	case what == "INFO" && len(args) == 2:
+		databases, _, ok := s.databases(args[1])
+		if !ok {
+			return
+		}
+		if len(databases) != 1 {
+			s.status(550, "invalid database, use \"SHOW DB\" for list of databases")
+			return
+		}
+		db := databases[0]
+		var count int64
+		err := DB.QueryRow(`select count(*) from ACRONYMS a left join SOURCES s on s.SourceID = a.SourceID
+			where coalesce(s.Name, '') = ?;`, db.source).Scan(&count)
+		if err != nil {
+			s.serverError(err)
+			return
+		}
+		s.status(112, "database information follows")
+		info := fmt.Sprintf("%s\n\n%s acronyms", db.description, humanize.Comma(count))
+		if sources, err := ListSources(); err == nil {
+			for _, src := range sources {
+				if src.Name == db.source && src.URL != "" {
+					info += "\n\n" + src.URL
+				}
+			}
+		}
+		s.text(info)
+		s.status(250, "ok")
+	case what == "SERVER" && len(args) == 1:
+		s.status(114, "server information follows")
+		s.text(fmt.Sprintf("%s version %s - acronym DICT server\n\n%s acronyms held", Appname, Appversion, humanize.Comma(CheckCount())))
+		s.status(250, "ok")
+	default:
+		s.status(501, "Syntax error, illegal parameters")
+	}
+}
+
+// databases returns the DICT databases named by 'name' - a single
+// database, '*' for all of them, or '!' for all of them searched only
+// until one holds a match, in which case 'first' is true. An error is
+// sent to the client, and 'ok' is false, if there is no such database.
+func (s *dictSession) databases(name string) (databases []dictDatabase, first, ok bool) {
+	all, err := dictDatabases()
+	if err != nil {
+		s.serverError(err)
+		return nil, false, false
+	}
+	switch name {
+	case "*":
+		return all, false, true
+	case "!":
+		return all, true, true
+	}
+	for _, db := range all {
+		if strings.EqualFold(db.name, name) {
+			return []dictDatabase{db}, false, true
+		}
+	}
+	s.status(550, "invalid database, use \"SHOW DB\" for list of databases")
+	return nil, false, false
+}
+
+// status sends a status line with the code 'code' to the client.
+func (s *dictSession) status(code int, format string, args ...interface{}) {
+	fmt.Fprintf(s.out, "%d %s\r\n", code, fmt.Sprintf(format, args...))
+}
+
+// text sends the lines of 'text' to the client, ended by a line holding
+// only a '.' - any line of the text starting with a '.' has it doubled.
+func (s *dictSession) text(text string) {
+	for _, line := range strings.Split(strings.TrimRight(text, "\n"), "\n") {
+		if strings.HasPrefix(line, ".") {
+			line = "." + line
+		}
+		fmt.Fprintf(s.out, "%s\r\n", strings.TrimRight(line, "\r"))
+	}
+	fmt.Fprint(s.out, ".\r\n")
+}
+
+// serverError reports a failure to read the database to the client and
+// the server log.
+func (s *dictSession) serverError(err error) {
+	log.Println(err)
+	s.status(420, "Server temporarily unavailable")
+}
+
+// dictFound holds the acronyms found in a single DICT database.
+type dictFound struct {
+	db      dictDatabase
+	records []Record
+}
+
+// dictFind returns the acronyms in each of 'databases' matching 'word'
+// with the strategy 'strat'. If 'first' is set only the first database
+// holding any matches is returned.
+func dictFind(databases []dictDatabase, first bool, strat dictStrategy, word string) ([]dictFound, error) {
+	records, err := FindRecords(SearchQuery{Term: strat.pattern(word)})
+	if err != nil {
+		return nil, err
+	}
+	var found []dictFound
+	for _, db := range databases {
+		f := dictFound{db: db}
+		for _, r := range records {
+			if strings.EqualFold(r.Source, db.source) && strat.match(word, r.Acronym) {
+				f.records = append(f.records, r)
+			}
+		}
+		if len(f.records) > 0 {
+			found = append(found, f)
+			if first {
+				break
+			}
+		}
+	}
+	return found, nil
+}
+
+// dictDatabases returns a DICT database for each source of acronyms,
+// and for the acronyms with no source. Each database is named by the
+// letters and digits of the source name - as a DICT database name can
+// not hold spaces - made unique with a number where needed.
+func dictDatabases() ([]dictDatabase, error) {
+	sources, err := ListSources()
+	if err != nil {
+		return nil, err
+	}
+	databases := []dictDatabase{}
+	used := map[string]int{dictNoSource: 1}
+	for _, src := range sources {
+		name := strings.Join(strings.FieldsFunc(strings.ToLower(src.Name), func(r rune) bool {
+			return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+		}), "-")
+		if name == "" {
+			name = "source"
+		}
+		used[name]++
+		if n := used[name]; n > 1 {
+			name = fmt.Sprintf("%s-%d", name, n)
+		}
+		description := src.Name
+		if src.Description != "" {
+			description += " - " + src.Description
+		}
+		databases = append(databases, dictDatabase{name: name, source: src.Name, description: description})
+	}
+	return append(databases, dictDatabase{name: dictNoSource, description: "Acronyms with no source"}), nil
+}
+
+// dictArticle returns the text sent to a DICT client for the acronym
+// record 'r'.
+func dictArticle(r Record) string {
+	text := r.Acronym + "\n    " + r.Definition
+	if r.Description != "" {
+		text += "\n\n    " + strings.ReplaceAll(r.Description, "\n", "\n    ")
+	}
+	if r.Source != "" {
+		text += "\n\n    Source: " + r.Source
+	}
+	if len(r.Tags) > 0 {
+		text += "\n    Tags: " + strings.Join(r.Tags, ", ")
+	}
+	return text
+}
+
+// dictParse splits a command line sent by a DICT client into its
+// words. A word containing spaces is enclosed in single or double
+// quotes, and a backslash includes the next character as it is.
+func dictParse(line string) ([]string, error) {
+	var words []string
+	var word strings.Builder
+	inWord := false
+	var quote rune
+	escaped := false
+	for _, c := range line {
+		switch {
+		case escaped:
+			word.WriteRune(c)
+			escaped = false
+		case c == '\\':
+			escaped, inWord = true, true
+		case quote != 0 && c == quote:
+			quote = 0
+		case quote != 0:
+			word.WriteRune(c)
+		case c == '"' || c == '\'':
+			quote, inWord = c, true
+		case c == ' ' || c == '\t' || c == '\r' || c == '\n':
+			if inWord {
+				words = append(words, word.String())
+				word.Reset()
+				inWord = false
+			}
+		default:
+			word.WriteRune(c)
+			inWord = true
+		}
+	}
+	if quote != 0 || escaped {
+		return nil, fmt.Errorf("unterminated quote or escape")
+	}
+	if inWord {
+		words = append(words, word.String())
+	}
+	return words, nil
+}
+
+// dictQuote returns 's' as a quoted DICT string.
+func dictQuote(s string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(s) + `"`
+}
+
+// soundex returns the American Soundex code of the letters in 'word' -
+// a letter followed by three digits - or an empty string if it holds no
+// letters.
+func soundex(word string) string {
+	codes := map[rune]byte{
+		'B': '1', 'F': '1', 'P': '1', 'V': '1',
+		'C': '2', 'G': '2', 'J': '2', 'K': '2', 'Q': '2', 'S': '2', 'X': '2', 'Z': '2',
+		'D': '3', 'T': '3',
+		'L': '4',
+		'M': '5', 'N': '5',
+		'R': '6',
+	}
+	var code []byte
+	var last byte
+	for _, c := range strings.ToUpper(word) {
+		if c < 'A' || c > 'Z' {
+			continue
+		}
+		digit := codes[c]
+		if len(code) == 0 {
+			code = append(code, byte(c))
+			last = digit
+			continue
+		}
+		switch {
+		case c == 'H' || c == 'W':
+			// these do not separate letters with the same code
+		case digit == 0:
+			last = 0
+		case digit != last:
+			code = append(code, digit)
+			last = digit
+		}
+		if len(code) == 4 {
+			break
+		}
+	}
+	if len(code) == 0 {
+		return ""
+	}
+	for len(code) < 4 {
+		code = append(code, '0')
+	}
+	return string(code[:4])
+}
+
+// levenshtein returns the number of single character insertions,
+// deletions or changes needed to turn 'a' into 'b'.
+func levenshtein(a, b string) int {
+	ra, rb := []rune(a), []rune(b)
+	prev := make([]int, len(rb)+1)
+	cur := make([]int, len(rb)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(ra); i++ {
+		cur[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			cur[j] = min3(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(rb)]
+}
+
+// min3 returns the smallest of 'a', 'b' and 'c'.
+func min3(a, b, c int) int {
+	if b < a {
+		a = b
+	}
+	if c < a {
+		a = c
+	}
+	return a
+}
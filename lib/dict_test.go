@@ -0,0 +1,102 @@
+// amt - program to access an SQLite database and lookup acronyms
+//
+// author:	Simon Rowe <simon@wiremoons.com>
+// license: open-source released under The MIT License (MIT).
+//
+// Package used to test the DICT protocol server for application 'amt'
+
+package lib
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestDictParse(t *testing.T) {
+	tests := []struct {
+		line string
+		want []string
+		err  bool
+	}{
+		{line: "DEFINE * SNI", want: []string{"DEFINE", "*", "SNI"}},
+		{line: "  MATCH\t*  prefix   tl \r\n", want: []string{"MATCH", "*", "prefix", "tl"}},
+		{line: `DEFINE * "Server Name"`, want: []string{"DEFINE", "*", "Server Name"}},
+		{line: `DEFINE * 'Server Name'`, want: []string{"DEFINE", "*", "Server Name"}},
+		{line: `DEFINE * "it's"`, want: []string{"DEFINE", "*", "it's"}},
+		{line: `DEFINE * 'say "hi"'`, want: []string{"DEFINE", "*", `say "hi"`}},
+		{line: `DEFINE * "a \"quoted\" word"`, want: []string{"DEFINE", "*", `a "quoted" word`}},
+		{line: `DEFINE * two\ words`, want: []string{"DEFINE", "*", "two words"}},
+		{line: `DEFINE * back\\slash`, want: []string{"DEFINE", "*", `back\slash`}},
+		{line: `DEFINE * ""`, want: []string{"DEFINE", "*", ""}},
+		{line: `DEFINE * pre"fix"ed`, want: []string{"DEFINE", "*", "prefixed"}},
+		{line: "DEFINE * café", want: []string{"DEFINE", "*", "café"}},
+		{line: "", want: nil},
+		{line: `DEFINE * "unterminated`, err: true},
+		{line: `DEFINE * 'unterminated`, err: true},
+		{line: `DEFINE * trailing\`, err: true},
+	}
+	for _, tt := range tests {
+		got, err := dictParse(tt.line)
+		if (err != nil) != tt.err {
+			t.Errorf("dictParse(%q) error = %v, want error %v", tt.line, err, tt.err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("dictParse(%q) = %q, want %q", tt.line, got, tt.want)
+		}
+	}
+}
+
+func TestSoundex(t *testing.T) {
+	tests := []struct {
+		word string
+		want string
+	}{
+		{"Robert", "R163"},
+		{"Rupert", "R163"},
+		{"robert", "R163"},
+		{"Rubin", "R150"},
+		{"Tymczak", "T522"},
+		{"Ashcraft", "A261"},
+		{"Ashcroft", "A261"},
+		{"Pfister", "P236"},
+		{"Honeyman", "H555"},
+		{"Lee", "L000"},
+		{"A", "A000"},
+		{"O'Hara", "O600"},
+		{"2FA", "F000"},
+		{"", ""},
+		{"123", ""},
+	}
+	for _, tt := range tests {
+		if got := soundex(tt.word); got != tt.want {
+			t.Errorf("soundex(%q) = %q, want %q", tt.word, got, tt.want)
+		}
+	}
+}
+
+func TestLevenshtein(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want int
+	}{
+		{"", "", 0},
+		{"SNI", "SNI", 0},
+		{"", "TLA", 3},
+		{"TLA", "", 3},
+		{"kitten", "sitting", 3},
+		{"flaw", "lawn", 2},
+		{"SNI", "SIN", 2},
+		{"HTTP", "HTTPS", 1},
+		{"café", "cafe", 1},
+		{"日本語", "日本", 1},
+	}
+	for _, tt := range tests {
+		if got := levenshtein(tt.a, tt.b); got != tt.want {
+			t.Errorf("levenshtein(%q, %q) = %d, want %d", tt.a, tt.b, got, tt.want)
+		}
+		if got := levenshtein(tt.b, tt.a); got != tt.want {
+			t.Errorf("levenshtein(%q, %q) = %d, want %d", tt.b, tt.a, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,452 @@
+// amt - program to access an SQLite database and lookup acronyms
+//
+// author:	Simon Rowe <simon@wiremoons.com>
+// license: open-source released under The MIT License (MIT).
+//
+// Package used to export the acronym glossary to files for
+// application 'amt'
+//
+// The whole glossary - every acronym record, source and tag - can be
+// written to a JSON or YAML file and read back to recreate the same
+// database. Records are written in ID order with no export time, so
+// exporting an unchanged database gives an identical file, and the
+// file can be kept under version control and changes reviewed.
+
+package lib
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// glossaryFormat and glossaryFormatVersion identify a file written by
+// ExportGlossary, and the version of its layout.
+const (
+	glossaryFormat        = "amt-glossary"
+	glossaryFormatVersion = 1
+)
+
+// Glossary holds the whole contents of the database, as written to and
+// read from an export file.
+type Glossary struct {
+	Format        string
+	FormatVersion int
+	SchemaVersion int
+	Sources       []Source
+	Tags          []string
+	Records       []Record
+}
+
+// glossaryFile is the layout of a JSON or YAML export file. It holds
+// the same values as Glossary, but with the records as glossaryRecord.
+type glossaryFile struct {
+	Format        string           `json:"format" yaml:"format"`
+	FormatVersion int              `json:"format_version" yaml:"format_version"`
+	SchemaVersion int              `json:"schema_version" yaml:"schema_version"`
+	Sources       []Source         `json:"sources" yaml:"sources"`
+	Tags          []string         `json:"tags" yaml:"tags"`
+	Records       []glossaryRecord `json:"records" yaml:"records"`
+}
+
+// glossaryRecord is an acronym record as held in a JSON or YAML export
+// file. Records added by early versions of amt have no created or
+// updated time, which are written as null - rather than as the zero
+// time - and read back as the zero time.
+type glossaryRecord struct {
+	ID          int64      `json:"id" yaml:"id"`
+	UUID        string     `json:"uuid" yaml:"uuid"`
+	Acronym     string     `json:"acronym" yaml:"acronym"`
+	Definition  string     `json:"definition" yaml:"definition"`
+	Description string     `json:"description" yaml:"description"`
+	Source      string     `json:"source" yaml:"source"`
+	Tags        []string   `json:"tags" yaml:"tags"`
+	CreatedAt   *time.Time `json:"created_at" yaml:"created_at"`
+	UpdatedAt   *time.Time `json:"updated_at" yaml:"updated_at"`
+	CreatedBy   string     `json:"created_by" yaml:"created_by"`
+	UpdatedBy   string     `json:"updated_by" yaml:"updated_by"`
+	Version     int64      `json:"version" yaml:"version"`
+}
+
+// newGlossaryFile returns the glossary 'g' laid out as written to a
+// JSON or YAML export file.
+func newGlossaryFile(g *Glossary) *glossaryFile {
+	f := &glossaryFile{Format: g.Format, FormatVersion: g.FormatVersion, SchemaVersion: g.SchemaVersion,
+		Sources: g.Sources, Tags: g.Tags, Records: make([]glossaryRecord, len(g.Records))}
+	for i, r := range g.Records {
+		f.Records[i] = glossaryRecord{ID: r.ID, UUID: r.UUID, Acronym: r.Acronym, Definition: r.Definition,
+			Description: r.Description, Source: r.Source, Tags: r.Tags, CreatedAt: filedTime(r.CreatedAt),
+			UpdatedAt: filedTime(r.UpdatedAt), CreatedBy: r.CreatedBy, UpdatedBy: r.UpdatedBy, Version: r.Version}
+	}
+	return f
+}
+
+// glossary returns the glossary held in the export file 'f'.
+func (f *glossaryFile) glossary() *Glossary {
+	g := &Glossary{Format: f.Format, FormatVersion: f.FormatVersion, SchemaVersion: f.SchemaVersion,
+		Sources: f.Sources, Tags: f.Tags, Records: make([]Record, len(f.Records))}
+	for i, r := range f.Records {
+		g.Records[i] = Record{ID: r.ID, UUID: r.UUID, Acronym: r.Acronym, Definition: r.Definition,
+			Description: r.Description, Source: r.Source, Tags: r.Tags, CreatedBy: r.CreatedBy,
+			UpdatedBy: r.UpdatedBy, Version: r.Version}
+		if r.CreatedAt != nil {
+			g.Records[i].CreatedAt = r.CreatedAt.UTC()
+		}
+		if r.UpdatedAt != nil {
+			g.Records[i].UpdatedAt = r.UpdatedAt.UTC()
+		}
+	}
+	return g
+}
+
+// filedTime returns the time 't' as held in an export file - nil if it
+// is the zero time.
+func filedTime(t time.Time) *time.Time {
+	if t.IsZero() {
+		return nil
+	}
+	return &t
+}
+
+// exportFormat holds a single file format the glossary can be exported
+// to, along with the file name extensions that select it.
+type exportFormat struct {
+	name        string
+	extensions  []string
+	description string
+	write       func(w io.Writer, g *Glossary) error
+	// writeFiles is set instead of 'write' for formats made up of
+	// several files, which it writes itself using the name given
+	writeFiles func(name string, g *Glossary) error
+	// match, if set, also selects the format for files named like
+	// those it is normally used for
+	match func(name string) bool
+}
+
+// exportFormats lists every format available to the export command.
+var exportFormats = []exportFormat{
+	{
+		name:        "json",
+		extensions:  []string{".json"},
+		description: "the whole glossary as JSON - can be imported again",
+		write:       writeGlossaryJSON,
+	},
+	{
+		name:        "yaml",
+		extensions:  []string{".yaml", ".yml"},
+		description: "the whole glossary as YAML - can be imported again",
+		write:       writeGlossaryYAML,
+	},
+	{
+		name:        "tbx",
+		extensions:  []string{".tbx"},
+		description: "TBX-Basic terms for terminology and translation tools",
+		write:       writeGlossaryTBX,
+	},
+	{
+		name:        "glossaries",
+		extensions:  []string{".tex"},
+		description: "\\newacronym definitions for the LaTeX glossaries package",
+		write:       writeGlossaryGlossaries,
+	},
+	{
+		name:        "acronym",
+		description: "\\acro definitions for the LaTeX acronym package",
+		write:       writeGlossaryAcronym,
+	},
+	{
+		name:        "html",
+		description: "static web site with an A-Z index and search - written to a directory",
+		writeFiles:  writeGlossarySite,
+		match:       isSiteDir,
+	},
+	{
+		name:        "stardict",
+		extensions:  []string{".ifo"},
+		description: "StarDict dictionary for GoldenDict and other readers - .ifo, .idx and .dict.dz files",
+		writeFiles:  writeGlossaryStarDict,
+	},
+	{
+		name:        "wtf",
+		match:       isWtfFile,
+		description: "acronyms file for the BSD wtf(6) command",
+		write:       writeGlossaryWtf,
+	},
+}
+
+// findExportFormat returns the export format called 'name', or if that
+// is empty the format used for files named like 'fileName'.
+func findExportFormat(name, fileName string) (exportFormat, error) {
+	ext := strings.ToLower(filepath.Ext(fileName))
+	for _, f := range exportFormats {
+		if name != "" {
+			if strings.EqualFold(f.name, name) {
+				return f, nil
+			}
+			continue
+		}
+		for _, e := range f.extensions {
+			if e == ext {
+				return f, nil
+			}
+		}
+	}
+	if name == "" {
+		for _, f := range exportFormats {
+			if f.match != nil && f.match(fileName) {
+				return f, nil
+			}
+		}
+	}
+	if name != "" {
+		return exportFormat{}, fmt.Errorf("ERROR: unknown export format '%s' - available formats are: %s", name, exportFormatNames())
+	}
+	return exportFormat{}, fmt.Errorf("ERROR: unable to tell the export format for file '%s' - set one with -format: %s", fileName, exportFormatNames())
+}
+
+// exportFormatNames returns the names of every export format, as a
+// list for help and error messages.
+func exportFormatNames() string {
+	names := make([]string, len(exportFormats))
+	for i, f := range exportFormats {
+		names[i] = f.name
+	}
+	return strings.Join(names, ", ")
+}
+
+// LoadGlossary reads every acronym record, source and tag held in the
+// database, or on the amt server in use, with the records in ID order.
+func LoadGlossary() (*Glossary, error) {
+	g := &Glossary{Format: glossaryFormat, FormatVersion: glossaryFormatVersion}
+	if Remote() {
+		return loadServerGlossary(g)
+	}
+	var err error
+	if g.SchemaVersion, err = SchemaVersion(); err != nil {
+		return nil, fmt.Errorf("ERROR: unable to read database schema version: %v", err)
+	}
+	if g.Sources, err = ListSources(); err != nil {
+		return nil, err
+	}
+	tags, err := ListTags()
+	if err != nil {
+		return nil, err
+	}
+	for _, t := range tags {
+		g.Tags = append(g.Tags, t.Name)
+	}
+
+	rows, err := DB.Query(recordQuery + " order by a.AcronymID;")
+	if err != nil {
+		return nil, fmt.Errorf("ERROR: unable to read acronym records: %v", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		r, err := scanRecord(rows)
+		if err != nil {
+			return nil, fmt.Errorf("ERROR: reading database record: %v", err)
+		}
+		g.Records = append(g.Records, r)
+	}
+	return g, rows.Err()
+}
+
+// loadServerGlossary reads every acronym record, source and tag held
+// on the amt server in use into 'g', with the records in ID order.
+func loadServerGlossary(g *Glossary) (*Glossary, error) {
+	st, err := Storage.GetStats()
+	if err != nil {
+		return nil, err
+	}
+	g.SchemaVersion = st.SchemaVersion
+	for _, t := range st.Tags {
+		g.Tags = append(g.Tags, t.Name)
+	}
+	if g.Sources, err = Storage.ListSources(); err != nil {
+		return nil, err
+	}
+	if g.Records, err = Storage.FindRecords(SearchQuery{}); err != nil {
+		return nil, err
+	}
+	sort.Slice(g.Records, func(i, j int) bool { return g.Records[i].ID < g.Records[j].ID })
+	return g, nil
+}
+
+// ExportOptions controls what ExportGlossary writes.
+type ExportOptions struct {
+	// Format is the name of the export format to use - if empty the
+	// format is chosen by the file name extension.
+	Format string
+	// UsedIn, if not empty, limits the export to the acronyms used in
+	// these LaTeX documents.
+	UsedIn []string
+}
+
+// ExportGlossary writes the glossary to the file called 'name', as set
+// by 'opts'. The file is only replaced once the export is complete. The
+// number of records written is returned.
+func ExportGlossary(name string, opts ExportOptions) (int, error) {
+	f, err := findExportFormat(opts.Format, name)
+	if err != nil {
+		return 0, err
+	}
+	g, err := LoadGlossary()
+	if err != nil {
+		return 0, err
+	}
+	if len(opts.UsedIn) > 0 {
+		used := make(map[string]bool)
+		for _, doc := range opts.UsedIn {
+			if err = readUsedAcronyms(doc, used); err != nil {
+				return 0, err
+			}
+		}
+		g.Records = usedRecords(g.Records, used)
+	}
+	if f.writeFiles != nil {
+		err = f.writeFiles(name, g)
+	} else {
+		err = writeFileAtomic(name, func(w io.Writer) error { return f.write(w, g) })
+	}
+	if err != nil {
+		return 0, fmt.Errorf("ERROR: unable to export to '%s': %v", name, err)
+	}
+	return len(g.Records), nil
+}
+
+// readUsedAcronyms adds the keys of the acronyms used in the LaTeX
+// document called 'name' to 'used'.
+func readUsedAcronyms(name string, used map[string]bool) error {
+	f, err := os.Open(name)
+	if err != nil {
+		return fmt.Errorf("ERROR: unable to open LaTeX document: %v", err)
+	}
+	defer f.Close()
+	keys, err := UsedAcronyms(f)
+	if err != nil {
+		return fmt.Errorf("ERROR: unable to read LaTeX document '%s': %v", name, err)
+	}
+	for k := range keys {
+		used[k] = true
+	}
+	return nil
+}
+
+// writeFileAtomic writes the file called 'name' using 'write'. The
+// output goes to a temporary file in the same directory first, which
+// then replaces 'name' - so a failed export never leaves a partly
+// written file behind.
+func writeFileAtomic(name string, write func(w io.Writer) error) error {
+	tmp, err := os.CreateTemp(filepath.Dir(name), "."+filepath.Base(name)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if err = write(tmp); err != nil {
+		tmp.Close()
+		return err
+	}
+	// temporary files are only readable by their owner
+	if err = tmp.Chmod(0644); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err = tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), name)
+}
+
+// writeGlossaryJSON writes the glossary 'g' to 'w' as indented JSON.
+func writeGlossaryJSON(w io.Writer, g *Glossary) error {
+	enc := json.NewEncoder(w)
+	enc.SetEscapeHTML(false)
+	enc.SetIndent("", "  ")
+	return enc.Encode(newGlossaryFile(g))
+}
+
+// writeGlossaryYAML writes the glossary 'g' to 'w' as YAML.
+func writeGlossaryYAML(w io.Writer, g *Glossary) error {
+	enc := yaml.NewEncoder(w)
+	enc.SetIndent(2)
+	if err := enc.Encode(newGlossaryFile(g)); err != nil {
+		return err
+	}
+	return enc.Close()
+}
+
+// ReadGlossary reads a glossary written by ExportGlossary from 'r', in
+// the format 'format' - either 'json' or 'yaml'.
+func ReadGlossary(r io.Reader, format string) (*Glossary, error) {
+	var f glossaryFile
+	var err error
+	switch format {
+	case "json":
+		err = json.NewDecoder(r).Decode(&f)
+	case "yaml", "yml":
+		err = yaml.NewDecoder(r).Decode(&f)
+	default:
+		return nil, fmt.Errorf("ERROR: glossary files must be json or yaml, not '%s'", format)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("ERROR: unable to read glossary file: %v", err)
+	}
+	g := f.glossary()
+	if g.Format != glossaryFormat {
+		return nil, fmt.Errorf("ERROR: the file is not a glossary exported by %s", Appname)
+	}
+	if g.FormatVersion > glossaryFormatVersion {
+		return nil, fmt.Errorf("ERROR: the glossary file is version %d, newer than this version of %s supports (%d) - please upgrade %s",
+			g.FormatVersion, Appname, glossaryFormatVersion, Appname)
+	}
+	return g, nil
+}
+
+// ImportGlossary adds the sources, tags and acronym records held in the
+// glossary 'g' to the database, and returns a report of the outcome of
+// every record. New records keep the IDs, UUIDs, times, authors and
+// versions held in the glossary, so importing into an empty database
+// recreates the database the glossary was exported from. Records
+// already held - those with the same UUID - are handled as set by
+// 'opts.OnDuplicate', where 'update' replaces every value with that
+// held in the glossary.
+func ImportGlossary(g *Glossary, opts ImportOptions) (*ImportReport, error) {
+	if !opts.DryRun {
+		err := withTx(CurrentUser(), func(tx *dbTx) error {
+			for _, s := range g.Sources {
+				_, err := tx.Exec(`insert into SOURCES(Name, Description, URL) values(?,?,?)
+					on conflict(Name) do update set Description = excluded.Description, URL = excluded.URL;`,
+					strings.TrimSpace(s.Name), s.Description, s.URL)
+				if err != nil {
+					return fmt.Errorf("ERROR: unable to import source '%s': %v", s.Name, err)
+				}
+			}
+			for _, tag := range g.Tags {
+				if err := ValidateTag(tag); err != nil {
+					return err
+				}
+				if _, err := tx.Exec("insert or ignore into TAGS(Name) values(?);", normaliseTag(tag)); err != nil {
+					return fmt.Errorf("ERROR: unable to import tag '%s': %v", tag, err)
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	rows := make([]ImportRow, len(g.Records))
+	for i, r := range g.Records {
+		rows[i] = ImportRow{Row: i + 1, Record: r}
+	}
+	opts.KeepValues = true
+	return ImportRecords(rows, opts)
+}
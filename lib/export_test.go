@@ -0,0 +1,123 @@
+// amt - program to access an SQLite database and lookup acronyms
+//
+// author:	Simon Rowe <simon@wiremoons.com>
+// license: open-source released under The MIT License (MIT).
+//
+// Package used to test exporting the acronym glossary to files for
+// application 'amt'
+
+package lib
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+// loadTestGlossary returns the glossary held in the database, as read
+// by LoadGlossary. Source IDs are cleared, as they are not exported.
+func loadTestGlossary(t *testing.T) *Glossary {
+	t.Helper()
+	g, err := LoadGlossary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := range g.Sources {
+		g.Sources[i].ID = 0
+	}
+	return g
+}
+
+func TestExportImportGlossary(t *testing.T) {
+	var want *Glossary
+	files := make(map[string]*bytes.Buffer)
+	t.Run("export", func(t *testing.T) {
+		migrateTestDB(t)
+		sni := Record{Acronym: "SNI", Definition: "Server Name Indication", Source: "Networking", Tags: []string{"tls"}}
+		tla := Record{Acronym: "TLA", Definition: "Three Letter Acronym", Description: "an example"}
+		rfc := Record{Acronym: "RFC", Definition: "Request for Comments", Source: "IETF"}
+		for _, r := range []*Record{&sni, &tla, &rfc} {
+			if err := InsertRecord(r, "alice"); err != nil {
+				t.Fatal(err)
+			}
+		}
+		sni, err := GetRecord(sni.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		sni.Description = "TLS extension"
+		if err = UpdateRecord(&sni, "bob"); err != nil {
+			t.Fatal(err)
+		}
+		if _, err = DeleteRecord(tla.ID, "alice"); err != nil {
+			t.Fatal(err)
+		}
+		// a record added by an early version of amt, with no expanded
+		// version, times or authors
+		_, err = DB.Exec(`
+UPDATE SOURCES SET Description = 'Internet standards', URL = 'https://www.ietf.org/' WHERE Name = 'IETF';
+INSERT INTO ACRONYMS(UUID, Acronym) VALUES ('0b9a3f6e-6d1c-4c47-9a5e-2f1d7c3e8a10', 'AMT');`)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		want = loadTestGlossary(t)
+		for name, write := range map[string]func(*bytes.Buffer, *Glossary) error{
+			"json": func(b *bytes.Buffer, g *Glossary) error { return writeGlossaryJSON(b, g) },
+			"yaml": func(b *bytes.Buffer, g *Glossary) error { return writeGlossaryYAML(b, g) },
+		} {
+			files[name] = new(bytes.Buffer)
+			if err = write(files[name], want); err != nil {
+				t.Fatalf("export to %s error = %v", name, err)
+			}
+		}
+		if bytes.Contains(files["json"].Bytes(), []byte("0001-01-01")) {
+			t.Errorf("JSON export holds the zero time, want null for missing times:\n%s", files["json"])
+		}
+	})
+	if want == nil {
+		t.FailNow()
+	}
+
+	for _, format := range []string{"json", "yaml"} {
+		t.Run(format, func(t *testing.T) {
+			migrateTestDB(t)
+			setTestUser(t, "carol")
+			g, err := ReadGlossary(bytes.NewReader(files[format].Bytes()), format)
+			if err != nil {
+				t.Fatal(err)
+			}
+			report, err := ImportGlossary(g, ImportOptions{OnDuplicate: duplicateUpdate})
+			if err != nil {
+				t.Fatalf("ImportGlossary() error = %v", err)
+			}
+			if report.Inserted != len(want.Records) {
+				t.Errorf("ImportGlossary() inserted %d records, want %d: %+v", report.Inserted, len(want.Records), report.Results)
+			}
+			if got := loadTestGlossary(t); !reflect.DeepEqual(got, want) {
+				t.Errorf("imported glossary = %+v, want %+v", got, want)
+			}
+
+			// updating a record changed since puts back every value held
+			// in the glossary, rather than noting a new change
+			changed, err := GetRecord(want.Records[0].ID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			changed.Definition = "Server Name Identification"
+			if err = UpdateRecord(&changed, "carol"); err != nil {
+				t.Fatal(err)
+			}
+			if report, err = ImportGlossary(g, ImportOptions{OnDuplicate: duplicateUpdate}); err != nil {
+				t.Fatalf("ImportGlossary() again error = %v", err)
+			}
+			if report.Updated != 1 || report.Skipped != len(want.Records)-1 {
+				t.Errorf("ImportGlossary() again updated %d and skipped %d records, want 1 and %d",
+					report.Updated, report.Skipped, len(want.Records)-1)
+			}
+			if got := loadTestGlossary(t); !reflect.DeepEqual(got, want) {
+				t.Errorf("glossary updated by import = %+v, want %+v", got, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,565 @@
+// amt - program to access an SQLite database and lookup acronyms
+//
+// author:	Simon Rowe <simon@wiremoons.com>
+// license: open-source released under The MIT License (MIT).
+//
+// Package used to import acronym records in bulk from files for
+// application 'amt'
+//
+// Each row of an import file is validated and added as a new acronym
+// record, or matched to a record already held - by its UUID, or by the
+// same acronym and expanded version. Rows are saved in batches, each in
+// its own transaction, and the outcome of every row is recorded in an
+// ImportReport.
+
+package lib
+
+import (
+	"database/sql"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/dustin/go-humanize"
+)
+
+// The ways an import can handle a row that matches a record already
+// held in the database.
+const (
+	duplicateSkip   = "skip"
+	duplicateUpdate = "update"
+	duplicateKeep   = "duplicate"
+)
+
+// The outcomes recorded for each row of an import.
+const (
+	importInserted = "inserted"
+	importUpdated  = "updated"
+	importSkipped  = "skipped"
+	importRejected = "rejected"
+)
+
+// ImportOptions controls how ImportRecords handles the rows given to it.
+type ImportOptions struct {
+	// OnDuplicate is one of 'skip', 'update' or 'duplicate' - see
+	// ImportRecords.
+	OnDuplicate string
+	// DryRun checks every row and reports what would happen, but
+	// saves nothing.
+	DryRun bool
+	// BatchSize is the number of rows saved in each transaction.
+	BatchSize int
+	// KeepValues stores new records with the ID, times, authors and
+	// version given in the import - rather than as new records added
+	// now by the current user - and updates records to hold exactly
+	// the values given, so an exported glossary is recreated exactly.
+	// Records missing an acronym or expanded version are accepted.
+	KeepValues bool
+	// Progress, if not nil, is written a count of the rows done after
+	// each batch.
+	Progress io.Writer
+}
+
+// ImportRow holds a single record read from an import file, along with
+// the row of the file it was read from. 'Err' is set instead if the row
+// could not be read.
+type ImportRow struct {
+	Row    int
+	Record Record
+	Err    error
+}
+
+// ImportResult holds the outcome of importing a single row.
+type ImportResult struct {
+	Row     int
+	Status  string
+	ID      int64
+	Acronym string
+	Message string
+}
+
+// ImportReport holds the outcome of every row of an import, along with
+// the number of rows with each outcome.
+type ImportReport struct {
+	Results  []ImportResult
+	Inserted int
+	Updated  int
+	Skipped  int
+	Rejected int
+	DryRun   bool
+}
+
+// add records the outcome of a single row in the report.
+func (rep *ImportReport) add(res ImportResult) {
+	rep.Results = append(rep.Results, res)
+	switch res.Status {
+	case importInserted:
+		rep.Inserted++
+	case importUpdated:
+		rep.Updated++
+	case importSkipped:
+		rep.Skipped++
+	case importRejected:
+		rep.Rejected++
+	}
+}
+
+// importedRows holds the row of the import file each new record was
+// added from - 'saved' for the batches already committed, and 'batch'
+// for the batch being saved. A batch's rows are only added to 'saved'
+// once it is committed, as its transaction may be tried again.
+type importedRows struct {
+	saved map[int64]int
+	batch map[int64]int
+}
+
+// row returns the row of the import file the record with the ID 'id'
+// was added from, if it was added by this import.
+func (m importedRows) row(id int64) (int, bool) {
+	if row, ok := m.batch[id]; ok {
+		return row, true
+	}
+	row, ok := m.saved[id]
+	return row, ok
+}
+
+// ImportRecords validates and saves each of the rows in 'rows', and
+// returns a report of the outcome of every row. A row matching a record
+// already held is handled by 'opts.OnDuplicate':
+//
+//	skip		leave the existing record unchanged
+//	update		add any new description, source or tags to it
+//	duplicate	add the row as a further record anyway
+//
+// Rows are saved 'opts.BatchSize' at a time, each batch in its own
+// transaction, and a row that can not be saved is rejected without
+// affecting the rest of its batch. A dry run does all of the same work
+// in a single transaction - so the report is accurate, including rows
+// repeated within the file - but then rolls it back.
+//
+// An error is only returned if the import could not continue. The
+// report then holds the rows completed before it stopped.
+func ImportRecords(rows []ImportRow, opts ImportOptions) (*ImportReport, error) {
+	switch opts.OnDuplicate {
+	case duplicateSkip, duplicateUpdate, duplicateKeep:
+	default:
+		return nil, fmt.Errorf("ERROR: duplicate handling '%s' must be one of: skip, update or duplicate", opts.OnDuplicate)
+	}
+	if opts.BatchSize < 1 || opts.DryRun {
+		opts.BatchSize = len(rows)
+	}
+	report := &ImportReport{DryRun: opts.DryRun}
+	// the row each new record came from, so a later row repeating it is
+	// reported against the row rather than a record ID
+	saved := make(map[int64]int)
+	for start := 0; start < len(rows); start += opts.BatchSize {
+		end := start + opts.BatchSize
+		if end > len(rows) {
+			end = len(rows)
+		}
+		var results []ImportResult
+		var imported importedRows
+		save := func(tx *dbTx) error {
+			results = results[:0]
+			imported = importedRows{saved: saved, batch: make(map[int64]int)}
+			for _, row := range rows[start:end] {
+				res, err := importRow(tx, row, opts, imported)
+				if err != nil {
+					return err
+				}
+				results = append(results, res)
+			}
+			return nil
+		}
+		var err error
+		if opts.DryRun {
+			err = dryRunTx(CurrentUser(), save)
+		} else {
+			err = withTx(CurrentUser(), save)
+		}
+		if err != nil {
+			return report, err
+		}
+		for id, row := range imported.batch {
+			saved[id] = row
+		}
+		for _, res := range results {
+			report.add(res)
+		}
+		if opts.Progress != nil {
+			fmt.Fprintf(opts.Progress, "\rImported %s of %s rows", humanize.Comma(int64(end)), humanize.Comma(int64(len(rows))))
+			if end == len(rows) {
+				fmt.Fprintln(opts.Progress)
+			}
+		}
+	}
+	return report, nil
+}
+
+// importRow saves a single import row within the transaction 'tx' and
+// returns its outcome. Each row is saved within a savepoint, so a row
+// that fails part way through is undone without affecting the others.
+// An error is only returned if the transaction can not continue.
+func importRow(tx *dbTx, row ImportRow, opts ImportOptions, imported importedRows) (ImportResult, error) {
+	r := row.Record
+	res := ImportResult{Row: row.Row, Acronym: r.Acronym, Status: importRejected}
+	if row.Err != nil {
+		res.Message = reportMessage(row.Err)
+		return res, nil
+	}
+	// records exported from early versions of amt may be missing an
+	// acronym or expanded version - but are still recreated as held
+	if err := validateRecord(&r, !opts.KeepValues); err != nil {
+		res.Message = reportMessage(err)
+		return res, nil
+	}
+
+	if _, err := tx.Exec("SAVEPOINT import_row;"); err != nil {
+		return res, fmt.Errorf("ERROR: unable to import row %d: %v", row.Row, err)
+	}
+	status, id, message, err := storeImportRecord(tx, &r, opts, imported)
+	if err != nil {
+		if _, rbErr := tx.Exec("ROLLBACK TO import_row;"); rbErr != nil {
+			return res, fmt.Errorf("ERROR: unable to undo failed import of row %d: %v", row.Row, rbErr)
+		}
+		status, id, message = importRejected, 0, reportMessage(err)
+	}
+	if _, err = tx.Exec("RELEASE import_row;"); err != nil {
+		return res, fmt.Errorf("ERROR: unable to import row %d: %v", row.Row, err)
+	}
+	res.Status, res.ID, res.Message = status, id, message
+	if status == importInserted {
+		imported.batch[id] = row.Row
+	}
+	// IDs given to new records in a dry run are never kept
+	if _, ok := imported.row(id); ok && opts.DryRun {
+		res.ID = 0
+	}
+	return res, nil
+}
+
+// storeImportRecord adds the valid import record 'r', or handles it as
+// set by 'onDuplicate' if it matches a record already held. It returns
+// the outcome, the ID of the record inserted or matched, and a message
+// describing what was done. 'imported' holds the row each record added
+// by this import came from.
+func storeImportRecord(tx *dbTx, r *Record, opts ImportOptions, imported importedRows) (status string, id int64, message string, err error) {
+	existing, found, err := findDuplicate(tx, r)
+	if err != nil {
+		return "", 0, "", err
+	}
+	held := fmt.Sprintf("acronym ID %d", existing.ID)
+	if row, ok := imported.row(existing.ID); ok {
+		held = fmt.Sprintf("row %d of this file", row)
+	}
+	if !found {
+		if err = addImportRecord(tx, r, opts.KeepValues); err != nil {
+			return "", 0, "", err
+		}
+		return importInserted, r.ID, "", nil
+	}
+
+	switch opts.OnDuplicate {
+	case duplicateKeep:
+		// a further copy can not share the UUID of the existing record
+		if strings.EqualFold(r.UUID, existing.UUID) {
+			r.UUID = ""
+		}
+		if err = addImportRecord(tx, r, opts.KeepValues); err != nil {
+			return "", 0, "", err
+		}
+		return importInserted, r.ID, "duplicate of " + held, nil
+	case duplicateUpdate:
+		updated, changed := mergeImportRecord(existing, r, opts.KeepValues)
+		if !changed {
+			return importSkipped, existing.ID, "no new values for " + held, nil
+		}
+		if opts.KeepValues {
+			err = replaceRecord(tx, &updated)
+		} else {
+			err = updateRecord(tx, &updated)
+		}
+		if err != nil {
+			return "", 0, "", err
+		}
+		return importUpdated, existing.ID, "", nil
+	default:
+		return importSkipped, existing.ID, "already held as " + held, nil
+	}
+}
+
+// addImportRecord adds the import record 'r' as a new record - keeping
+// its ID, times, authors and version if 'keepValues' is set.
+func addImportRecord(tx *dbTx, r *Record, keepValues bool) error {
+	if !keepValues {
+		return insertRecord(tx, r)
+	}
+	if err := storeRecord(tx, r); err != nil {
+		return err
+	}
+	return auditChange(tx, auditInsert, r.ID, nil)
+}
+
+// findDuplicate looks for a record already held that matches the import
+// record 'r' - one with the same UUID if 'r' has one, or otherwise with
+// the same acronym and expanded version, ignoring case.
+func findDuplicate(tx *dbTx, r *Record) (Record, bool, error) {
+	var existing Record
+	var err error
+	if r.UUID != "" {
+		existing, err = getRecordByUUID(tx, r.UUID)
+	} else {
+		existing, err = scanRecord(tx.QueryRow(recordQuery+` where upper(trim(a.Acronym)) = upper(?)
+			and lower(trim(a.Definition)) = lower(?) order by a.AcronymID limit 1;`,
+			strings.TrimSpace(r.Acronym), strings.TrimSpace(r.Definition)))
+	}
+	if err == sql.ErrNoRows {
+		return existing, false, nil
+	}
+	if err != nil {
+		return existing, false, fmt.Errorf("ERROR: checking for an existing acronym '%s': %v", r.Acronym, err)
+	}
+	return existing, true, nil
+}
+
+// mergeImportRecord returns the existing record 'existing' updated with
+// the values of the import record 'r', and whether anything changed.
+// Values left empty in the import are kept, and the tags of both are
+// combined. The acronym and expanded version are only taken from 'r'
+// when it was matched by UUID - otherwise they already match. If
+// 'exact' is set every value - including the times, authors and
+// version - is replaced by that held in 'r' instead.
+func mergeImportRecord(existing Record, r *Record, exact bool) (Record, bool) {
+	updated := existing
+	if exact {
+		updated.Acronym, updated.Definition, updated.Description = r.Acronym, r.Definition, r.Description
+		updated.Source, updated.Tags = r.Source, mergeTags(nil, r.Tags)
+		updated.CreatedAt, updated.UpdatedAt = r.CreatedAt, r.UpdatedAt
+		updated.CreatedBy, updated.UpdatedBy, updated.Version = r.CreatedBy, r.UpdatedBy, r.Version
+		changed := updated.Acronym != existing.Acronym || updated.Definition != existing.Definition ||
+			updated.Description != existing.Description || updated.Source != existing.Source ||
+			strings.Join(updated.Tags, tagSeparator) != strings.Join(existing.Tags, tagSeparator) ||
+			!updated.CreatedAt.Equal(existing.CreatedAt) || !updated.UpdatedAt.Equal(existing.UpdatedAt) ||
+			updated.CreatedBy != existing.CreatedBy || updated.UpdatedBy != existing.UpdatedBy ||
+			updated.Version != existing.Version
+		return updated, changed
+	}
+	if r.UUID != "" {
+		updated.Acronym, updated.Definition = r.Acronym, r.Definition
+	}
+	if r.Description != "" {
+		updated.Description = r.Description
+	}
+	if r.Source != "" {
+		updated.Source = r.Source
+	}
+	updated.Tags = mergeTags(existing.Tags, r.Tags)
+	changed := updated.Acronym != existing.Acronym || updated.Definition != existing.Definition ||
+		updated.Description != existing.Description || !strings.EqualFold(updated.Source, existing.Source) ||
+		len(updated.Tags) != len(existing.Tags)
+	return updated, changed
+}
+
+// mergeTags returns the tags held in either 'a' or 'b', sorted and
+// without repeats.
+func mergeTags(a, b []string) []string {
+	seen := make(map[string]bool)
+	var tags []string
+	for _, tag := range append(append([]string{}, a...), b...) {
+		tag = normaliseTag(tag)
+		if !seen[tag] {
+			seen[tag] = true
+			tags = append(tags, tag)
+		}
+	}
+	sort.Strings(tags)
+	return tags
+}
+
+// reportMessage returns the error 'err' as shown in an import report -
+// without the 'ERROR:' prefix, as every rejected row is an error.
+func reportMessage(err error) string {
+	return strings.TrimPrefix(err.Error(), "ERROR: ")
+}
+
+// csvColumns maps the column headings recognised in a CSV import file,
+// in lower case, to the record value they hold.
+var csvColumns = map[string]string{
+	"acronym":      "acronym",
+	"abbreviation": "acronym",
+	"abbr":         "acronym",
+	"term":         "acronym",
+	"definition":   "definition",
+	"expanded":     "definition",
+	"expansion":    "definition",
+	"meaning":      "definition",
+	"description":  "description",
+	"notes":        "description",
+	"source":       "source",
+	"tags":         "tags",
+	"tag":          "tags",
+	"uuid":         "uuid",
+}
+
+// ReadCSV reads the acronym records held in the CSV file 'r', with
+// values separated by 'comma' - such as ',' or a tab. The first row
+// holds the column headings, which are matched to the record values
+// they hold using 'csvColumns', or the extra headings given in
+// 'mapping' - such as {"long form": "definition"}. Columns with any
+// other heading are not imported, and their names are returned in
+// 'ignored'. Tags are given as a list separated by semicolons or
+// commas.
+//
+// A row that can not be read is returned with its 'Err' set, so it is
+// reported as rejected. An error is only returned if the file as a
+// whole can not be read.
+func ReadCSV(r io.Reader, comma rune, mapping map[string]string) (rows []ImportRow, ignored []string, err error) {
+	reader := csv.NewReader(r)
+	reader.Comma = comma
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+
+	header, err := reader.Read()
+	if err == io.EOF {
+		return nil, nil, fmt.Errorf("ERROR: the import file is empty")
+	}
+	if err != nil {
+		return nil, nil, fmt.Errorf("ERROR: unable to read the column headings of the import file: %v", err)
+	}
+	columns := make([]string, len(header))
+	found := make(map[string]bool)
+	for i, name := range header {
+		name = strings.ToLower(strings.TrimSpace(strings.TrimPrefix(name, "\ufeff")))
+		field, ok := mapping[name]
+		if !ok {
+			field, ok = csvColumns[name]
+		}
+		if !ok {
+			ignored = append(ignored, header[i])
+			continue
+		}
+		if found[field] {
+			return nil, nil, fmt.Errorf("ERROR: more than one column of the import file holds the %s", field)
+		}
+		columns[i], found[field] = field, true
+	}
+	for _, field := range []string{"acronym", "definition"} {
+		if !found[field] {
+			return nil, nil, fmt.Errorf("ERROR: no column of the import file holds the %s - columns found are: %s",
+				field, strings.Join(header, ", "))
+		}
+	}
+
+	// rows are numbered as a spreadsheet would show them - the column
+	// headings are row 1
+	for rowNo := 2; ; rowNo++ {
+		values, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		row := ImportRow{Row: rowNo}
+		if err != nil {
+			var parseErr *csv.ParseError
+			if !errors.As(err, &parseErr) {
+				return rows, ignored, fmt.Errorf("ERROR: unable to read row %d of the import file: %v", rowNo, err)
+			}
+			row.Err = parseErr.Err
+			rows = append(rows, row)
+			continue
+		}
+		if len(values) == 1 && strings.TrimSpace(values[0]) == "" {
+			continue
+		}
+		if len(values) > len(columns) {
+			row.Err = fmt.Errorf("row has %d values but there are only %d columns", len(values), len(columns))
+		}
+		for i, value := range values {
+			if i >= len(columns) {
+				break
+			}
+			value = strings.TrimSpace(value)
+			switch columns[i] {
+			case "acronym":
+				row.Record.Acronym = value
+			case "definition":
+				row.Record.Definition = value
+			case "description":
+				row.Record.Description = value
+			case "source":
+				row.Record.Source = value
+			case "tags":
+				row.Record.Tags = SplitTags(value)
+			case "uuid":
+				row.Record.UUID = strings.ToLower(value)
+			}
+		}
+		rows = append(rows, row)
+	}
+	return rows, ignored, nil
+}
+
+// ParseColumnMapping converts a list of column mappings, such as
+// 'long form=definition,abbrev=acronym', into the map used by ReadCSV.
+func ParseColumnMapping(list string) (map[string]string, error) {
+	mapping := make(map[string]string)
+	for _, pair := range strings.Split(list, ",") {
+		if strings.TrimSpace(pair) == "" {
+			continue
+		}
+		idx := strings.Index(pair, "=")
+		if idx < 0 {
+			return nil, fmt.Errorf("ERROR: column mapping '%s' must be in the form 'column=value'", pair)
+		}
+		column := strings.ToLower(strings.TrimSpace(pair[:idx]))
+		field, ok := csvColumns[strings.ToLower(strings.TrimSpace(pair[idx+1:]))]
+		if !ok {
+			return nil, fmt.Errorf("ERROR: column mapping '%s' must map to one of: acronym, definition, description, source, tags or uuid", pair)
+		}
+		mapping[column] = field
+	}
+	return mapping, nil
+}
+
+// WriteImportReport writes the outcome of every row of an import to
+// 'w' as a CSV file.
+func WriteImportReport(w io.Writer, rep *ImportReport) error {
+	out := csv.NewWriter(w)
+	if err := out.Write([]string{"row", "status", "id", "acronym", "message"}); err != nil {
+		return err
+	}
+	for _, res := range rep.Results {
+		id := ""
+		if res.ID > 0 {
+			id = fmt.Sprint(res.ID)
+		}
+		if err := out.Write([]string{fmt.Sprint(res.Row), res.Status, id, res.Acronym, res.Message}); err != nil {
+			return err
+		}
+	}
+	out.Flush()
+	return out.Error()
+}
+
+// printImportReport displays the outcome of every row of an import on
+// stdout, followed by the number of rows with each outcome.
+func printImportReport(rep *ImportReport, showRows bool) {
+	if showRows && len(rep.Results) > 0 {
+		fmt.Printf("\n%-6s  %-8s  %-6s  %-15s  %s\n", "ROW", "STATUS", "ID", "ACRONYM", "MESSAGE")
+		for _, res := range rep.Results {
+			id := ""
+			if res.ID > 0 {
+				id = fmt.Sprint(res.ID)
+			}
+			fmt.Printf("%-6d  %-8s  %-6s  %-15s  %s\n", res.Row, res.Status, id, res.Acronym, res.Message)
+		}
+	}
+	fmt.Printf("\nRows read: %s   inserted: %s   updated: %s   skipped: %s   rejected: %s\n",
+		humanize.Comma(int64(len(rep.Results))), humanize.Comma(int64(rep.Inserted)),
+		humanize.Comma(int64(rep.Updated)), humanize.Comma(int64(rep.Skipped)), humanize.Comma(int64(rep.Rejected)))
+	if rep.DryRun {
+		fmt.Println("Dry run only - no changes have been saved to the database")
+	}
+}
@@ -0,0 +1,115 @@
+// amt - program to access an SQLite database and lookup acronyms
+//
+// author:	Simon Rowe <simon@wiremoons.com>
+// license: open-source released under The MIT License (MIT).
+//
+// Package used to test importing acronym records in bulk for
+// application 'amt'
+
+package lib
+
+import (
+	"reflect"
+	"testing"
+)
+
+// setTestUser makes 'name' the user recorded against the changes made
+// by a test, until the test finishes.
+func setTestUser(t *testing.T, name string) {
+	t.Helper()
+	saved := Settings.Author
+	Settings.Author = name
+	t.Cleanup(func() { Settings.Author = saved })
+}
+
+func TestImportRecordsDuplicates(t *testing.T) {
+	rows := []ImportRow{
+		{Row: 2, Record: Record{Acronym: "SNI", Definition: "server name indication", Description: "TLS extension", Tags: []string{"tls"}}},
+		{Row: 3, Record: Record{Acronym: "TLA", Definition: "Three Letter Acronym"}},
+		{Row: 4, Record: Record{Acronym: "TLA", Definition: "Three Letter Acronym"}},
+		{Row: 5, Record: Record{Definition: "No acronym given"}},
+	}
+	tests := []struct {
+		name        string
+		onDuplicate string
+		dryRun      bool
+		want        []string
+		wantRecords int
+		wantSNI     string
+	}{
+		{
+			name:        "skip",
+			onDuplicate: duplicateSkip,
+			want:        []string{importSkipped, importInserted, importSkipped, importRejected},
+			wantRecords: 2,
+			wantSNI:     "old description",
+		},
+		{
+			name:        "update",
+			onDuplicate: duplicateUpdate,
+			want:        []string{importUpdated, importInserted, importSkipped, importRejected},
+			wantRecords: 2,
+			wantSNI:     "TLS extension",
+		},
+		{
+			name:        "duplicate",
+			onDuplicate: duplicateKeep,
+			want:        []string{importInserted, importInserted, importInserted, importRejected},
+			wantRecords: 4,
+			wantSNI:     "old description",
+		},
+		{
+			// a dry run reports the same outcomes, but saves nothing
+			name:        "dry run",
+			onDuplicate: duplicateUpdate,
+			dryRun:      true,
+			want:        []string{importUpdated, importInserted, importSkipped, importRejected},
+			wantRecords: 1,
+			wantSNI:     "old description",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			migrateTestDB(t)
+			setTestUser(t, "alice")
+			sni := Record{Acronym: "SNI", Definition: "Server Name Indication", Description: "old description"}
+			if err := InsertRecord(&sni, "alice"); err != nil {
+				t.Fatal(err)
+			}
+
+			report, err := ImportRecords(rows, ImportOptions{OnDuplicate: tt.onDuplicate, DryRun: tt.dryRun, BatchSize: 2})
+			if err != nil {
+				t.Fatalf("ImportRecords() error = %v", err)
+			}
+			var got []string
+			for _, res := range report.Results {
+				got = append(got, res.Status)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ImportRecords() outcomes = %q, want %q", got, tt.want)
+			}
+
+			var records int
+			if err = DB.QueryRow("select count(*) from ACRONYMS;").Scan(&records); err != nil {
+				t.Fatal(err)
+			}
+			if records != tt.wantRecords {
+				t.Errorf("ImportRecords() left %d records, want %d", records, tt.wantRecords)
+			}
+			held, err := GetRecord(sni.ID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if held.Description != tt.wantSNI {
+				t.Errorf("ImportRecords() left description %q, want %q", held.Description, tt.wantSNI)
+			}
+		})
+	}
+}
+
+func TestImportRecordsOnDuplicate(t *testing.T) {
+	migrateTestDB(t)
+	if _, err := ImportRecords(nil, ImportOptions{OnDuplicate: "merge"}); err == nil {
+		t.Errorf("ImportRecords() with duplicate handling 'merge' succeeded, want an error")
+	}
+}
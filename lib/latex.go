@@ -0,0 +1,289 @@
+// amt - program to access an SQLite database and lookup acronyms
+//
+// author:	Simon Rowe <simon@wiremoons.com>
+// license: open-source released under The MIT License (MIT).
+//
+// Package used to exchange acronyms with LaTeX documents for
+// application 'amt'
+//
+// Acronyms can be exported as the definitions used by the LaTeX
+// 'glossaries' package:
+//
+//	\newacronym{sni}{SNI}{Server Name Indication}
+//
+// or by the 'acronym' package:
+//
+//	\acro{SNI}{Server Name Indication}
+//
+// and both kinds of definition can be harvested from existing LaTeX
+// documents and imported.
+
+package lib
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+	"unicode"
+)
+
+// latexEscapes maps the characters that have a special meaning to LaTeX
+// to the text that prints them.
+var latexEscapes = strings.NewReplacer(
+	`\`, `\textbackslash{}`,
+	`&`, `\&`,
+	`%`, `\%`,
+	`$`, `\$`,
+	`#`, `\#`,
+	`_`, `\_`,
+	`{`, `\{`,
+	`}`, `\}`,
+	`~`, `\textasciitilde{}`,
+	`^`, `\textasciicircum{}`,
+)
+
+// latexUnescapes maps the LaTeX commands for special characters back
+// to the characters - used when harvesting definitions.
+var latexUnescapes = strings.NewReplacer(
+	`\textbackslash{}`, `\`,
+	`\textasciitilde{}`, `~`,
+	`\textasciicircum{}`, `^`,
+	`\&`, `&`,
+	`\%`, `%`,
+	`\$`, `$`,
+	`\#`, `#`,
+	`\_`, `_`,
+	`\{`, "\x00",
+	`\}`, "\x01",
+	`\ `, ` `,
+	`~`, ` `,
+	`{`, ``,
+	`}`, ``,
+)
+
+// latexUses finds the commands that use an acronym in a LaTeX document,
+// such as '\gls{sni}' or '\ac{SNI}', capturing the key used.
+var latexUses = regexp.MustCompile(`\\(?:[gG][lL][sS][a-zA-Z]*|[aA][cC][rR](?:short|long|full)[a-z]*|[aA][cC][slfp]*)\*?(?:\[[^\]]*\])?\{([^{}]+)\}`)
+
+// latexKey returns the key used to refer to the acronym 'acronym' in a
+// LaTeX document - the acronym with only its letters, digits and dashes.
+func latexKey(acronym string) string {
+	key := strings.Map(func(r rune) rune {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) || r == '-' {
+			return r
+		}
+		return -1
+	}, acronym)
+	if key == "" {
+		key = "acronym"
+	}
+	return key
+}
+
+// latexKeys returns the LaTeX key for each record in 'records'. A key
+// already given to an earlier record has '-2', '-3' and so on added, so
+// every key is unique.
+func latexKeys(records []Record) []string {
+	keys := make([]string, len(records))
+	seen := make(map[string]int)
+	for i, r := range records {
+		key := latexKey(r.Acronym)
+		seen[strings.ToLower(key)]++
+		if n := seen[strings.ToLower(key)]; n > 1 {
+			key = fmt.Sprintf("%s-%d", key, n)
+		}
+		keys[i] = key
+	}
+	return keys
+}
+
+// UsedAcronyms returns the keys of the acronyms used in the LaTeX
+// document 'r', in lower case - such as 'sni' for '\gls{sni}' or
+// '\acs{SNI}'.
+func UsedAcronyms(r io.Reader) (map[string]bool, error) {
+	text, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	used := make(map[string]bool)
+	for _, m := range latexUses.FindAllSubmatch(stripLaTeXComments(text), -1) {
+		used[strings.ToLower(strings.TrimSpace(string(m[1])))] = true
+	}
+	return used, nil
+}
+
+// usedRecords returns the records in 'records' whose LaTeX key is held
+// in 'used'. Keys made unique with a number, such as 'wtf-2', also match
+// the key without it.
+func usedRecords(records []Record, used map[string]bool) []Record {
+	var kept []Record
+	for _, r := range records {
+		key := strings.ToLower(latexKey(r.Acronym))
+		if used[key] {
+			kept = append(kept, r)
+			continue
+		}
+		for u := range used {
+			if i := strings.LastIndex(u, "-"); i > 0 && u[:i] == key && strings.Trim(u[i+1:], "0123456789") == "" {
+				kept = append(kept, r)
+				break
+			}
+		}
+	}
+	return kept
+}
+
+// writeGlossaryGlossaries writes the acronym records in the glossary 'g'
+// to 'w' as '\newacronym' definitions for the LaTeX glossaries package.
+func writeGlossaryGlossaries(w io.Writer, g *Glossary) error {
+	bw := bufio.NewWriter(w)
+	fmt.Fprintf(bw, "%% Acronyms exported from %s - for the LaTeX glossaries package:\n", Appname)
+	fmt.Fprintf(bw, "%%   \\usepackage[acronym]{glossaries}\n\n")
+	keys := latexKeys(g.Records)
+	for i, r := range g.Records {
+		fmt.Fprintf(bw, "\\newacronym{%s}{%s}{%s}\n", strings.ToLower(keys[i]),
+			latexEscapes.Replace(r.Acronym), latexEscapes.Replace(r.Definition))
+	}
+	return bw.Flush()
+}
+
+// writeGlossaryAcronym writes the acronym records in the glossary 'g' to
+// 'w' as '\acro' definitions for the LaTeX acronym package.
+func writeGlossaryAcronym(w io.Writer, g *Glossary) error {
+	bw := bufio.NewWriter(w)
+	fmt.Fprintf(bw, "%% Acronyms exported from %s - for the LaTeX acronym package:\n", Appname)
+	fmt.Fprintf(bw, "%%   \\usepackage{acronym}\n\n")
+	fmt.Fprintf(bw, "\\begin{acronym}\n")
+	keys := latexKeys(g.Records)
+	for i, r := range g.Records {
+		short := latexEscapes.Replace(r.Acronym)
+		if short == keys[i] {
+			fmt.Fprintf(bw, "\\acro{%s}{%s}\n", keys[i], latexEscapes.Replace(r.Definition))
+		} else {
+			fmt.Fprintf(bw, "\\acro{%s}[%s]{%s}\n", keys[i], short, latexEscapes.Replace(r.Definition))
+		}
+	}
+	fmt.Fprintf(bw, "\\end{acronym}\n")
+	return bw.Flush()
+}
+
+// stripLaTeXComments returns the LaTeX document 'text' with every
+// comment - from a '%' that is not escaped to the end of the line -
+// removed. Line breaks are kept, so line numbers are not changed.
+func stripLaTeXComments(text []byte) []byte {
+	out := make([]byte, 0, len(text))
+	inComment := false
+	for i := 0; i < len(text); i++ {
+		c := text[i]
+		switch {
+		case c == '\n':
+			inComment = false
+		case inComment:
+			continue
+		case c == '\\' && i+1 < len(text) && text[i+1] != '\n':
+			out = append(out, c, text[i+1])
+			i++
+			continue
+		case c == '%':
+			inComment = true
+			continue
+		}
+		out = append(out, c)
+	}
+	return out
+}
+
+// latexDefinition finds the acronym definitions in a LaTeX document.
+var latexDefinition = regexp.MustCompile(`\\(newacronym|acrodef|acro)\b\*?`)
+
+// ReadLaTeX harvests the acronyms defined in the LaTeX document 'r' -
+// '\newacronym{key}{short}{long}' for the glossaries package, and
+// '\acro{key}[short]{long}' or '\acrodef' for the acronym package. The
+// LaTeX commands for special characters are turned back into the
+// characters. A definition that can not be read is returned with its
+// 'Err' set, so it is reported as rejected. Rows are numbered by the
+// line of the document each definition starts on.
+func ReadLaTeX(r io.Reader) ([]ImportRow, error) {
+	raw, err := io.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("ERROR: unable to read the LaTeX file: %v", err)
+	}
+	text := string(stripLaTeXComments(raw))
+
+	var rows []ImportRow
+	for _, loc := range latexDefinition.FindAllStringSubmatchIndex(text, -1) {
+		command := text[loc[2]:loc[3]]
+		row := ImportRow{Row: strings.Count(text[:loc[0]], "\n") + 1}
+		rest := text[loc[1]:]
+
+		var key, short, long string
+		var ok bool
+		if command == "newacronym" {
+			// skip any options, such as: [description={...}]
+			_, rest, _ = latexGroup(rest, '[', ']')
+			key, rest, ok = latexGroup(rest, '{', '}')
+			if ok {
+				short, rest, ok = latexGroup(rest, '{', '}')
+			}
+		} else {
+			key, rest, ok = latexGroup(rest, '{', '}')
+			short, rest, _ = latexGroup(rest, '[', ']')
+			if short == "" {
+				short = key
+			}
+		}
+		if ok {
+			long, _, ok = latexGroup(rest, '{', '}')
+		}
+		if !ok {
+			row.Err = fmt.Errorf("unable to read the \\%s definition", command)
+			rows = append(rows, row)
+			continue
+		}
+		row.Record = Record{
+			Acronym:    latexText(short),
+			Definition: latexText(long),
+		}
+		rows = append(rows, row)
+	}
+	return rows, nil
+}
+
+// latexGroup reads a group enclosed by 'open' and 'close' - such as
+// '{...}' - from the start of 'text', after any white space. Nested
+// groups and escaped characters are allowed within it. The contents of
+// the group and the text following it are returned, or 'false' if
+// 'text' does not start with a complete group - in which case 'text' is
+// returned unchanged in 'rest'.
+func latexGroup(text string, open, close byte) (group, rest string, ok bool) {
+	s := strings.TrimLeft(text, " \t\r\n")
+	if s == "" || s[0] != open {
+		return "", text, false
+	}
+	depth := 0
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			i++
+		case open:
+			depth++
+		case close:
+			depth--
+			if depth == 0 {
+				return s[1:i], s[i+1:], true
+			}
+		}
+	}
+	return "", text, false
+}
+
+// latexText turns the LaTeX source 'text' back into plain text, by
+// replacing the commands for special characters and removing grouping
+// braces and extra white space.
+func latexText(text string) string {
+	text = latexUnescapes.Replace(text)
+	text = strings.NewReplacer("\x00", "{", "\x01", "}").Replace(text)
+	return strings.Join(strings.Fields(text), " ")
+}
@@ -0,0 +1,100 @@
+// amt - program to access an SQLite database and lookup acronyms
+//
+// author:	Simon Rowe <simon@wiremoons.com>
+// license: open-source released under The MIT License (MIT).
+//
+// Package used to test reading acronyms from LaTeX documents for
+// application 'amt'
+
+package lib
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestLatexGroup(t *testing.T) {
+	tests := []struct {
+		text        string
+		open, close byte
+		group, rest string
+		ok          bool
+	}{
+		{text: "{SNI}{Server}", open: '{', close: '}', group: "SNI", rest: "{Server}", ok: true},
+		{text: " \t\n{SNI} rest", open: '{', close: '}', group: "SNI", rest: " rest", ok: true},
+		{text: "{}", open: '{', close: '}', group: "", rest: "", ok: true},
+		{text: "{a {nested {group}} b}c", open: '{', close: '}', group: "a {nested {group}} b", rest: "c", ok: true},
+		{text: `{a \} b}c`, open: '{', close: '}', group: `a \} b`, rest: "c", ok: true},
+		{text: `{a \{ b}c`, open: '{', close: '}', group: `a \{ b`, rest: "c", ok: true},
+		{text: `{a \\}c`, open: '{', close: '}', group: `a \\`, rest: "c", ok: true},
+		{text: "[short]{long}", open: '[', close: ']', group: "short", rest: "{long}", ok: true},
+		{text: "[a [b] c]x", open: '[', close: ']', group: "a [b] c", rest: "x", ok: true},
+		{text: "{long}", open: '[', close: ']', rest: "{long}", ok: false},
+		{text: "x{SNI}", open: '{', close: '}', rest: "x{SNI}", ok: false},
+		{text: "{unclosed", open: '{', close: '}', rest: "{unclosed", ok: false},
+		{text: `{escaped \}`, open: '{', close: '}', rest: `{escaped \}`, ok: false},
+		{text: "{a {b}", open: '{', close: '}', rest: "{a {b}", ok: false},
+		{text: "", open: '{', close: '}', rest: "", ok: false},
+		{text: "   ", open: '{', close: '}', rest: "   ", ok: false},
+	}
+	for _, tt := range tests {
+		group, rest, ok := latexGroup(tt.text, tt.open, tt.close)
+		if group != tt.group || rest != tt.rest || ok != tt.ok {
+			t.Errorf("latexGroup(%q, %q, %q) = %q, %q, %v, want %q, %q, %v",
+				tt.text, tt.open, tt.close, group, rest, ok, tt.group, tt.rest, tt.ok)
+		}
+	}
+}
+
+func TestReadLaTeX(t *testing.T) {
+	doc := `\documentclass{article}
+\usepackage{acronym}
+\newacronym{sni}{SNI}{Server Name Indication}
+\newacronym[description={an {example}}]{tla}{TLA}{Three {Letter} Acronym}
+% \newacronym{old}{OLD}{Commented out}
+\begin{acronym}
+\acro{HTTP}{Hypertext Transfer Protocol}
+\acro{ampersand}[A\&B]{Apples \& Bananas}
+\acro*{braces}[B\{\}]{Escaped \{braces\} kept}
+\acrodef{RFC}{Request for
+  Comments}
+\acro{50pc}[50\%]{Fifty per cent} % a comment after the definition
+\end{acronym}
+\newacronym{bad}{BAD}
+\newacronym{end}{END}{Last {nested {deeply}} one}
+`
+	type row struct {
+		Row        int
+		Acronym    string
+		Definition string
+		Err        bool
+	}
+	want := []row{
+		{Row: 3, Acronym: "SNI", Definition: "Server Name Indication"},
+		{Row: 4, Acronym: "TLA", Definition: "Three Letter Acronym"},
+		{Row: 7, Acronym: "HTTP", Definition: "Hypertext Transfer Protocol"},
+		{Row: 8, Acronym: "A&B", Definition: "Apples & Bananas"},
+		{Row: 9, Acronym: "B{}", Definition: "Escaped {braces} kept"},
+		{Row: 10, Acronym: "RFC", Definition: "Request for Comments"},
+		{Row: 12, Acronym: "50%", Definition: "Fifty per cent"},
+		{Row: 14, Err: true},
+		{Row: 15, Acronym: "END", Definition: "Last nested deeply one"},
+	}
+
+	rows, err := ReadLaTeX(strings.NewReader(doc))
+	if err != nil {
+		t.Fatalf("ReadLaTeX() error = %v", err)
+	}
+	var got []row
+	for _, r := range rows {
+		got = append(got, row{Row: r.Row, Acronym: r.Record.Acronym, Definition: r.Record.Definition, Err: r.Err != nil})
+	}
+	if len(got) != len(want) {
+		t.Fatalf("ReadLaTeX() returned %d rows, want %d:\n%+v", len(got), len(want), got)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("ReadLaTeX() row %d = %+v, want %+v", i, got[i], want[i])
+		}
+	}
+}
@@ -0,0 +1,863 @@
+// amt - program to access an SQLite database and lookup acronyms
+//
+// author:	Simon Rowe <simon@wiremoons.com>
+// license: open-source released under The MIT License (MIT).
+//
+// Package used to answer Language Server Protocol requests from an
+// editor for application 'amt'
+//
+// An editor runs 'amt lsp' and talks to it over stdin and stdout. The
+// expansion of any known acronym under the cursor is shown on hover,
+// acronyms and their expansions are offered as completions, and words
+// that look like acronyms but are not in the glossary are marked. Where
+// the document spells out such an acronym - as 'Transport Layer Security
+// (TLS)' or 'TLS (Transport Layer Security)' - a quick fix adds it.
+
+package lib
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+)
+
+// lspAddCommand is the command run by the quick fix that adds a missing
+// acronym to the glossary.
+const lspAddCommand = "amt.addAcronym"
+
+// lspUnknownCode marks the diagnostics given for words that look like
+// acronyms but are not in the glossary.
+const lspUnknownCode = "unknown-acronym"
+
+// lspRefresh is how long the list of known acronyms is used before it
+// is read again, so acronyms added by other users are picked up.
+const lspRefresh = time.Minute
+
+// lspMaxMessage is the largest message accepted from the editor.
+const lspMaxMessage = 64 << 20
+
+// lspMaxCompletions is the most acronyms offered as completions at
+// once - typing more of the acronym narrows the list.
+const lspMaxCompletions = 100
+
+// The JSON-RPC error codes sent to the editor.
+const (
+	lspParseError     = -32700
+	lspInvalidRequest = -32600
+	lspMethodNotFound = -32601
+	lspInvalidParams  = -32602
+	lspRequestFailed  = -32803
+)
+
+// The LSP values used for diagnostic severity, message type and
+// completion kind.
+const (
+	lspSeverityInformation = 3
+	lspMessageError        = 1
+	lspMessageInfo         = 3
+	lspCompletionText      = 1
+)
+
+// lspSmallWords are the words that may be left out of an acronym when
+// matching it to its expansion, such as the 'of' in 'Bank of England
+// (BE)'.
+var lspSmallWords = map[string]bool{
+	"a": true, "an": true, "and": true, "by": true, "for": true, "in": true,
+	"of": true, "on": true, "or": true, "the": true, "to": true, "with": true,
+}
+
+// lspMessage holds a single request, notification or response read
+// from the editor.
+type lspMessage struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id,omitempty"`
+	Method  string          `json:"method,omitempty"`
+	Params  json.RawMessage `json:"params,omitempty"`
+}
+
+// lspResponse holds the reply to a request that succeeded.
+type lspResponse struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id"`
+	Result  interface{}     `json:"result"`
+}
+
+// lspErrorResponse holds the reply to a request that failed.
+type lspErrorResponse struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id"`
+	Error   *lspError       `json:"error"`
+}
+
+// lspNotification holds a message sent to the editor that needs no
+// reply.
+type lspNotification struct {
+	JSONRPC string      `json:"jsonrpc"`
+	Method  string      `json:"method"`
+	Params  interface{} `json:"params"`
+}
+
+// lspError is the error sent to the editor when a request fails.
+type lspError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *lspError) Error() string { return e.Message }
+
+// lspPosition is a place in a document - the line, and the character in
+// that line counted in UTF-16 code units, both from zero.
+type lspPosition struct {
+	Line      int `json:"line"`
+	Character int `json:"character"`
+}
+
+// lspRange is the part of a document from Start up to End.
+type lspRange struct {
+	Start lspPosition `json:"start"`
+	End   lspPosition `json:"end"`
+}
+
+// lspDiagnostic marks a word that looks like an acronym but is not in
+// the glossary.
+type lspDiagnostic struct {
+	Range    lspRange `json:"range"`
+	Severity int      `json:"severity"`
+	Code     string   `json:"code"`
+	Source   string   `json:"source"`
+	Message  string   `json:"message"`
+}
+
+// lspDocument names the document a request is about.
+type lspDocument struct {
+	URI string `json:"uri"`
+}
+
+// lspPositionParams holds the parameters of the hover and completion
+// requests.
+type lspPositionParams struct {
+	TextDocument lspDocument `json:"textDocument"`
+	Position     lspPosition `json:"position"`
+}
+
+// lspAddArgs holds the acronym added by the quick fix command.
+type lspAddArgs struct {
+	Acronym    string `json:"acronym"`
+	Definition string `json:"definition"`
+}
+
+// lspWord is a word found in a line of a document, from the byte
+// offset 'start' up to 'end'.
+type lspWord struct {
+	text       string
+	start, end int
+}
+
+// lspServer holds the state of the conversation with the editor.
+type lspServer struct {
+	in       *bufio.Reader
+	out      io.Writer
+	source   string
+	docs     map[string]string
+	known    map[string]bool
+	loadedAt time.Time
+	shutdown bool
+}
+
+// RunLsp opens the acronym records without printing anything, then
+// answers the Language Server Protocol on stdin and stdout as
+// 'amt lsp'. The exit status for the program is returned.
+func RunLsp(args []string) int {
+	defer CloseDataBase()
+	if err := openQuiet(false); err != nil {
+		fmt.Fprintln(os.Stderr, strings.TrimSpace(err.Error()))
+		return 1
+	}
+	if err := RunCommand(append([]string{"lsp"}, args...)); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	return 0
+}
+
+// runLsp answers Language Server Protocol requests from an editor on
+// stdin and stdout until it is told to exit.
+func runLsp(args []string) error {
+	fs := flag.NewFlagSet(Appname+" lsp", flag.ContinueOnError)
+	source := fs.String("source", "", "`source` given to acronyms added from the editor")
+	if err := fs.Parse(args); err != nil {
+		if err == flag.ErrHelp {
+			return nil
+		}
+		return err
+	}
+	if fs.NArg() != 0 {
+		return fmt.Errorf("ERROR: usage is: %s lsp [-source name]", Appname)
+	}
+	return ServeLsp(os.Stdin, os.Stdout, *source)
+}
+
+// ServeLsp answers the Language Server Protocol requests read from 'in',
+// writing the replies to 'out', until the editor sends 'exit' or closes
+// 'in'. Acronyms added from the editor are given the source 'source'.
+func ServeLsp(in io.Reader, out io.Writer, source string) error {
+	s := &lspServer{
+		in:     bufio.NewReader(in),
+		out:    out,
+		source: source,
+		docs:   make(map[string]string),
+	}
+	if DebugSwitch {
+		log.Println("DEBUG: language server waiting for requests on stdin")
+	}
+	for {
+		m, err := s.read()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if m == nil {
+			continue
+		}
+		if DebugSwitch {
+			log.Printf("DEBUG: language server received: %s\n", m.Method)
+		}
+		if m.Method == "exit" {
+			if !s.shutdown {
+				return fmt.Errorf("ERROR: the editor stopped the language server without shutting it down")
+			}
+			return nil
+		}
+		// replies to requests sent to the editor are not needed
+		if m.Method == "" {
+			continue
+		}
+
+		var result interface{}
+		if s.shutdown {
+			err = &lspError{Code: lspInvalidRequest, Message: "the language server is shutting down"}
+		} else {
+			result, err = s.handle(m)
+		}
+		if len(m.ID) == 0 {
+			// a notification - so there is no one to tell of an
+			// unknown method, but other failures are shown
+			var le *lspError
+			if err != nil && !(errors.As(err, &le) && le.Code == lspMethodNotFound) {
+				s.showMessage(lspMessageError, err.Error())
+			}
+			continue
+		}
+		if err != nil {
+			s.replyError(m.ID, err)
+			continue
+		}
+		s.write(lspResponse{JSONRPC: "2.0", ID: m.ID, Result: result})
+	}
+}
+
+// read returns the next message sent by the editor. A nil message is
+// returned if the message could not be understood, after the editor has
+// been told.
+func (s *lspServer) read() (*lspMessage, error) {
+	length := -1
+	for {
+		line, err := s.in.ReadString('\n')
+		if err != nil {
+			if err == io.EOF && line != "" {
+				err = io.ErrUnexpectedEOF
+			}
+			return nil, err
+		}
+		line = strings.TrimRight(line, "\r\n")
+		if line == "" {
+			break
+		}
+		i := strings.IndexByte(line, ':')
+		if i > 0 && strings.EqualFold(strings.TrimSpace(line[:i]), "Content-Length") {
+			if length, err = strconv.Atoi(strings.TrimSpace(line[i+1:])); err != nil {
+				length = -1
+			}
+		}
+	}
+	if length < 0 || length > lspMaxMessage {
+		return nil, fmt.Errorf("ERROR: language server message has no valid 'Content-Length' header")
+	}
+	body := make([]byte, length)
+	if _, err := io.ReadFull(s.in, body); err != nil {
+		return nil, err
+	}
+	var m lspMessage
+	if err := json.Unmarshal(body, &m); err != nil {
+		s.replyError(json.RawMessage("null"), &lspError{Code: lspParseError, Message: "message is not valid JSON: " + err.Error()})
+		return nil, nil
+	}
+	return &m, nil
+}
+
+// write sends the message 'v' to the editor.
+func (s *lspServer) write(v interface{}) {
+	body, err := json.Marshal(v)
+	if err != nil {
+		log.Printf("ERROR: unable to encode language server message: %v\n", err)
+		return
+	}
+	message := append([]byte("Content-Length: "+strconv.Itoa(len(body))+"\r\n\r\n"), body...)
+	if _, err = s.out.Write(message); err != nil {
+		log.Printf("ERROR: unable to send language server message: %v\n", err)
+	}
+}
+
+// replyError tells the editor the request with the ID 'id' failed.
+func (s *lspServer) replyError(id json.RawMessage, err error) {
+	var le *lspError
+	if !errors.As(err, &le) {
+		le = &lspError{Code: lspRequestFailed, Message: strings.TrimPrefix(err.Error(), "ERROR: ")}
+	}
+	s.write(lspErrorResponse{JSONRPC: "2.0", ID: id, Error: le})
+}
+
+// notify sends the notification 'method' to the editor.
+func (s *lspServer) notify(method string, params interface{}) {
+	s.write(lspNotification{JSONRPC: "2.0", Method: method, Params: params})
+}
+
+// showMessage asks the editor to show 'message' to its user.
+func (s *lspServer) showMessage(kind int, message string) {
+	s.notify("window/showMessage", map[string]interface{}{
+		"type":    kind,
+		"message": strings.TrimPrefix(message, "ERROR: "),
+	})
+}
+
+// handle answers the message 'm', returning the result of a request.
+func (s *lspServer) handle(m *lspMessage) (interface{}, error) {
+	switch m.Method {
+	case "initialize":
+		return map[string]interface{}{
+			"capabilities": map[string]interface{}{
+				"textDocumentSync":       map[string]interface{}{"openClose": true, "change": 1},
+				"hoverProvider":          true,
+				"completionProvider":     map[string]interface{}{},
+				"codeActionProvider":     map[string]interface{}{"codeActionKinds": []string{"quickfix"}},
+				"executeCommandProvider": map[string]interface{}{"commands": []string{lspAddCommand}},
+			},
+			"serverInfo": map[string]string{"name": Appname, "version": Appversion},
+		}, nil
+	case "initialized", "$/cancelRequest", "$/setTrace", "textDocument/didSave":
+		return nil, nil
+	case "shutdown":
+		s.shutdown = true
+		return nil, nil
+
+	case "textDocument/didOpen":
+		var p struct {
+			TextDocument struct {
+				URI  string `json:"uri"`
+				Text string `json:"text"`
+			} `json:"textDocument"`
+		}
+		if err := lspParams(m, &p); err != nil {
+			return nil, err
+		}
+		s.docs[p.TextDocument.URI] = p.TextDocument.Text
+		return nil, s.publish(p.TextDocument.URI)
+	case "textDocument/didChange":
+		var p struct {
+			TextDocument   lspDocument `json:"textDocument"`
+			ContentChanges []struct {
+				Text string `json:"text"`
+			} `json:"contentChanges"`
+		}
+		if err := lspParams(m, &p); err != nil {
+			return nil, err
+		}
+		// the whole document is sent with each change
+		if n := len(p.ContentChanges); n > 0 {
+			s.docs[p.TextDocument.URI] = p.ContentChanges[n-1].Text
+		}
+		return nil, s.publish(p.TextDocument.URI)
+	case "textDocument/didClose":
+		var p struct {
+			TextDocument lspDocument `json:"textDocument"`
+		}
+		if err := lspParams(m, &p); err != nil {
+			return nil, err
+		}
+		delete(s.docs, p.TextDocument.URI)
+		s.notify("textDocument/publishDiagnostics", map[string]interface{}{
+			"uri":         p.TextDocument.URI,
+			"diagnostics": []lspDiagnostic{},
+		})
+		return nil, nil
+
+	case "textDocument/hover":
+		var p lspPositionParams
+		if err := lspParams(m, &p); err != nil {
+			return nil, err
+		}
+		return s.hover(p)
+	case "textDocument/completion":
+		var p lspPositionParams
+		if err := lspParams(m, &p); err != nil {
+			return nil, err
+		}
+		return s.complete(p)
+	case "textDocument/codeAction":
+		var p struct {
+			TextDocument lspDocument `json:"textDocument"`
+			Context      struct {
+				Diagnostics []lspDiagnostic `json:"diagnostics"`
+			} `json:"context"`
+		}
+		if err := lspParams(m, &p); err != nil {
+			return nil, err
+		}
+		return s.codeActions(p.TextDocument.URI, p.Context.Diagnostics), nil
+	case "workspace/executeCommand":
+		var p struct {
+			Command   string            `json:"command"`
+			Arguments []json.RawMessage `json:"arguments"`
+		}
+		if err := lspParams(m, &p); err != nil {
+			return nil, err
+		}
+		var a lspAddArgs
+		if p.Command != lspAddCommand || len(p.Arguments) != 1 || json.Unmarshal(p.Arguments[0], &a) != nil {
+			return nil, &lspError{Code: lspInvalidParams, Message: "unknown command '" + p.Command + "'"}
+		}
+		return nil, s.addAcronym(a)
+	}
+	return nil, &lspError{Code: lspMethodNotFound, Message: "method '" + m.Method + "' is not supported"}
+}
+
+// lspParams reads the parameters of the message 'm' into 'v'.
+func lspParams(m *lspMessage, v interface{}) error {
+	if err := json.Unmarshal(m.Params, v); err != nil {
+		return &lspError{Code: lspInvalidParams, Message: "invalid parameters for '" + m.Method + "': " + err.Error()}
+	}
+	return nil
+}
+
+// loadKnown reads every acronym in the glossary, unless they were read
+// within the last 'lspRefresh'.
+func (s *lspServer) loadKnown() error {
+	if s.known != nil && time.Since(s.loadedAt) < lspRefresh {
+		return nil
+	}
+	records, err := Storage.FindRecords(SearchQuery{})
+	if err != nil {
+		return err
+	}
+	known := make(map[string]bool, len(records))
+	for _, r := range records {
+		known[strings.ToUpper(r.Acronym)] = true
+	}
+	s.known, s.loadedAt = known, time.Now()
+	if DebugSwitch {
+		log.Printf("DEBUG: language server read %d known acronyms\n", len(known))
+	}
+	return nil
+}
+
+// publish sends the editor the diagnostics for the open document 'uri'
+// - one for each word that looks like an acronym but is not in the
+// glossary.
+func (s *lspServer) publish(uri string) error {
+	if err := s.loadKnown(); err != nil {
+		return err
+	}
+	diagnostics := []lspDiagnostic{}
+	for n, line := range lspLines(s.docs[uri]) {
+		for _, w := range lspWords(line) {
+			if !looksLikeAcronym(w.text) || s.known[strings.ToUpper(w.text)] {
+				continue
+			}
+			diagnostics = append(diagnostics, lspDiagnostic{
+				Range:    lspWordRange(n, line, w),
+				Severity: lspSeverityInformation,
+				Code:     lspUnknownCode,
+				Source:   Appname,
+				Message:  fmt.Sprintf("'%s' is not in the acronyms glossary", w.text),
+			})
+		}
+	}
+	s.notify("textDocument/publishDiagnostics", map[string]interface{}{
+		"uri":         uri,
+		"diagnostics": diagnostics,
+	})
+	return nil
+}
+
+// hover returns the expansions of the acronym under the cursor, or nil
+// if it is not in the glossary.
+func (s *lspServer) hover(p lspPositionParams) (interface{}, error) {
+	line, w, ok := s.wordAt(p)
+	if !ok {
+		return nil, nil
+	}
+	records, err := Storage.FindRecords(SearchQuery{Term: w.text})
+	if err != nil || len(records) == 0 {
+		return nil, err
+	}
+	var parts []string
+	for _, r := range records {
+		parts = append(parts, lspMarkdown(r))
+	}
+	return map[string]interface{}{
+		"contents": map[string]string{"kind": "markdown", "value": strings.Join(parts, "\n\n---\n\n")},
+		"range":    lspWordRange(p.Position.Line, line, w),
+	}, nil
+}
+
+// complete returns the acronyms starting with the part of the word
+// typed before the cursor - each offered on its own, and spelt out
+// followed by the acronym.
+func (s *lspServer) complete(p lspPositionParams) (interface{}, error) {
+	items := []map[string]interface{}{}
+	line, w, ok := s.wordAt(p)
+	cursor := lspByteOffset(line, p.Position.Character)
+	if !ok || cursor == w.start {
+		return map[string]interface{}{"isIncomplete": true, "items": items}, nil
+	}
+	// only the part of the word before the cursor is replaced
+	w.text, w.end = line[w.start:cursor], cursor
+	records, err := Storage.FindRecords(SearchQuery{Term: w.text + "%"})
+	if err != nil {
+		return nil, err
+	}
+	incomplete := len(records) > lspMaxCompletions
+	if incomplete {
+		records = records[:lspMaxCompletions]
+	}
+
+	replace := lspWordRange(p.Position.Line, line, w)
+	seen := make(map[string]bool)
+	add := func(label, filter, detail string, r Record) {
+		if seen[label] {
+			return
+		}
+		seen[label] = true
+		items = append(items, map[string]interface{}{
+			"label":         label,
+			"kind":          lspCompletionText,
+			"detail":        detail,
+			"documentation": map[string]string{"kind": "markdown", "value": lspMarkdown(r)},
+			"filterText":    filter,
+			"textEdit":      map[string]interface{}{"range": replace, "newText": label},
+		})
+	}
+	for _, r := range records {
+		add(r.Acronym, r.Acronym, r.Definition, r)
+	}
+	for _, r := range records {
+		add(r.Definition+" ("+r.Acronym+")", r.Acronym, r.Source, r)
+	}
+	return map[string]interface{}{"isIncomplete": incomplete, "items": items}, nil
+}
+
+// codeActions returns a quick fix adding each acronym marked by the
+// diagnostics 'diagnostics' that is spelt out in the document 'uri'.
+func (s *lspServer) codeActions(uri string, diagnostics []lspDiagnostic) []map[string]interface{} {
+	actions := []map[string]interface{}{}
+	text, ok := s.docs[uri]
+	if !ok {
+		return actions
+	}
+	seen := make(map[string]bool)
+	for _, d := range diagnostics {
+		if d.Source != Appname || d.Code != lspUnknownCode {
+			continue
+		}
+		_, w, ok := s.wordAt(lspPositionParams{TextDocument: lspDocument{URI: uri}, Position: d.Range.Start})
+		if !ok || seen[w.text] {
+			continue
+		}
+		seen[w.text] = true
+		definition := findExpansion(text, w.text)
+		if definition == "" {
+			continue
+		}
+		title := fmt.Sprintf("Add '%s' (%s) to the acronyms glossary", w.text, definition)
+		actions = append(actions, map[string]interface{}{
+			"title":       title,
+			"kind":        "quickfix",
+			"diagnostics": []lspDiagnostic{d},
+			"command": map[string]interface{}{
+				"title":     title,
+				"command":   lspAddCommand,
+				"arguments": []lspAddArgs{{Acronym: w.text, Definition: definition}},
+			},
+		})
+	}
+	return actions
+}
+
+// addAcronym adds the acronym 'a' to the glossary, in the same way as
+// AddRecord, then updates the diagnostics of every open document.
+func (s *lspServer) addAcronym(a lspAddArgs) error {
+	// the editor may run the command before opening any document
+	if err := s.loadKnown(); err != nil {
+		return err
+	}
+	existing, err := Storage.FindRecords(SearchQuery{Term: a.Acronym})
+	if err != nil {
+		return err
+	}
+	for _, r := range existing {
+		if strings.EqualFold(r.Definition, a.Definition) {
+			return fmt.Errorf("ERROR: acronym '%s' (%s) is already in the glossary as acronym ID '%d'", r.Acronym, r.Definition, r.ID)
+		}
+	}
+
+	record := Record{
+		Acronym:    strings.TrimSpace(a.Acronym),
+		Definition: strings.TrimSpace(a.Definition),
+		Source:     s.source,
+	}
+	if err := ValidateRecord(&record); err != nil {
+		return err
+	}
+	if err := Storage.InsertRecord(&record); err != nil {
+		return err
+	}
+	if DebugSwitch {
+		log.Printf("DEBUG: language server added acronym ID '%d'\n", record.ID)
+	}
+	s.known[strings.ToUpper(record.Acronym)] = true
+	for uri := range s.docs {
+		if err := s.publish(uri); err != nil {
+			return err
+		}
+	}
+	s.showMessage(lspMessageInfo, fmt.Sprintf("Acronym '%s' added to the glossary as acronym ID '%d'", record.Acronym, record.ID))
+	return nil
+}
+
+// wordAt returns the word at the position 'p', and the line holding it.
+// 'false' is returned if there is no word there.
+func (s *lspServer) wordAt(p lspPositionParams) (string, lspWord, bool) {
+	lines := lspLines(s.docs[p.TextDocument.URI])
+	if p.Position.Line < 0 || p.Position.Line >= len(lines) {
+		return "", lspWord{}, false
+	}
+	line := lines[p.Position.Line]
+	cursor := lspByteOffset(line, p.Position.Character)
+	for _, w := range lspWords(line) {
+		if cursor >= w.start && cursor <= w.end {
+			return line, w, true
+		}
+	}
+	return "", lspWord{}, false
+}
+
+// lspMarkdown returns the acronym record 'r' as shown by the editor.
+func lspMarkdown(r Record) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "**%s** - %s", r.Acronym, r.Definition)
+	if r.Description != "" {
+		fmt.Fprintf(&b, "\n\n%s", r.Description)
+	}
+	if r.Source != "" {
+		fmt.Fprintf(&b, "\n\n*Source: %s*", r.Source)
+	}
+	return b.String()
+}
+
+// lspLines splits the document 'text' into lines, without their line
+// endings.
+func lspLines(text string) []string {
+	lines := strings.Split(text, "\n")
+	for i, line := range lines {
+		lines[i] = strings.TrimSuffix(line, "\r")
+	}
+	return lines
+}
+
+// isWordRune returns true if 'c' can be part of an acronym.
+func isWordRune(c rune) bool {
+	return unicode.IsLetter(c) || unicode.IsDigit(c) || c == '&'
+}
+
+// lspWords returns the words in 'line' that hold at least one letter -
+// runs of letters, digits and '&', without any '&' at either end.
+func lspWords(line string) []lspWord {
+	var words []lspWord
+	start := -1
+	for i, c := range line + " " {
+		if isWordRune(c) {
+			if start < 0 {
+				start = i
+			}
+			continue
+		}
+		if start < 0 {
+			continue
+		}
+		w := lspWord{start: start, end: i}
+		for w.start < w.end && line[w.start] == '&' {
+			w.start++
+		}
+		for w.end > w.start && line[w.end-1] == '&' {
+			w.end--
+		}
+		w.text = line[w.start:w.end]
+		if strings.IndexFunc(w.text, unicode.IsLetter) >= 0 {
+			words = append(words, w)
+		}
+		start = -1
+	}
+	return words
+}
+
+// looksLikeAcronym returns true if the word 'w' starts with a capital
+// letter and at least two, and at least half, of its letters are
+// capitals - such as 'TLS', 'IoT' or 'MP3', but not 'I' or 'Paris'.
+func looksLikeAcronym(w string) bool {
+	first, _ := utf8.DecodeRuneInString(w)
+	if !unicode.IsUpper(first) || utf8.RuneCountInString(w) > maxAcronymLen {
+		return false
+	}
+	upper, letters := 0, 0
+	for _, c := range w {
+		if unicode.IsLetter(c) {
+			letters++
+			if unicode.IsUpper(c) {
+				upper++
+			}
+		}
+	}
+	return upper >= 2 && upper*2 >= letters
+}
+
+// lspByteOffset returns the byte offset in 'line' of the character
+// 'character', counted in UTF-16 code units as LSP does.
+func lspByteOffset(line string, character int) int {
+	units := 0
+	for i, c := range line {
+		if units >= character {
+			return i
+		}
+		units += utf16Len(c)
+	}
+	return len(line)
+}
+
+// lspCharacter returns the character, counted in UTF-16 code units,
+// at the byte offset 'offset' in 'line'.
+func lspCharacter(line string, offset int) int {
+	units := 0
+	for _, c := range line[:offset] {
+		units += utf16Len(c)
+	}
+	return units
+}
+
+// utf16Len returns the number of UTF-16 code units needed for 'c'.
+func utf16Len(c rune) int {
+	if c >= 0x10000 {
+		return 2
+	}
+	return 1
+}
+
+// lspWordRange returns the range of the word 'w' in the line 'line',
+// which is line number 'n' of its document.
+func lspWordRange(n int, line string, w lspWord) lspRange {
+	return lspRange{
+		Start: lspPosition{Line: n, Character: lspCharacter(line, w.start)},
+		End:   lspPosition{Line: n, Character: lspCharacter(line, w.end)},
+	}
+}
+
+// findExpansion returns the expansion of 'acronym' spelt out in 'text',
+// as either 'Transport Layer Security (TLS)' or 'TLS (Transport Layer
+// Security)', or an empty string if it is not.
+func findExpansion(text, acronym string) string {
+	var letters []rune
+	for _, c := range acronym {
+		if unicode.IsLetter(c) {
+			letters = append(letters, unicode.ToLower(c))
+		}
+	}
+	if len(letters) == 0 {
+		return ""
+	}
+
+	// the words before '(TLS)' - back to the start of the sentence
+	for from := 0; ; {
+		i := strings.Index(text[from:], "("+acronym+")")
+		if i < 0 {
+			break
+		}
+		i += from
+		before := text[:i]
+		if cut := strings.LastIndexAny(before, ".;:!?()[]"); cut >= 0 {
+			before = before[cut+1:]
+		}
+		if cut := strings.LastIndex(before, "\n\n"); cut >= 0 {
+			before = before[cut+2:]
+		}
+		words := strings.Fields(before)
+		if start, ok := matchInitials(words, letters); ok {
+			return strings.Join(words[start:], " ")
+		}
+		from = i + 1
+	}
+
+	// the words inside the brackets of 'TLS (...)'
+	for from := 0; ; {
+		i := strings.Index(text[from:], acronym+" (")
+		if i < 0 {
+			break
+		}
+		i += from
+		from = i + 1
+		if c, _ := utf8.DecodeLastRuneInString(text[:i]); i > 0 && isWordRune(c) {
+			continue
+		}
+		inside := text[i+len(acronym)+2:]
+		end := strings.IndexByte(inside, ')')
+		if end < 0 {
+			break
+		}
+		words := strings.Fields(inside[:end])
+		if start, ok := matchInitials(words, letters); ok && start == 0 {
+			return strings.Join(words, " ")
+		}
+	}
+	return ""
+}
+
+// matchInitials matches the last of 'words' to the acronym letters
+// 'letters', working back from the end. Each letter must start a word,
+// though small words such as 'of' may be skipped. The index of the
+// first word matched is returned, and 'true' if every letter was.
+func matchInitials(words []string, letters []rune) (int, bool) {
+	i, j := len(letters)-1, len(words)-1
+	for i >= 0 && j >= 0 {
+		w := strings.TrimFunc(words[j], func(c rune) bool { return !unicode.IsLetter(c) && !unicode.IsDigit(c) })
+		first, _ := utf8.DecodeRuneInString(w)
+		switch {
+		case unicode.ToLower(first) == letters[i]:
+			i--
+		case lspSmallWords[strings.ToLower(w)]:
+		default:
+			return 0, false
+		}
+		j--
+	}
+	return j + 1, i < 0
+}
@@ -0,0 +1,636 @@
+// amt - program to access an SQLite database and lookup acronyms
+//
+// author:	Simon Rowe <simon@wiremoons.com>
+// license: open-source released under The MIT License (MIT).
+//
+// Package used to read acronym records for application 'amt'
+
+package lib
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"fmt"
+	"log"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Record holds a single acronym record, as read from the ACRONYMS
+// table along with the name of its source and any tags it carries.
+//
+// 'ID' is the record's integer primary key, as shown to users in the
+// search output. 'UUID' never changes once the record is created, and
+// identifies the same record across copies of the database. 'Version'
+// is increased by every change made to the record.
+//
+// The created and updated times and authors are filled in by
+// InsertRecord and UpdateRecord. They are zero for records added by
+// earlier versions of 'amt', as their history is not known.
+type Record struct {
+	ID          int64     `json:"id" yaml:"id"`
+	UUID        string    `json:"uuid" yaml:"uuid"`
+	Acronym     string    `json:"acronym" yaml:"acronym"`
+	Definition  string    `json:"definition" yaml:"definition"`
+	Description string    `json:"description" yaml:"description"`
+	Source      string    `json:"source" yaml:"source"`
+	Tags        []string  `json:"tags" yaml:"tags"`
+	CreatedAt   time.Time `json:"created_at" yaml:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at" yaml:"updated_at"`
+	CreatedBy   string    `json:"created_by" yaml:"created_by"`
+	UpdatedBy   string    `json:"updated_by" yaml:"updated_by"`
+	Version     int64     `json:"version" yaml:"version"`
+}
+
+// SearchQuery describes the acronym records to be found by
+// FindRecords.
+type SearchQuery struct {
+	// Term is matched against each acronym using the SQL 'like'
+	// operator, so it may contain the '%' and '_' wildcards. Matches
+	// ignore case. An empty Term matches every acronym.
+	Term string
+	// Wild finds any similar matches - those acronyms or definitions
+	// that contain Term anywhere within them.
+	Wild bool
+	// Tags limits the results to records carrying every tag listed.
+	Tags []string
+	// AddedSince and ChangedSince limit the results to records created,
+	// or last updated, at or after the time given - when not zero.
+	AddedSince   time.Time
+	ChangedSince time.Time
+	// Author limits the results to records created or last updated by
+	// the user named.
+	Author string
+	// Source limits the results to records from the source named,
+	// ignoring case.
+	Source string
+}
+
+// timeFormat is the layout used to hold times in the database - RFC
+// 3339 in UTC, so the text values sort in time order.
+const timeFormat = "2006-01-02T15:04:05Z"
+
+// dbTime returns the time 't' formatted for storing in the database.
+func dbTime(t time.Time) string {
+	return t.UTC().Format(timeFormat)
+}
+
+// parseDBTime converts a time read from the database back into a
+// time.Time. A NULL or invalid value returns the zero time.
+func parseDBTime(value sql.NullString) time.Time {
+	t, err := time.Parse(timeFormat, value.String)
+	if !value.Valid || err != nil {
+		return time.Time{}
+	}
+	return t
+}
+
+// ParseSince converts a search filter typed by the user into the time
+// it refers to. The filter can be an age, given as a number followed
+// by 'h' for hours, 'd' for days or 'w' for weeks - so '30d' is thirty
+// days ago - or a date in the form '2006-01-02'.
+func ParseSince(value string) (time.Time, error) {
+	value = strings.TrimSpace(value)
+	if t, err := time.ParseInLocation("2006-01-02", value, time.Local); err == nil {
+		return t, nil
+	}
+	units := map[string]time.Duration{"h": time.Hour, "d": 24 * time.Hour, "w": 7 * 24 * time.Hour}
+	if len(value) > 1 {
+		if unit, ok := units[strings.ToLower(value[len(value)-1:])]; ok {
+			if n, err := strconv.Atoi(value[:len(value)-1]); err == nil && n >= 0 {
+				return time.Now().Add(-time.Duration(n) * unit), nil
+			}
+		}
+	}
+	return time.Time{}, fmt.Errorf("ERROR: '%s' is not an age such as '30d', '2w' or '12h', or a date such as '2006-01-02'", value)
+}
+
+// tagSeparator is used to join tag names read with 'group_concat' -
+// a control character that never appears in a tag name.
+const tagSeparator = "\x1f"
+
+// recordQuery is the select statement used to read full acronym
+// records. The source name is joined in from the SOURCES table, so
+// callers see the same 'Source' value that used to be held in the
+// ACRONYMS table itself, and the record's tags are gathered into a
+// single column. Callers append their own 'where' and 'order by'
+// clauses.
+const recordQuery = `select a.AcronymID, a.UUID, coalesce(a.Acronym, ''), coalesce(a.Definition, ''),
+	coalesce(a.Description, ''), coalesce(s.Name, ''),
+	coalesce((select group_concat(t.Name, char(31)) from ACRONYM_TAGS at
+		join TAGS t on t.TagID = at.TagID where at.AcronymID = a.AcronymID), ''),
+	a.CreatedAt, a.UpdatedAt, coalesce(a.CreatedBy, ''), coalesce(a.UpdatedBy, ''),
+	a.Version
+	from ACRONYMS a left join SOURCES s on s.SourceID = a.SourceID`
+
+// scanRecord reads a single row returned by 'recordQuery' into a
+// Record.
+func scanRecord(row interface{ Scan(...interface{}) error }) (Record, error) {
+	var r Record
+	var tags string
+	var created, updated sql.NullString
+	err := row.Scan(&r.ID, &r.UUID, &r.Acronym, &r.Definition, &r.Description, &r.Source, &tags,
+		&created, &updated, &r.CreatedBy, &r.UpdatedBy, &r.Version)
+	r.CreatedAt = parseDBTime(created)
+	r.UpdatedAt = parseDBTime(updated)
+	if tags != "" {
+		r.Tags = strings.Split(tags, tagSeparator)
+		sort.Strings(r.Tags)
+	}
+	return r, err
+}
+
+// FindRecords returns every acronym record matching the SearchQuery
+// 'q', ordered by acronym and then source.
+func FindRecords(q SearchQuery) ([]Record, error) {
+	var where []string
+	var args []interface{}
+
+	term := q.Term
+	if term == "" {
+		term = "%"
+	}
+	if q.Wild {
+		where = append(where, "(a.Acronym like ? or a.Definition like ?)")
+		args = append(args, "%"+term+"%", "%"+term+"%")
+	} else {
+		where = append(where, "a.Acronym like ?")
+		args = append(args, term)
+	}
+	for _, tag := range q.Tags {
+		where = append(where, `a.AcronymID in (select at.AcronymID from ACRONYM_TAGS at
+			join TAGS t on t.TagID = at.TagID where t.Name = ?)`)
+		args = append(args, normaliseTag(tag))
+	}
+	if !q.AddedSince.IsZero() {
+		where = append(where, "a.CreatedAt >= ?")
+		args = append(args, dbTime(q.AddedSince))
+	}
+	if !q.ChangedSince.IsZero() {
+		where = append(where, "a.UpdatedAt >= ?")
+		args = append(args, dbTime(q.ChangedSince))
+	}
+	if q.Author != "" {
+		where = append(where, "(a.CreatedBy = ? or a.UpdatedBy = ?)")
+		args = append(args, q.Author, q.Author)
+	}
+	if q.Source != "" {
+		where = append(where, "s.Name = ? collate nocase")
+		args = append(args, q.Source)
+	}
+
+	query := recordQuery + " where " + strings.Join(where, " and ") + " order by a.Acronym, s.Name;"
+	if DebugSwitch {
+		log.Printf("DEBUG: running search query: %s with values: %v\n", query, args)
+	}
+	rows, err := DB.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("ERROR: searching for acronym records: %v", err)
+	}
+	defer rows.Close()
+
+	var records []Record
+	for rows.Next() {
+		r, err := scanRecord(rows)
+		if err != nil {
+			return nil, fmt.Errorf("ERROR: reading database record: %v", err)
+		}
+		records = append(records, r)
+	}
+	return records, rows.Err()
+}
+
+// GetRecord returns the acronym record with the ID 'id'. The error
+// 'sql.ErrNoRows' is returned if there is no such record.
+func GetRecord(id int64) (Record, error) {
+	return getRecord(DB, id)
+}
+
+// getRecord reads the acronym record with the ID 'id' through 'q'.
+func getRecord(q dbQuerier, id int64) (Record, error) {
+	return scanRecord(q.QueryRow(recordQuery+" where a.AcronymID = ?;", id))
+}
+
+// GetRecordByUUID returns the acronym record with the UUID 'uuid'.
+// The error 'sql.ErrNoRows' is returned if there is no such record.
+func GetRecordByUUID(uuid string) (Record, error) {
+	return getRecordByUUID(DB, uuid)
+}
+
+// getRecordByUUID reads the acronym record with the UUID 'uuid'
+// through 'q'.
+func getRecordByUUID(q dbQuerier, uuid string) (Record, error) {
+	return scanRecord(q.QueryRow(recordQuery+" where a.UUID = ?;", strings.ToLower(uuid)))
+}
+
+// LookupRecord returns the acronym record identified by 'value', which
+// may be either its ID number or its UUID. The error 'sql.ErrNoRows' is
+// returned if there is no such record.
+func LookupRecord(value string) (Record, error) {
+	if IsUUID(value) {
+		return GetRecordByUUID(value)
+	}
+	id, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return Record{}, fmt.Errorf("ERROR: acronym ID '%s' is not a valid number or UUID", value)
+	}
+	return GetRecord(id)
+}
+
+// NewUUID returns a new random (version 4) UUID, as given to each new
+// acronym record.
+func NewUUID() string {
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		log.Fatalf("FATAL ERROR: unable to generate a new UUID: %v\n", err)
+	}
+	b[6] = (b[6] & 0x0f) | 0x40
+	b[8] = (b[8] & 0x3f) | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16])
+}
+
+// uuidPattern matches the text form of a UUID.
+var uuidPattern = regexp.MustCompile(`^[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}$`)
+
+// IsUUID reports whether 'value' is in the text form of a UUID.
+func IsUUID(value string) bool {
+	return uuidPattern.MatchString(value)
+}
+
+// The longest values accepted for an acronym and for a source name.
+const (
+	maxAcronymLen = 64
+	maxSourceLen  = 128
+)
+
+// ValidateRecord checks the acronym record 'r' can be stored. An
+// acronym and its expanded version are required, values must not hold
+// control characters - other than line breaks in a description - and
+// any UUID and tags must be valid.
+func ValidateRecord(r *Record) error {
+	return validateRecord(r, true)
+}
+
+// validateRecord checks the acronym record 'r' as ValidateRecord does,
+// but only requires an acronym and expanded version if 'required' is
+// set. Records added by early versions of amt may be missing either,
+// and are still imported from an export file just as they were held.
+func validateRecord(r *Record, required bool) error {
+	if required && strings.TrimSpace(r.Acronym) == "" {
+		return fmt.Errorf("ERROR: an acronym must be provided")
+	}
+	if utf8.RuneCountInString(r.Acronym) > maxAcronymLen {
+		return fmt.Errorf("ERROR: acronym '%s' is longer than %d characters", r.Acronym, maxAcronymLen)
+	}
+	if required && strings.TrimSpace(r.Definition) == "" {
+		return fmt.Errorf("ERROR: the expanded version of acronym '%s' must be provided", r.Acronym)
+	}
+	if utf8.RuneCountInString(r.Source) > maxSourceLen {
+		return fmt.Errorf("ERROR: source '%s' is longer than %d characters", r.Source, maxSourceLen)
+	}
+	for _, f := range []struct {
+		name, value string
+		lines       bool
+	}{
+		{"acronym", r.Acronym, false},
+		{"expanded version", r.Definition, false},
+		{"description", r.Description, true},
+		{"source", r.Source, false},
+	} {
+		if strings.IndexFunc(f.value, func(c rune) bool {
+			return unicode.IsControl(c) && !(f.lines && (c == '\n' || c == '\r' || c == '\t'))
+		}) >= 0 {
+			return fmt.Errorf("ERROR: the %s of acronym '%s' contains control characters", f.name, r.Acronym)
+		}
+		if !utf8.ValidString(f.value) {
+			return fmt.Errorf("ERROR: the %s of acronym '%s' is not valid UTF-8 text", f.name, r.Acronym)
+		}
+	}
+	if r.UUID != "" && !IsUUID(r.UUID) {
+		return fmt.Errorf("ERROR: '%s' is not a valid UUID", r.UUID)
+	}
+	for _, tag := range r.Tags {
+		if err := ValidateTag(tag); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// printRecord displays a single acronym record on stdout in the
+// format used by all the search output.
+func printRecord(r Record) {
+	fmt.Printf("ID: %d\nUUID: %s\nACRONYM: '%s' is: %s.\nDESCRIPTION: %s\nSOURCE: %s\n",
+		r.ID, r.UUID, r.Acronym, r.Definition, r.Description, r.Source)
+	if len(r.Tags) > 0 {
+		fmt.Printf("TAGS: %s\n", strings.Join(r.Tags, ", "))
+	}
+	if !r.CreatedAt.IsZero() {
+		fmt.Printf("ADDED: %s by %s\n", r.CreatedAt.Local().Format("2006-01-02 15:04"), r.CreatedBy)
+	}
+	if !r.UpdatedAt.IsZero() && !r.UpdatedAt.Equal(r.CreatedAt) {
+		fmt.Printf("UPDATED: %s by %s\n", r.UpdatedAt.Local().Format("2006-01-02 15:04"), r.UpdatedBy)
+	}
+	fmt.Println()
+}
+
+// InsertRecord adds the acronym record 'r' to the ACRONYMS table,
+// along with its tags. The source is added to the SOURCES table first
+// if it is new. The created and updated times and authors are set to
+// now and 'author'. The record is given a new UUID, unless
+// 'r.UUID' is already set, and the new record's ID is stored in
+// 'r.ID'. The new record is added to the change history. The whole
+// change is saved in a single transaction.
+//
+// The SQL insert statement used is:
+//
+//	insert into ACRONYMS(UUID, Acronym, Definition, Description, SourceID,
+//	CreatedAt, UpdatedAt, CreatedBy, UpdatedBy) values(?,?,?,?,?,?,?,?,?)
+func InsertRecord(r *Record, author string) error {
+	return withTx(author, func(tx *dbTx) error { return insertRecord(tx, r) })
+}
+
+// insertRecord adds the acronym record 'r' within the transaction 'tx'.
+func insertRecord(tx *dbTx, r *Record) error {
+	sourceID, err := sourceID(tx, r.Source)
+	if err != nil {
+		return err
+	}
+	r.CreatedAt = time.Now().UTC().Truncate(time.Second)
+	r.UpdatedAt = r.CreatedAt
+	r.CreatedBy = tx.author
+	r.UpdatedBy = r.CreatedBy
+	if r.UUID == "" {
+		r.UUID = NewUUID()
+	}
+
+	result, err := tx.Exec(`insert into ACRONYMS(UUID, Acronym, Definition, Description, SourceID,
+		CreatedAt, UpdatedAt, CreatedBy, UpdatedBy) values(?,?,?,?,?,?,?,?,?)`,
+		strings.ToLower(r.UUID), r.Acronym, r.Definition, r.Description, sourceID,
+		dbTime(r.CreatedAt), dbTime(r.UpdatedAt), r.CreatedBy, r.UpdatedBy)
+	if err != nil {
+		return fmt.Errorf("ERROR: inserting new acronym record: %v", err)
+	}
+	if r.ID, err = result.LastInsertId(); err != nil {
+		return fmt.Errorf("ERROR: reading ID of new acronym record: %v", err)
+	}
+	if DebugSwitch {
+		log.Printf("DEBUG: inserted new acronym record ID: %d\n", r.ID)
+	}
+	if _, err = addTags(tx, r.ID, r.Tags); err != nil {
+		return err
+	}
+	return auditChange(tx, auditInsert, r.ID, nil)
+}
+
+// UpdateRecord saves changes made to the acronym, definition,
+// description, source and tags of the existing record 'r', identified
+// by 'r.ID'. The updated time and author are set to now and 'author',
+// and the change is added to the record's history. The error
+// 'sql.ErrNoRows' is returned if there is no such record.
+//
+// 'r.Version' must be the version of the record the changes were made
+// to. If the record has been changed since, the update is refused with
+// a '*ConflictError' holding both versions. Otherwise 'r.Version' is
+// set to the record's new version.
+//
+// The SQL update statement used is:
+//
+//	update ACRONYMS set Acronym = ?, Definition = ?, Description = ?,
+//	SourceID = ?, UpdatedAt = ?, UpdatedBy = ?, Version = Version + 1
+//	where AcronymID = ? and Version = ?
+func UpdateRecord(r *Record, author string) error {
+	base := r.Version
+	return withTx(author, func(tx *dbTx) error {
+		r.Version = base
+		return updateRecord(tx, r)
+	})
+}
+
+// updateRecord saves changes made to the acronym record 'r' within the
+// transaction 'tx'.
+func updateRecord(tx *dbTx, r *Record) error {
+	old, err := getRecord(tx, r.ID)
+	if err != nil {
+		return err
+	}
+	if old.Version != r.Version {
+		return &ConflictError{Yours: *r, Theirs: old}
+	}
+	sourceID, err := sourceID(tx, r.Source)
+	if err != nil {
+		return err
+	}
+	r.UpdatedAt = time.Now().UTC().Truncate(time.Second)
+	r.UpdatedBy = tx.author
+
+	result, err := tx.Exec(`update ACRONYMS set Acronym = ?, Definition = ?, Description = ?,
+		SourceID = ?, UpdatedAt = ?, UpdatedBy = ?, Version = Version + 1
+		where AcronymID = ? and Version = ?`,
+		r.Acronym, r.Definition, r.Description, sourceID, dbTime(r.UpdatedAt), r.UpdatedBy, r.ID, r.Version)
+	if err != nil {
+		return fmt.Errorf("ERROR: updating acronym record ID '%d': %v", r.ID, err)
+	}
+	if err = checkRowsAffected(result, 1); err != nil {
+		return fmt.Errorf("ERROR: updating acronym record ID '%d': %v", r.ID, err)
+	}
+	r.Version++
+	if _, err = setTags(tx, r.ID, r.Tags); err != nil {
+		return err
+	}
+	return auditChange(tx, auditUpdate, r.ID, &old)
+}
+
+// DeleteRecord removes the acronym record with the ID 'id' from the
+// ACRONYMS table, along with its tags. The record is moved to the
+// TRASH table, from where it can be restored, and the ID of its trash
+// entry is returned. The removed values are also kept in the record's
+// history, as removed by 'author'. The error 'sql.ErrNoRows' is
+// returned if there is no such record.
+//
+// The SQL delete statement used is:
+//
+//	delete from ACRONYMS where AcronymID = ?;
+func DeleteRecord(id int64, author string) (trashID int64, err error) {
+	err = withTx(author, func(tx *dbTx) error {
+		trashID, err = deleteRecord(tx, id, auditDelete)
+		return err
+	})
+	return trashID, err
+}
+
+// DeleteRecordVersion moves the acronym record 'r' to the trash as
+// DeleteRecord does, but only if it is still at version 'r.Version'. A
+// *ConflictError is returned if another user has changed it since.
+func DeleteRecordVersion(r Record, author string) (trashID int64, err error) {
+	err = withTx(author, func(tx *dbTx) error {
+		old, err := getRecord(tx, r.ID)
+		if err != nil {
+			return err
+		}
+		if old.Version != r.Version {
+			return &ConflictError{Yours: r, Theirs: old}
+		}
+		trashID, err = deleteRecord(tx, r.ID, auditDelete)
+		return err
+	})
+	return trashID, err
+}
+
+// deleteRecord moves the acronym record with the ID 'id' to the
+// trash within the transaction 'tx', adding the change to its history
+// as 'action'.
+func deleteRecord(tx *dbTx, id int64, action string) (trashID int64, err error) {
+	old, err := getRecord(tx, id)
+	if err != nil {
+		return 0, err
+	}
+	values, err := recordJSON(&old)
+	if err != nil {
+		return 0, err
+	}
+	result, err := tx.Exec("insert into TRASH(AcronymID, RecordValues, DeletedAt, DeletedBy) values(?,?,?,?);",
+		id, values, dbTime(time.Now()), tx.author)
+	if err != nil {
+		return 0, fmt.Errorf("ERROR: moving acronym record ID '%d' to the trash: %v", id, err)
+	}
+	if trashID, err = result.LastInsertId(); err != nil {
+		return 0, fmt.Errorf("ERROR: reading trash ID of acronym record ID '%d': %v", id, err)
+	}
+	result, err = tx.Exec("delete from ACRONYMS where AcronymID = ?;", id)
+	if err != nil {
+		return 0, fmt.Errorf("ERROR: removing acronym record ID '%d': %v", id, err)
+	}
+	if err = checkRowsAffected(result, 1); err != nil {
+		return 0, fmt.Errorf("ERROR: removing acronym record ID '%d': %v", id, err)
+	}
+	return trashID, auditChange(tx, action, id, &old)
+}
+
+// storeRecord inserts every value of the acronym record 'r' - including
+// its times and authors - as held before it was removed. The record
+// keeps its old ID if that is still free, otherwise it is given a new
+// one, which is stored in 'r.ID'. Likewise it keeps its UUID unless
+// that is missing or already in use. The record is stored with the
+// version held in 'r.Version'.
+func storeRecord(tx *dbTx, r *Record) error {
+	var taken int
+	if err := tx.QueryRow("select count(*) from ACRONYMS where AcronymID = ?;", r.ID).Scan(&taken); err != nil {
+		return fmt.Errorf("ERROR: checking acronym ID '%d' is free: %v", r.ID, err)
+	}
+	var rowid interface{}
+	if taken == 0 && r.ID > 0 {
+		rowid = r.ID
+	}
+	if _, err := getRecordByUUID(tx, r.UUID); r.UUID == "" || err != sql.ErrNoRows {
+		r.UUID = NewUUID()
+	}
+	sourceID, err := sourceID(tx, r.Source)
+	if err != nil {
+		return err
+	}
+	if r.Version < 1 {
+		r.Version = 1
+	}
+	result, err := tx.Exec(`insert into ACRONYMS(AcronymID, UUID, Acronym, Definition, Description, SourceID,
+		CreatedAt, UpdatedAt, CreatedBy, UpdatedBy, Version) values(?,?,?,?,?,?,?,?,?,?,?)`,
+		rowid, strings.ToLower(r.UUID), r.Acronym, r.Definition, r.Description, sourceID,
+		nullTime(r.CreatedAt), nullTime(r.UpdatedAt), r.CreatedBy, r.UpdatedBy, r.Version)
+	if err != nil {
+		return fmt.Errorf("ERROR: restoring acronym record: %v", err)
+	}
+	if r.ID, err = result.LastInsertId(); err != nil {
+		return fmt.Errorf("ERROR: reading ID of restored acronym record: %v", err)
+	}
+	_, err = addTags(tx, r.ID, r.Tags)
+	return err
+}
+
+// replaceRecord saves every value of the existing acronym record 'r' -
+// including its times, authors and version - within the transaction
+// 'tx', so the record is held exactly as given. It is used to update a
+// record from an export file.
+func replaceRecord(tx *dbTx, r *Record) error {
+	old, err := getRecord(tx, r.ID)
+	if err != nil {
+		return err
+	}
+	sourceID, err := sourceID(tx, r.Source)
+	if err != nil {
+		return err
+	}
+	if r.Version < 1 {
+		r.Version = 1
+	}
+	result, err := tx.Exec(`update ACRONYMS set Acronym = ?, Definition = ?, Description = ?, SourceID = ?,
+		CreatedAt = ?, UpdatedAt = ?, CreatedBy = ?, UpdatedBy = ?, Version = ? where AcronymID = ?`,
+		r.Acronym, r.Definition, r.Description, sourceID, nullTime(r.CreatedAt), nullTime(r.UpdatedAt),
+		r.CreatedBy, r.UpdatedBy, r.Version, r.ID)
+	if err != nil {
+		return fmt.Errorf("ERROR: replacing acronym record ID '%d': %v", r.ID, err)
+	}
+	if err = checkRowsAffected(result, 1); err != nil {
+		return fmt.Errorf("ERROR: replacing acronym record ID '%d': %v", r.ID, err)
+	}
+	if _, err = setTags(tx, r.ID, r.Tags); err != nil {
+		return err
+	}
+	return auditChange(tx, auditUpdate, r.ID, &old)
+}
+
+// revertRecord puts back the acronym, definition, description, source
+// and tags of the acronym record 'r' as they were held before a change.
+// The updated time and author are set to now and the author of 'tx'.
+func revertRecord(tx *dbTx, r *Record) error {
+	sourceID, err := sourceID(tx, r.Source)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(`update ACRONYMS set Acronym = ?, Definition = ?, Description = ?,
+		SourceID = ?, UpdatedAt = ?, UpdatedBy = ?, Version = Version + 1 where AcronymID = ?`,
+		r.Acronym, r.Definition, r.Description, sourceID, dbTime(time.Now()), tx.author, r.ID)
+	if err != nil {
+		return fmt.Errorf("ERROR: reverting acronym record ID '%d': %v", r.ID, err)
+	}
+	_, err = setTags(tx, r.ID, r.Tags)
+	return err
+}
+
+// nullTime returns the time 't' formatted for storing in the database,
+// or NULL if it is the zero time.
+func nullTime(t time.Time) interface{} {
+	if t.IsZero() {
+		return nil
+	}
+	return dbTime(t)
+}
+
+// touchRecord sets the updated time and author of the acronym record
+// with the ID 'id' - used when a change is made to the record other
+// than through UpdateRecord, such as to its tags.
+func touchRecord(tx *dbTx, id int64) error {
+	_, err := tx.Exec("update ACRONYMS set UpdatedAt = ?, UpdatedBy = ?, Version = Version + 1 where AcronymID = ?",
+		dbTime(time.Now()), tx.author, id)
+	if err != nil {
+		return fmt.Errorf("ERROR: updating acronym record ID '%d': %v", id, err)
+	}
+	return nil
+}
+
+// checkRowsAffected confirms the statement that returned 'result'
+// changed exactly 'want' rows.
+func checkRowsAffected(result sql.Result, want int64) error {
+	n, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("unable to read the number of rows changed: %v", err)
+	}
+	if n != want {
+		return fmt.Errorf("%d rows changed where %d were expected", n, want)
+	}
+	return nil
+}
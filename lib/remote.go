@@ -0,0 +1,322 @@
+// amt - program to access an SQLite database and lookup acronyms
+//
+// author:	Simon Rowe <simon@wiremoons.com>
+// license: open-source released under The MIT License (MIT).
+//
+// Package used to work with the acronyms held by an amt HTTP server
+// for application 'amt'
+//
+// When a server address is given with '-server', or as 'server' in the
+// configuration file, the command line sends its searches and changes
+// to the HTTP API offered by 'amt serve' on that server, in place of a
+// local database. The API token sent with each request is taken from
+// the environment variable AMTTOKEN, or 'token' in the configuration
+// file.
+
+package lib
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dustin/go-humanize"
+)
+
+// remoteTimeout is the longest wait for the server to answer a request.
+const remoteTimeout = 30 * time.Second
+
+// remoteStore holds the acronym records on an amt HTTP server.
+type remoteStore struct {
+	base   *url.URL
+	token  string
+	client *http.Client
+}
+
+// remoteError holds the error sent by the server, along with the
+// current record when a change conflicts with another user's.
+type remoteError struct {
+	Error   string  `json:"error"`
+	Current *Record `json:"current"`
+}
+
+// OpenServer connects to the amt HTTP server at 'ServerURL', so
+// 'Storage' uses it in place of the local database, and shows the
+// details of the acronyms it holds.
+func OpenServer() error {
+	address := ServerURL
+	if !strings.Contains(address, "://") {
+		address = "http://" + address
+	}
+	base, err := url.Parse(address)
+	if err != nil || base.Host == "" || (base.Scheme != "http" && base.Scheme != "https") {
+		return fmt.Errorf("ERROR: server address '%s' is not a valid http or https URL", ServerURL)
+	}
+	base.Path = strings.TrimSuffix(base.Path, "/")
+	s := &remoteStore{
+		base:   base,
+		token:  os.Getenv("AMTTOKEN"),
+		client: &http.Client{Timeout: remoteTimeout},
+	}
+	if s.token == "" {
+		s.token = Settings.Token
+	}
+
+	st, err := s.GetStats()
+	if err != nil {
+		return err
+	}
+	Storage = s
+	RecCount = st.Records
+	if Quiet {
+		return nil
+	}
+	fmt.Printf("Server location: %s\n", base)
+	fmt.Println("Server connection status:  √")
+	fmt.Printf("Current record count is:  %s\n", humanize.Comma(RecCount))
+	fmt.Printf("Last acronym entered was:  '%s'\n", st.LastAcronym)
+	return nil
+}
+
+// call sends a request to the server API at 'path', with 'query' and,
+// unless nil, 'body' as JSON. The JSON reply is read into 'out'. The
+// header 'ifMatch' is sent when not empty.
+func (s *remoteStore) call(method, path string, query url.Values, ifMatch string, body, out interface{}) error {
+	u := *s.base
+	u.Path += path
+	u.RawQuery = query.Encode()
+
+	var content io.Reader
+	if body != nil {
+		b, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		content = bytes.NewReader(b)
+	}
+	req, err := http.NewRequest(method, u.String(), content)
+	if err != nil {
+		return fmt.Errorf("ERROR: unable to make request to the amt server: %v", err)
+	}
+	req.Header.Set("Accept", "application/json")
+	req.Header.Set("User-Agent", Appname+"/"+Appversion)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if ifMatch != "" {
+		req.Header.Set("If-Match", ifMatch)
+	}
+	if s.token != "" {
+		req.Header.Set("Authorization", "Bearer "+s.token)
+	}
+	if DebugSwitch {
+		log.Printf("DEBUG: sending %s %s to the amt server\n", method, u.String())
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("ERROR: unable to reach the amt server at '%s': %v", s.base, err)
+	}
+	defer resp.Body.Close()
+	dec := json.NewDecoder(io.LimitReader(resp.Body, 64<<20))
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		if out == nil {
+			return nil
+		}
+		if err = dec.Decode(out); err != nil {
+			return fmt.Errorf("ERROR: unable to read the reply from the amt server: %v", err)
+		}
+		return nil
+	}
+
+	var e remoteError
+	if dec.Decode(&e) != nil || e.Error == "" {
+		e.Error = resp.Status
+	}
+	if DebugSwitch {
+		log.Printf("DEBUG: amt server replied: %s - %s\n", resp.Status, e.Error)
+	}
+	switch {
+	case resp.StatusCode == http.StatusNotFound && strings.HasPrefix(path, "/api/acronyms/"),
+		resp.StatusCode == http.StatusNotFound && strings.HasPrefix(path, "/api/trash/"):
+		return sql.ErrNoRows
+	case resp.StatusCode == http.StatusPreconditionFailed && e.Current != nil && body != nil:
+		yours, _ := body.(*remoteRecord)
+		c := &ConflictError{Theirs: *e.Current}
+		if yours != nil {
+			c.Yours = yours.record
+		}
+		return c
+	case resp.StatusCode == http.StatusForbidden && "ERROR: "+e.Error == ErrReadOnly.Error():
+		return ErrReadOnly
+	case resp.StatusCode == http.StatusUnauthorized:
+		return fmt.Errorf("ERROR: the amt server needs a valid API token - set AMTTOKEN, or 'token' in the configuration file: %s", e.Error)
+	}
+	return fmt.Errorf("ERROR: the amt server refused the request: %s", e.Error)
+}
+
+// remoteRecord holds the values of an acronym record sent to the server
+// to add or change it.
+type remoteRecord struct {
+	record      Record
+	UUID        string   `json:"uuid,omitempty"`
+	Acronym     string   `json:"acronym"`
+	Definition  string   `json:"definition"`
+	Description string   `json:"description"`
+	Source      string   `json:"source"`
+	Tags        []string `json:"tags"`
+}
+
+// newRemoteRecord returns the values of the acronym record 'r' to send
+// to the server. The tags are always sent, so removing the last tag
+// from a record is saved.
+func newRemoteRecord(r Record) *remoteRecord {
+	tags := r.Tags
+	if tags == nil {
+		tags = []string{}
+	}
+	return &remoteRecord{
+		record:      r,
+		UUID:        r.UUID,
+		Acronym:     r.Acronym,
+		Definition:  r.Definition,
+		Description: r.Description,
+		Source:      r.Source,
+		Tags:        tags,
+	}
+}
+
+// FindRecords returns every acronym record matching 'q', reading each
+// page of results from the server in turn.
+func (s *remoteStore) FindRecords(q SearchQuery) ([]Record, error) {
+	query := url.Values{}
+	query.Set("q", q.Term)
+	query.Set("wild", strconv.FormatBool(q.Wild))
+	if len(q.Tags) > 0 {
+		query.Set("tags", strings.Join(q.Tags, ","))
+	}
+	if !q.AddedSince.IsZero() {
+		query.Set("added", q.AddedSince.Format(time.RFC3339))
+	}
+	if !q.ChangedSince.IsZero() {
+		query.Set("changed", q.ChangedSince.Format(time.RFC3339))
+	}
+	if q.Author != "" {
+		query.Set("author", q.Author)
+	}
+	if q.Source != "" {
+		query.Set("source", q.Source)
+	}
+	query.Set("limit", strconv.Itoa(apiMaxLimit))
+
+	var records []Record
+	for {
+		query.Set("offset", strconv.Itoa(len(records)))
+		var page apiSearchResult
+		if err := s.call(http.MethodGet, "/api/acronyms", query, "", nil, &page); err != nil {
+			return nil, err
+		}
+		records = append(records, page.Records...)
+		if len(page.Records) == 0 || len(records) >= page.Total {
+			return records, nil
+		}
+	}
+}
+
+// LookupRecord returns the acronym record with the ID or UUID 'value'.
+func (s *remoteStore) LookupRecord(value string) (r Record, err error) {
+	if !isNumber(value) && !IsUUID(value) {
+		return r, fmt.Errorf("ERROR: acronym ID '%s' is not a valid number or UUID", value)
+	}
+	err = s.call(http.MethodGet, "/api/acronyms/"+value, nil, "", nil, &r)
+	return r, err
+}
+
+// InsertRecord adds the new acronym record 'r' on the server.
+func (s *remoteStore) InsertRecord(r *Record) error {
+	return s.call(http.MethodPost, "/api/acronyms", nil, "", newRemoteRecord(*r), r)
+}
+
+// UpdateRecord saves the changes made to the acronym record 'r' on the
+// server, if it is still at version 'r.Version' there.
+func (s *remoteStore) UpdateRecord(r *Record) error {
+	return s.call(http.MethodPut, fmt.Sprintf("/api/acronyms/%d", r.ID), nil, recordETag(*r), newRemoteRecord(*r), r)
+}
+
+// DeleteRecord moves the acronym record with the ID 'id' to the trash
+// on the server.
+func (s *remoteStore) DeleteRecord(id int64) (int64, error) {
+	var out struct {
+		TrashID int64 `json:"trash_id"`
+	}
+	err := s.call(http.MethodDelete, fmt.Sprintf("/api/acronyms/%d", id), nil, "", nil, &out)
+	return out.TrashID, err
+}
+
+// ListTrash returns the removed acronym records held on the server.
+func (s *remoteStore) ListTrash() (items []TrashItem, err error) {
+	err = s.call(http.MethodGet, "/api/trash", nil, "", nil, &items)
+	return items, err
+}
+
+// RestoreRecord brings back the removed acronym record with the trash
+// ID 'trashID' on the server.
+func (s *remoteStore) RestoreRecord(trashID int64) (r Record, err error) {
+	err = s.call(http.MethodPost, fmt.Sprintf("/api/trash/%d/restore", trashID), nil, "", struct{}{}, &r)
+	return r, err
+}
+
+// RecordHistory returns every change made to the acronym record with
+// the ID or UUID 'value' on the server, along with its ID.
+func (s *remoteStore) RecordHistory(value string) (int64, []AuditEntry, error) {
+	if !isNumber(value) && !IsUUID(value) {
+		return 0, nil, fmt.Errorf("ERROR: acronym ID '%s' is not a valid number or UUID", value)
+	}
+	var out apiHistory
+	err := s.call(http.MethodGet, "/api/acronyms/"+value+"/history", nil, "", nil, &out)
+	return out.ID, out.History, err
+}
+
+// TagRecord adds the tags 'tags' to the acronym record with the ID
+// 'id' on the server.
+func (s *remoteStore) TagRecord(id int64, tags []string) (int64, error) {
+	var out apiTagsChanged
+	err := s.call(http.MethodPost, fmt.Sprintf("/api/acronyms/%d/tags", id), nil, "", apiTagsInput{Tags: tags}, &out)
+	return out.Changed, err
+}
+
+// UntagRecord removes the tags 'tags' from the acronym record with the
+// ID 'id' on the server.
+func (s *remoteStore) UntagRecord(id int64, tags []string) (int64, error) {
+	var out apiTagsChanged
+	query := url.Values{"tags": {strings.Join(tags, ",")}}
+	err := s.call(http.MethodDelete, fmt.Sprintf("/api/acronyms/%d/tags", id), query, "", nil, &out)
+	return out.Changed, err
+}
+
+// ListSources returns the sources held on the server.
+func (s *remoteStore) ListSources() (sources []Source, err error) {
+	err = s.call(http.MethodGet, "/api/sources", nil, "", nil, &sources)
+	return sources, err
+}
+
+// ListTags returns the tags held on the server.
+func (s *remoteStore) ListTags() (tags []Tag, err error) {
+	err = s.call(http.MethodGet, "/api/tags", nil, "", nil, &tags)
+	return tags, err
+}
+
+// GetStats returns a summary of the acronyms held on the server.
+func (s *remoteStore) GetStats() (st Stats, err error) {
+	err = s.call(http.MethodGet, "/api/stats", nil, "", nil, &st)
+	return st, err
+}
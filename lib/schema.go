@@ -0,0 +1,376 @@
+// amt - program to access an SQLite database and lookup acronyms
+//
+// author:	Simon Rowe <simon@wiremoons.com>
+// license: open-source released under The MIT License (MIT).
+//
+// Package used to create and upgrade the SQLite database schema for
+// application 'amt'
+//
+// The schema version of a database is held in the SQLite
+// 'PRAGMA user_version' value. Each entry in the 'migrations' slice
+// below upgrades the schema by one version, and they are always
+// applied in order. A new database starts at version 0, so every
+// migration is run against it in turn.
+
+package lib
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+)
+
+// migration holds a single schema upgrade step. The 'apply' function
+// is run inside a transaction with foreign key enforcement switched
+// off, so a table can be rebuilt without tripping over constraints.
+type migration struct {
+	description string
+	apply       func(tx *sql.Tx) error
+}
+
+// migrations lists every schema upgrade in the order it must be
+// applied. The schema version of a database is the number of entries
+// that have been applied to it - so new entries must only ever be
+// appended to the end of the list.
+var migrations = []migration{
+	{
+		description: "create the original ACRONYMS table",
+		apply:       execMigration(createAcronymsTable),
+	},
+	{
+		description: "move sources into their own SOURCES table",
+		apply:       execMigration(normaliseSources),
+	},
+	{
+		description: "add TAGS and the ACRONYM_TAGS join table",
+		apply:       execMigration(createTagTables),
+	},
+	{
+		description: "add created and updated timestamps and authors to ACRONYMS",
+		apply:       execMigration(addRecordTimestamps),
+	},
+	{
+		description: "add the AUDIT table holding the change history of records",
+		apply:       execMigration(createAuditTable),
+	},
+	{
+		description: "add the TRASH table for removed records and undo tracking",
+		apply:       execMigration(createTrashTable),
+	},
+	{
+		description: "give ACRONYMS an explicit primary key and a permanent UUID",
+		apply:       execMigration(addRecordKeys),
+	},
+	{
+		description: "add a version counter to ACRONYMS for detecting conflicting edits",
+		apply:       execMigration(addRecordVersion),
+	},
+	{
+		description: "add the TOKENS table holding API tokens for the HTTP server",
+		apply:       execMigration(createTokensTable),
+	},
+}
+
+// createAcronymsTable is the original 'amt' table layout. Databases
+// created by earlier versions of 'amt' will already have it.
+const createAcronymsTable = `
+CREATE TABLE IF NOT EXISTS ACRONYMS (
+	Acronym TEXT,
+	Definition TEXT,
+	Description TEXT,
+	Source TEXT
+);`
+
+// normaliseSources moves the free text 'Source' column of ACRONYMS
+// into a SOURCES table, and rebuilds ACRONYMS to reference it by
+// foreign key. Existing rowid values are carried over unchanged as
+// users will have recorded them from search output.
+const normaliseSources = `
+CREATE TABLE SOURCES (
+	SourceID INTEGER PRIMARY KEY,
+	Name TEXT NOT NULL UNIQUE COLLATE NOCASE,
+	Description TEXT NOT NULL DEFAULT '',
+	URL TEXT NOT NULL DEFAULT ''
+);
+INSERT OR IGNORE INTO SOURCES(Name)
+	SELECT DISTINCT trim(Source) FROM ACRONYMS
+	WHERE Source IS NOT NULL AND trim(Source) <> '';
+CREATE TABLE ACRONYMS_NEW (
+	Acronym TEXT,
+	Definition TEXT,
+	Description TEXT,
+	SourceID INTEGER REFERENCES SOURCES(SourceID)
+);
+INSERT INTO ACRONYMS_NEW(rowid, Acronym, Definition, Description, SourceID)
+	SELECT a.rowid, a.Acronym, a.Definition, a.Description, s.SourceID
+	FROM ACRONYMS a LEFT JOIN SOURCES s ON s.Name = trim(a.Source);
+DROP TABLE ACRONYMS;
+ALTER TABLE ACRONYMS_NEW RENAME TO ACRONYMS;
+CREATE INDEX ACRONYMS_SOURCE_IDX ON ACRONYMS(SourceID);`
+
+// createTagTables adds the tables used to label acronym records with
+// any number of tags. ACRONYMS has no declared primary key for a
+// foreign key to reference, so a trigger removes the tags of a deleted
+// record instead.
+const createTagTables = `
+CREATE TABLE TAGS (
+	TagID INTEGER PRIMARY KEY,
+	Name TEXT NOT NULL UNIQUE COLLATE NOCASE
+);
+CREATE TABLE ACRONYM_TAGS (
+	AcronymID INTEGER NOT NULL,
+	TagID INTEGER NOT NULL REFERENCES TAGS(TagID) ON DELETE CASCADE,
+	PRIMARY KEY (AcronymID, TagID)
+);
+CREATE INDEX ACRONYM_TAGS_TAG_IDX ON ACRONYM_TAGS(TagID);
+CREATE TRIGGER ACRONYMS_DELETE_TAGS AFTER DELETE ON ACRONYMS
+BEGIN
+	DELETE FROM ACRONYM_TAGS WHERE AcronymID = old.rowid;
+END;`
+
+// addRecordTimestamps adds the time each acronym record was created
+// and last updated, and who by. Times are held as RFC 3339 text in UTC
+// so they sort and compare correctly as strings. Existing records are
+// left with NULL values as their history is not known.
+const addRecordTimestamps = `
+ALTER TABLE ACRONYMS ADD COLUMN CreatedAt TEXT;
+ALTER TABLE ACRONYMS ADD COLUMN UpdatedAt TEXT;
+ALTER TABLE ACRONYMS ADD COLUMN CreatedBy TEXT;
+ALTER TABLE ACRONYMS ADD COLUMN UpdatedBy TEXT;
+CREATE INDEX ACRONYMS_CREATED_IDX ON ACRONYMS(CreatedAt);
+CREATE INDEX ACRONYMS_UPDATED_IDX ON ACRONYMS(UpdatedAt);`
+
+// createAuditTable adds the table holding the change history of every
+// acronym record. The old and new values of a record are each held as
+// a JSON object, so the history is kept intact whatever changes are
+// later made to the ACRONYMS table layout. There is deliberately no
+// foreign key to ACRONYMS, as the history of a removed record is kept.
+const createAuditTable = `
+CREATE TABLE AUDIT (
+	AuditID INTEGER PRIMARY KEY,
+	AcronymID INTEGER NOT NULL,
+	Action TEXT NOT NULL,
+	OldValues TEXT,
+	NewValues TEXT,
+	ChangedAt TEXT NOT NULL,
+	ChangedBy TEXT NOT NULL
+);
+CREATE INDEX AUDIT_ACRONYM_IDX ON AUDIT(AcronymID);`
+
+// createTrashTable adds the table that holds removed acronym records
+// until they are restored or purged. The AUDIT table gains a flag to
+// mark the changes that have been undone, so each undo steps further
+// back through a user's changes.
+const createTrashTable = `
+CREATE TABLE TRASH (
+	TrashID INTEGER PRIMARY KEY,
+	AcronymID INTEGER NOT NULL,
+	RecordValues TEXT NOT NULL,
+	DeletedAt TEXT NOT NULL,
+	DeletedBy TEXT NOT NULL
+);
+CREATE INDEX TRASH_ACRONYM_IDX ON TRASH(AcronymID);
+ALTER TABLE AUDIT ADD COLUMN Undone INTEGER NOT NULL DEFAULT 0;`
+
+// sqlUUID is an SQL expression returning a random (version 4) UUID,
+// such as '0b8f9c2e-5d41-4a7e-9c1d-3f2b6a8e4c10'.
+const sqlUUID = `lower(hex(randomblob(4)) || '-' || hex(randomblob(2)) || '-4' ||
+	substr(hex(randomblob(2)), 2) || '-' || substr('89ab', 1 + abs(random() % 4), 1) ||
+	substr(hex(randomblob(2)), 2) || '-' || hex(randomblob(6)))`
+
+// addRecordKeys rebuilds ACRONYMS with an explicit 'AcronymID' integer
+// primary key, so record IDs can no longer be changed by a VACUUM, and
+// a permanent 'UUID' that identifies a record across copies of the
+// database. Existing IDs are carried over from the rowid unchanged.
+// The key is AUTOINCREMENT, so a new record is never given the ID of a
+// removed one - which would mix the history and trash of the two.
+// ACRONYM_TAGS is rebuilt to reference the new key by foreign key, in
+// place of the trigger that removed the tags of a deleted record.
+const addRecordKeys = `
+CREATE TABLE ACRONYMS_NEW (
+	AcronymID INTEGER PRIMARY KEY AUTOINCREMENT,
+	UUID TEXT NOT NULL UNIQUE,
+	Acronym TEXT,
+	Definition TEXT,
+	Description TEXT,
+	SourceID INTEGER REFERENCES SOURCES(SourceID),
+	CreatedAt TEXT,
+	UpdatedAt TEXT,
+	CreatedBy TEXT,
+	UpdatedBy TEXT
+);
+INSERT INTO ACRONYMS_NEW(AcronymID, UUID, Acronym, Definition, Description, SourceID,
+		CreatedAt, UpdatedAt, CreatedBy, UpdatedBy)
+	SELECT rowid, ` + sqlUUID + `, Acronym, Definition, Description, SourceID,
+		CreatedAt, UpdatedAt, CreatedBy, UpdatedBy
+	FROM ACRONYMS;
+` + seedRecordIDs + `
+DROP TABLE ACRONYMS;
+ALTER TABLE ACRONYMS_NEW RENAME TO ACRONYMS;
+CREATE INDEX ACRONYMS_SOURCE_IDX ON ACRONYMS(SourceID);
+CREATE INDEX ACRONYMS_CREATED_IDX ON ACRONYMS(CreatedAt);
+CREATE INDEX ACRONYMS_UPDATED_IDX ON ACRONYMS(UpdatedAt);
+CREATE TABLE ACRONYM_TAGS_NEW (
+	AcronymID INTEGER NOT NULL REFERENCES ACRONYMS(AcronymID) ON DELETE CASCADE,
+	TagID INTEGER NOT NULL REFERENCES TAGS(TagID) ON DELETE CASCADE,
+	PRIMARY KEY (AcronymID, TagID)
+);
+INSERT INTO ACRONYM_TAGS_NEW(AcronymID, TagID)
+	SELECT AcronymID, TagID FROM ACRONYM_TAGS
+	WHERE AcronymID IN (SELECT AcronymID FROM ACRONYMS);
+DROP TABLE ACRONYM_TAGS;
+ALTER TABLE ACRONYM_TAGS_NEW RENAME TO ACRONYM_TAGS;
+CREATE INDEX ACRONYM_TAGS_TAG_IDX ON ACRONYM_TAGS(TagID);`
+
+// seedRecordIDs starts the AUTOINCREMENT counter of ACRONYMS_NEW after
+// the highest ID ever used - including those of records only left in
+// the trash or the change history - so a new record is never given the
+// ID of a removed one.
+const seedRecordIDs = `
+INSERT INTO sqlite_sequence(name, seq)
+	SELECT 'ACRONYMS_NEW', 0
+	WHERE NOT EXISTS (SELECT 1 FROM sqlite_sequence WHERE name = 'ACRONYMS_NEW');
+UPDATE sqlite_sequence SET seq = max(seq,
+		coalesce((SELECT max(AcronymID) FROM ACRONYMS_NEW), 0),
+		coalesce((SELECT max(AcronymID) FROM TRASH), 0),
+		coalesce((SELECT max(AcronymID) FROM AUDIT), 0))
+	WHERE name = 'ACRONYMS_NEW';`
+
+// addRecordVersion adds a 'Version' counter to ACRONYMS, which is
+// increased by every change made to a record. An edit based on an
+// older version of a record than the one now held is refused, so one
+// user never silently overwrites another user's change.
+const addRecordVersion = `
+ALTER TABLE ACRONYMS ADD COLUMN Version INTEGER NOT NULL DEFAULT 1;`
+
+// createTokensTable adds the table of API tokens accepted by the HTTP
+// server. Only a SHA-256 hash of each token is held, so a copy of the
+// database does not give away the tokens themselves. A revoked token is
+// kept, marked with when it was revoked, so its name can be given to a
+// new token.
+const createTokensTable = `
+CREATE TABLE TOKENS (
+	TokenID INTEGER PRIMARY KEY,
+	Name TEXT NOT NULL,
+	Role TEXT NOT NULL CHECK (Role IN ('reader', 'editor', 'admin')),
+	Hash TEXT NOT NULL UNIQUE,
+	CreatedAt TEXT NOT NULL,
+	CreatedBy TEXT NOT NULL,
+	RevokedAt TEXT
+);
+CREATE UNIQUE INDEX TOKENS_NAME_IDX ON TOKENS(Name) WHERE RevokedAt IS NULL;`
+
+// execMigration wraps a plain SQL script as a migration 'apply'
+// function.
+func execMigration(script string) func(tx *sql.Tx) error {
+	return func(tx *sql.Tx) error {
+		_, err := tx.Exec(script)
+		return err
+	}
+}
+
+// SchemaVersion returns the schema version currently recorded in the
+// open database, as held in 'PRAGMA user_version'.
+func SchemaVersion() (version int, err error) {
+	err = DB.QueryRow("PRAGMA user_version;").Scan(&version)
+	return version, err
+}
+
+// MigrateDB upgrades the open database to the latest schema version
+// known to this version of 'amt'. Each migration is applied in its
+// own transaction, so a failure leaves the database at the last
+// version that was completed successfully.
+//
+// A database with a schema newer than this program understands is
+// left untouched and an error is returned instead.
+func MigrateDB() (err error) {
+	ctx := context.Background()
+	// pin a single connection - the foreign_keys pragma is set per
+	// connection and can not be changed inside a transaction
+	conn, err := DB.Conn(ctx)
+	if err != nil {
+		return fmt.Errorf("ERROR: unable to get database connection for schema upgrade: %v", err)
+	}
+	defer conn.Close()
+
+	var version int
+	if err = conn.QueryRowContext(ctx, "PRAGMA user_version;").Scan(&version); err != nil {
+		return fmt.Errorf("ERROR: unable to read database schema version: %v", err)
+	}
+	if DebugSwitch {
+		log.Printf("DEBUG: database schema version is: %d (latest is: %d)\n", version, len(migrations))
+	}
+	if version > len(migrations) {
+		return fmt.Errorf("ERROR: database schema version %d is newer than this version of %s supports (%d) - please upgrade %s",
+			version, Appname, len(migrations), Appname)
+	}
+	if version == len(migrations) {
+		return nil
+	}
+	if requireWritable() != nil {
+		return fmt.Errorf("ERROR: database schema version %d needs upgrading to version %d - open it once without read-only mode first",
+			version, len(migrations))
+	}
+
+	if _, err = conn.ExecContext(ctx, "PRAGMA foreign_keys=OFF;"); err != nil {
+		return fmt.Errorf("ERROR: unable to disable foreign keys for schema upgrade: %v", err)
+	}
+	defer func() {
+		if _, ferr := conn.ExecContext(ctx, "PRAGMA foreign_keys=ON;"); ferr != nil && err == nil {
+			err = fmt.Errorf("ERROR: unable to enable foreign keys after schema upgrade: %v", ferr)
+		}
+	}()
+
+	for ; version < len(migrations); version++ {
+		m := migrations[version]
+		if DebugSwitch {
+			log.Printf("DEBUG: applying schema migration %d: %s\n", version+1, m.description)
+		}
+		if err = applyMigration(ctx, conn, version+1, m); err != nil {
+			return fmt.Errorf("ERROR: database schema upgrade to version %d (%s) failed: %v", version+1, m.description, err)
+		}
+	}
+	return nil
+}
+
+// applyMigration runs a single migration in a transaction, checks no
+// foreign key constraints were broken by it, and records the new
+// schema version on success. The migration is skipped if another copy
+// of 'amt' using the same database has already applied it.
+func applyMigration(ctx context.Context, conn *sql.Conn, version int, m migration) error {
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	var current int
+	if err = tx.QueryRow("PRAGMA user_version;").Scan(&current); err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+	if current >= version {
+		return tx.Rollback()
+	}
+	if err = m.apply(tx); err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+	// 'foreign_key_check' returns a row for each violation found
+	rows, err := tx.Query("PRAGMA foreign_key_check;")
+	if err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+	violations := rows.Next()
+	rows.Close()
+	if violations {
+		_ = tx.Rollback()
+		return fmt.Errorf("foreign key constraint violations found")
+	}
+	// PRAGMA statements do not accept bound parameters
+	if _, err = tx.Exec(fmt.Sprintf("PRAGMA user_version = %d;", version)); err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
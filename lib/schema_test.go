@@ -0,0 +1,188 @@
+// amt - program to access an SQLite database and lookup acronyms
+//
+// author:	Simon Rowe <simon@wiremoons.com>
+// license: open-source released under The MIT License (MIT).
+//
+// Package used to test the SQLite database schema upgrades for
+// application 'amt'
+
+package lib
+
+import (
+	"database/sql"
+	"path/filepath"
+	"strconv"
+	"testing"
+)
+
+// openTestDB opens a new empty database file in a temporary directory
+// as the global 'DB', which is closed again when the test finishes. Its
+// schema is left at version 0 - ready for 'MigrateDB' to upgrade.
+func openTestDB(t *testing.T) {
+	t.Helper()
+	DbName = filepath.Join(t.TempDir(), "acronyms.db")
+	var err error
+	if DB, err = sql.Open("sqlite3", dataSourceName()); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		DB.Close()
+		DB = nil
+	})
+}
+
+// migrateTestDB opens a new database with openTestDB, and upgrades it
+// to the latest schema version.
+func migrateTestDB(t *testing.T) {
+	t.Helper()
+	openTestDB(t)
+	if err := MigrateDB(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestMigrateDBNew(t *testing.T) {
+	openTestDB(t)
+	// a second upgrade finds nothing left to do
+	for i := 0; i < 2; i++ {
+		if err := MigrateDB(); err != nil {
+			t.Fatalf("MigrateDB() run %d error = %v", i+1, err)
+		}
+		version, err := SchemaVersion()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if version != len(migrations) {
+			t.Errorf("SchemaVersion() after run %d = %d, want %d", i+1, version, len(migrations))
+		}
+	}
+}
+
+func TestMigrateDBUpgrade(t *testing.T) {
+	openTestDB(t)
+	// a database as created by the original version of 'amt', where
+	// users have noted the rowid of records from the search output
+	_, err := DB.Exec(`
+CREATE TABLE ACRONYMS (Acronym TEXT, Definition TEXT, Description TEXT, Source TEXT);
+INSERT INTO ACRONYMS(rowid, Acronym, Definition, Description, Source) VALUES
+	(1, 'SNI', 'Server Name Indication', 'TLS extension', 'Networking'),
+	(2, 'TLA', 'Three Letter Acronym', '', ' networking '),
+	(5, 'RFC', 'Request for Comments', '', 'IETF'),
+	(9, 'AMT', 'Acronym Management Tool', '', ''),
+	(12, 'WTF', 'What The Function', '', NULL);`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = MigrateDB(); err != nil {
+		t.Fatalf("MigrateDB() error = %v", err)
+	}
+
+	type row struct {
+		ID      int64
+		Acronym string
+		Source  string
+	}
+	want := []row{
+		{1, "SNI", "Networking"},
+		{2, "TLA", "Networking"},
+		{5, "RFC", "IETF"},
+		{9, "AMT", ""},
+		{12, "WTF", ""},
+	}
+	rows, err := DB.Query(`SELECT a.rowid, a.Acronym, coalesce(s.Name, '')
+		FROM ACRONYMS a LEFT JOIN SOURCES s ON s.SourceID = a.SourceID ORDER BY a.rowid;`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	var got []row
+	for rows.Next() {
+		var r row
+		if err = rows.Scan(&r.ID, &r.Acronym, &r.Source); err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, r)
+	}
+	if err = rows.Err(); err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != len(want) {
+		t.Fatalf("upgraded database holds %d records, want %d: %+v", len(got), len(want), got)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("upgraded record %d = %+v, want %+v", i, got[i], want[i])
+		}
+	}
+
+	var sources int
+	if err = DB.QueryRow("SELECT count(*) FROM SOURCES;").Scan(&sources); err != nil {
+		t.Fatal(err)
+	}
+	if sources != 2 {
+		t.Errorf("upgraded database holds %d sources, want 2", sources)
+	}
+}
+
+func TestMigrateDBNewer(t *testing.T) {
+	openTestDB(t)
+	newer := len(migrations) + 1
+	if _, err := DB.Exec("PRAGMA user_version = " + strconv.Itoa(newer) + ";"); err != nil {
+		t.Fatal(err)
+	}
+	if err := MigrateDB(); err == nil {
+		t.Errorf("MigrateDB() of schema version %d succeeded, want an error", newer)
+	}
+	if version, err := SchemaVersion(); err != nil || version != newer {
+		t.Errorf("SchemaVersion() = %d, %v, want %d left unchanged", version, err, newer)
+	}
+}
+
+func TestAddRecordKeys(t *testing.T) {
+	openTestDB(t)
+	// stop at the schema from before records had their own key
+	all := migrations
+	migrations = all[:6]
+	err := MigrateDB()
+	migrations = all
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the highest IDs used are now only held in the trash and history
+	_, err = DB.Exec(`
+INSERT INTO ACRONYMS(rowid, Acronym) VALUES (1, 'SNI'), (4, 'TLA');
+INSERT INTO TRASH(AcronymID, RecordValues, DeletedAt, DeletedBy) VALUES (7, '{}', '2023-01-31T00:00:00Z', 'alice');
+INSERT INTO AUDIT(AcronymID, Action, ChangedAt, ChangedBy) VALUES (9, 'delete', '2023-01-31T00:00:00Z', 'alice');`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = MigrateDB(); err != nil {
+		t.Fatalf("MigrateDB() error = %v", err)
+	}
+
+	var ids, uuids int
+	if err = DB.QueryRow("SELECT count(*), count(DISTINCT UUID) FROM ACRONYMS WHERE AcronymID IN (1, 4);").Scan(&ids, &uuids); err != nil {
+		t.Fatal(err)
+	}
+	if ids != 2 || uuids != 2 {
+		t.Errorf("upgraded database holds %d of the 2 record IDs with %d different UUIDs", ids, uuids)
+	}
+	// a new record never takes the ID of one removed - even once the
+	// newest record has itself been removed
+	for _, want := range []int64{10, 11} {
+		result, err := DB.Exec("INSERT INTO ACRONYMS(UUID, Acronym) VALUES (?, 'NEW');", strconv.FormatInt(want, 10))
+		if err != nil {
+			t.Fatal(err)
+		}
+		id, err := result.LastInsertId()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if id != want {
+			t.Errorf("new record ID = %d, want %d", id, want)
+		}
+		if _, err = DB.Exec("DELETE FROM ACRONYMS WHERE AcronymID = ?;", id); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
@@ -10,6 +10,7 @@ package lib
 import (
 	"bufio"
 	"fmt"
+	"io"
 	"log"
 	"os"
 	"runtime"
@@ -17,6 +18,12 @@ import (
 	"text/template"
 )
 
+// stdin is the reader shared by every function that reads the
+// user's console input. Creating a new reader for each question
+// would lose any input already buffered by an earlier one - such as
+// when answers are piped into the program.
+var stdin = bufio.NewReader(os.Stdin)
+
 // getInput function asks the user a question and returns their
 // answer. The question is provided to the function as a string
 // 'question' and the users response is returned by the function as a
@@ -25,14 +32,29 @@ func GetInput(question string) string {
 	if DebugSwitch {
 		fmt.Println("\nDEBUG: in function 'getInput' ...")
 	}
-	// create a new reader and attached to stdin
-	reader := bufio.NewReader(os.Stdin)
+	// ask the user the question and read their response - any error
+	// reading it is treated as an empty response
+	response, _ := readInput(question)
+	if DebugSwitch {
+		fmt.Printf("\nDEBUG: user provided input (after TrimSuffix): '%s' \n", response)
+	}
+	// return the string read from the user to the calling function
+	return response
+}
+
+// readInput asks the user the question provided and returns their
+// response without any line ending. An error is returned if the input
+// can not be read, such as 'io.EOF' when the user presses Ctrl + d or
+// piped input runs out, so callers that ask again on a bad response
+// know when to stop.
+func readInput(question string) (string, error) {
 	// ask the user the question passed to the function
 	fmt.Printf("%s", question)
 	// read the user's response - terminating their input on newline
-	response, _ := reader.ReadString('\n')
-	if DebugSwitch {
-		fmt.Printf("\nDEBUG: user provided input: '%s' \n", response)
+	response, err := stdin.ReadString('\n')
+	// a final line without a newline is still a valid response
+	if err != nil && (err != io.EOF || response == "") {
+		return "", err
 	}
 	// remove the trailing newline (Unix/Mac) or both the newline and
 	// return (Windows) from the input string provided by the user. As
@@ -42,13 +64,9 @@ func GetInput(question string) string {
 	// is returned unchanged - so no harm done!
 	response = strings.TrimSuffix(response, "\n")
 	response = strings.TrimSuffix(response, "\r")
-	if DebugSwitch {
-		fmt.Printf("\nDEBUG: user provided input (after TrimSuffix): '%s' \n", response)
-	}
 	// flush any output to the screen
 	_ = os.Stdout.Sync()
-	// return the string read from the user to the calling function
-	return response
+	return response, nil
 }
 
 // CheckContinue function asks the user if they would like to continue
@@ -61,12 +79,8 @@ func GetInput(question string) string {
 // If the response contains the letter 'y' it returns 'true'. Any other
 // response will return 'false'.
 func CheckContinue() bool {
-	// create a new reader from stdin
-	reader := bufio.NewReader(os.Stdin)
-	// ask the user if they wish to continue
-	fmt.Print("Continue? [y/n]: ")
-	// read the user's response - terminating their input on newline
-	response, _ := reader.ReadString('\n')
+	// ask the user if they wish to continue and read their response
+	response, _ := readInput("Continue? [y/n]: ")
 	// convert the response to lower case - easier to compare
 	response = strings.ToLower(response)
 	// see if the user input contains 'y' : returns 'true' or 'false'
@@ -118,14 +132,20 @@ Usage of ./amt:
 
         Flag               Description                                        Default Value
         ¯¯¯¯               ¯¯¯¯¯¯¯¯¯¯¯                                        ¯¯¯¯¯¯¯¯¯¯¯¯¯
+        -added <age>       only find acronyms added within age, eg: 30d       optional
+        -author <user>     only find acronyms added or changed by user        optional
+        -changed <age>     only find acronyms changed within age, eg: 2w      optional
         -d                 show debug output                                  false
         -f <filename>      provide filename and path to SQLite database       optional
         -h                 display help for this program                      false
         -n                 add a new acronym record                           optional
         -s <acronym>       provide acronym to search for                      optional
         -r <acronym id>    provide acronym id to remove                       optional
+        -ro                open the database read-only                        false
+        -t <tags>          only find acronyms with these tags (comma list)    optional
         -v                 display program version                            false
         -w                 search for any similar matches                     false`
 	fmt.Println(usageText)
+	fmt.Print(commandUsage())
 
 }
@@ -0,0 +1,226 @@
+// amt - program to access an SQLite database and lookup acronyms
+//
+// author:	Simon Rowe <simon@wiremoons.com>
+// license: open-source released under The MIT License (MIT).
+//
+// Package used to export the acronyms as a static web site for
+// application 'amt'
+//
+// The site needs no server - it can be copied to any web server or
+// opened straight from the files. It holds an A-Z index with an anchor
+// for every acronym, a page grouping the acronyms by source, and a
+// search box that works in the browser over an index of the acronyms
+// held within the page. The pages are built from the templates and
+// files in the 'site' directory, which are held within the program.
+
+package lib
+
+import (
+	"embed"
+	"fmt"
+	"html/template"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// siteFiles holds the page templates and other files used to build the
+// static web site.
+//
+//go:embed site
+var siteFiles embed.FS
+
+// siteTemplates holds the templates for every page of the site.
+var siteTemplates = template.Must(template.New("site").Funcs(template.FuncMap{
+	"anchor":       siteAnchor,
+	"sourceAnchor": siteSourceAnchor,
+}).ParseFS(siteFiles, "site/*.html", "site/*.tmpl"))
+
+// sitePages lists each page of the site, along with the title it is
+// given.
+var sitePages = []struct {
+	name  string
+	title string
+}{
+	{"index.html", "A–Z"},
+	{"sources.html", "By source"},
+}
+
+// siteAssets lists the files copied to the site unchanged.
+var siteAssets = []string{"search.js", "style.css"}
+
+// siteNoSource is the name the acronyms with no source are grouped
+// under.
+const siteNoSource = "No source"
+
+// siteData holds everything shown on the pages of the site.
+type siteData struct {
+	Title   string
+	App     string
+	Count   int
+	Letters []siteLetter
+	Sources []siteSource
+	Index   []siteIndexEntry
+}
+
+// sitePage holds the data for a single page of the site.
+type sitePage struct {
+	*siteData
+	Page string
+}
+
+// siteLetter holds the acronyms starting with a single letter - or with
+// any other character, for the final '#' group.
+type siteLetter struct {
+	Letter  string
+	ID      string
+	Records []Record
+}
+
+// siteSource holds the acronyms from a single source.
+type siteSource struct {
+	ID          string
+	Name        string
+	Description string
+	URL         string
+	Records     []Record
+}
+
+// siteIndexEntry holds an acronym in the search index held in the page,
+// using short names to keep the index small.
+type siteIndexEntry struct {
+	ID         string `json:"id"`
+	Acronym    string `json:"a"`
+	Definition string `json:"d"`
+	Source     string `json:"s,omitempty"`
+}
+
+// siteAnchor returns the ID of the anchor for the acronym record 'r'.
+func siteAnchor(r Record) string {
+	return "a-" + r.UUID
+}
+
+// siteSourceAnchor returns the ID of the anchor for the source called
+// 'name' - its letters and digits in lower case, joined by dashes.
+func siteSourceAnchor(name string) string {
+	words := strings.FieldsFunc(strings.ToLower(name), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	if len(words) == 0 {
+		return "source-none"
+	}
+	return "source-" + strings.Join(words, "-")
+}
+
+// isSiteDir returns true if 'name' is an existing directory, or ends
+// with a path separator, so the site export is used for it.
+func isSiteDir(name string) bool {
+	if strings.HasSuffix(name, "/") || strings.HasSuffix(name, string(os.PathSeparator)) {
+		return true
+	}
+	fi, err := os.Stat(name)
+	return err == nil && fi.IsDir()
+}
+
+// buildSiteData arranges the acronym records in the glossary 'g' for the
+// pages of the site.
+func buildSiteData(g *Glossary) *siteData {
+	records := append([]Record(nil), g.Records...)
+	sort.SliceStable(records, func(i, j int) bool {
+		a, b := strings.ToUpper(records[i].Acronym), strings.ToUpper(records[j].Acronym)
+		if a != b {
+			return a < b
+		}
+		return records[i].Definition < records[j].Definition
+	})
+
+	data := &siteData{Title: "Acronyms", App: Appname, Count: len(records)}
+
+	// the A-Z index, with a final group for any other first character
+	for c := 'A'; c <= 'Z'; c++ {
+		data.Letters = append(data.Letters, siteLetter{Letter: string(c), ID: strings.ToLower(string(c))})
+	}
+	data.Letters = append(data.Letters, siteLetter{Letter: "#", ID: "other"})
+	for _, r := range records {
+		i := len(data.Letters) - 1
+		if first := strings.ToUpper(r.Acronym); first != "" && first[0] >= 'A' && first[0] <= 'Z' {
+			i = int(first[0] - 'A')
+		}
+		data.Letters[i].Records = append(data.Letters[i].Records, r)
+		data.Index = append(data.Index, siteIndexEntry{
+			ID:         siteAnchor(r),
+			Acronym:    r.Acronym,
+			Definition: r.Definition,
+			Source:     r.Source,
+		})
+	}
+
+	// the sources that hold any acronyms, in name order
+	sources := make(map[string]*siteSource)
+	for _, s := range g.Sources {
+		sources[strings.ToLower(s.Name)] = &siteSource{
+			ID:          siteSourceAnchor(s.Name),
+			Name:        s.Name,
+			Description: s.Description,
+			URL:         s.URL,
+		}
+	}
+	for _, r := range records {
+		name := r.Source
+		if name == "" {
+			name = siteNoSource
+		}
+		s, ok := sources[strings.ToLower(name)]
+		if !ok {
+			s = &siteSource{ID: siteSourceAnchor(r.Source), Name: name}
+			sources[strings.ToLower(name)] = s
+		}
+		s.Records = append(s.Records, r)
+	}
+	for _, s := range sources {
+		if len(s.Records) > 0 {
+			data.Sources = append(data.Sources, *s)
+		}
+	}
+	sort.Slice(data.Sources, func(i, j int) bool {
+		return strings.ToLower(data.Sources[i].Name) < strings.ToLower(data.Sources[j].Name)
+	})
+	return data
+}
+
+// writeGlossarySite writes the acronym records in the glossary 'g' as a
+// static web site in the directory 'dir', which is created if needed.
+// Any other files in the directory are left in place.
+func writeGlossarySite(dir string, g *Glossary) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	data := buildSiteData(g)
+	for _, p := range sitePages {
+		page := sitePage{siteData: data, Page: p.title}
+		err := writeFileAtomic(filepath.Join(dir, p.name), func(w io.Writer) error {
+			return siteTemplates.ExecuteTemplate(w, p.name, page)
+		})
+		if err != nil {
+			return fmt.Errorf("unable to write page '%s': %v", p.name, err)
+		}
+	}
+	for _, name := range siteAssets {
+		content, err := siteFiles.ReadFile(path.Join("site", name))
+		if err != nil {
+			return err
+		}
+		err = writeFileAtomic(filepath.Join(dir, name), func(w io.Writer) error {
+			_, err := w.Write(content)
+			return err
+		})
+		if err != nil {
+			return fmt.Errorf("unable to write '%s': %v", name, err)
+		}
+	}
+	return nil
+}
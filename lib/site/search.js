@@ -0,0 +1,81 @@
+// Client-side search over the acronym index embedded in index.html -
+// so the site works without a server, even opened from a local file.
+(function () {
+  "use strict";
+
+  var input = document.getElementById("search");
+  var results = document.getElementById("results");
+  var index = JSON.parse(document.getElementById("search-index").textContent);
+  var maxResults = 50;
+
+  // rank returns how well the entry 'e' matches 'query' - lower is
+  // better - or -1 if it does not match at all.
+  function rank(e, query) {
+    var acronym = e.a.toLowerCase();
+    if (acronym === query) {
+      return 0;
+    }
+    if (acronym.indexOf(query) === 0) {
+      return 1;
+    }
+    if (acronym.indexOf(query) > 0 || e.d.toLowerCase().indexOf(query) >= 0) {
+      return 2;
+    }
+    return -1;
+  }
+
+  function show(query) {
+    query = query.trim().toLowerCase();
+    results.textContent = "";
+    if (query === "") {
+      results.hidden = true;
+      return;
+    }
+
+    var found = [];
+    index.forEach(function (e, i) {
+      var r = rank(e, query);
+      if (r >= 0) {
+        found.push({ rank: r, pos: i, entry: e });
+      }
+    });
+    found.sort(function (x, y) {
+      return x.rank - y.rank || x.pos - y.pos;
+    });
+
+    found.slice(0, maxResults).forEach(function (f) {
+      var li = document.createElement("li");
+      var a = document.createElement("a");
+      a.href = "#" + f.entry.id;
+      a.textContent = f.entry.a;
+      li.appendChild(a);
+      li.appendChild(document.createTextNode(" - " + f.entry.d));
+      if (f.entry.s) {
+        var source = document.createElement("span");
+        source.className = "source";
+        source.textContent = f.entry.s;
+        li.appendChild(document.createTextNode(" "));
+        li.appendChild(source);
+      }
+      results.appendChild(li);
+    });
+    if (found.length === 0) {
+      var none = document.createElement("li");
+      none.textContent = "No matching acronyms found";
+      results.appendChild(none);
+    } else if (found.length > maxResults) {
+      var more = document.createElement("li");
+      more.textContent = "... and " + (found.length - maxResults) + " more";
+      results.appendChild(more);
+    }
+    results.hidden = false;
+  }
+
+  input.addEventListener("input", function () {
+    show(input.value);
+  });
+  // keep the results when returning to the page
+  if (input.value) {
+    show(input.value);
+  }
+})();
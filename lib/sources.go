@@ -0,0 +1,131 @@
+// amt - program to access an SQLite database and lookup acronyms
+//
+// author:	Simon Rowe <simon@wiremoons.com>
+// license: open-source released under The MIT License (MIT).
+//
+// Package used to manage the acronym sources for application 'amt'
+//
+// Example record of 'SOURCES' table in SQLite database for
+// reference:
+//
+//   SourceID       : 1
+//   Name           : General ICT
+//   Description    : Common information and communication technology terms
+//   URL            : https://www.wiremoons.com/
+
+package lib
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+)
+
+// Source holds a single record from the SOURCES table. Each acronym
+// record references one source by its 'ID'.
+type Source struct {
+	ID          int64  `json:"-" yaml:"-"`
+	Name        string `json:"name" yaml:"name"`
+	Description string `json:"description" yaml:"description"`
+	URL         string `json:"url" yaml:"url"`
+}
+
+// ListSources returns every record held in the SOURCES table, ordered
+// by name.
+//
+// The SQL select statement used is:
+//
+//	select SourceID, Name, Description, URL from SOURCES order by Name;
+func ListSources() ([]Source, error) {
+	if DebugSwitch {
+		log.Println("DEBUG: Getting list of sources... ")
+	}
+	rows, err := DB.Query("select SourceID, Name, Description, URL from SOURCES order by Name;")
+	if err != nil {
+		return nil, fmt.Errorf("ERROR: unable to read the list of sources: %v", err)
+	}
+	defer rows.Close()
+
+	var sources []Source
+	for rows.Next() {
+		var s Source
+		if err = rows.Scan(&s.ID, &s.Name, &s.Description, &s.URL); err != nil {
+			return nil, fmt.Errorf("ERROR: reading source record: %v", err)
+		}
+		sources = append(sources, s)
+	}
+	return sources, rows.Err()
+}
+
+// sourceID returns the 'SourceID' for the source called 'name',
+// adding it to the SOURCES table within the transaction 'tx' first if
+// it does not already exist. Source names are matched without regard
+// to case. An empty name returns a NULL value, so the acronym is stored
+// without a source.
+func sourceID(tx *dbTx, name string) (sql.NullInt64, error) {
+	var id sql.NullInt64
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return id, nil
+	}
+	if _, err := tx.Exec("insert or ignore into SOURCES(Name) values(?);", name); err != nil {
+		return id, fmt.Errorf("ERROR: unable to add new source '%s': %v", name, err)
+	}
+	if err := tx.QueryRow("select SourceID from SOURCES where Name = ?;", name).Scan(&id); err != nil {
+		return id, fmt.Errorf("ERROR: unable to find source '%s': %v", name, err)
+	}
+	if DebugSwitch {
+		log.Printf("DEBUG: source '%s' has SourceID: %d\n", name, id.Int64)
+	}
+	return id, nil
+}
+
+// findSource returns the source in 'sources' called 'name', ignoring
+// case, and 'true' if one was found.
+func findSource(sources []Source, name string) (Source, bool) {
+	for _, s := range sources {
+		if strings.EqualFold(s.Name, name) {
+			return s, true
+		}
+	}
+	return Source{}, false
+}
+
+// filterSources returns the sources whose names match the partial
+// name 'pattern'. A source matches if the characters of 'pattern'
+// appear in its name in the same order, ignoring case - so 'gict'
+// matches 'General ICT'. Sources containing 'pattern' as a whole are
+// listed first, followed by the looser matches, each in name order.
+func filterSources(sources []Source, pattern string) []Source {
+	pattern = strings.ToLower(pattern)
+	var matches []Source
+	for _, s := range sources {
+		if fuzzyMatch(strings.ToLower(s.Name), pattern) {
+			matches = append(matches, s)
+		}
+	}
+	sort.SliceStable(matches, func(i, j int) bool {
+		iExact := strings.Contains(strings.ToLower(matches[i].Name), pattern)
+		jExact := strings.Contains(strings.ToLower(matches[j].Name), pattern)
+		return iExact && !jExact
+	})
+	return matches
+}
+
+// fuzzyMatch reports whether every character of 'pattern' appears in
+// 'text' in the same order, though not necessarily next to each other.
+// Spaces in 'pattern' are ignored.
+func fuzzyMatch(text, pattern string) bool {
+	remaining := []rune(strings.Replace(pattern, " ", "", -1))
+	for _, r := range text {
+		if len(remaining) == 0 {
+			break
+		}
+		if r == remaining[0] {
+			remaining = remaining[1:]
+		}
+	}
+	return len(remaining) == 0
+}
@@ -8,13 +8,14 @@
 // Example record of 'ACRONYMS' table in SQLite database for
 // reference:
 //
-//   rowid 			: hidden internal sqlite record id
+//   AcronymID 		: 14307  (record ID shown to users)
+//   UUID 			: 0b8f9c2e-5d41-4a7e-9c1d-3f2b6a8e4c10  (never changes)
 //   Acronym 		: 21CN
 //   Definition 	: 21st Century Network
 //   Description    : A new BT Plc network infrastructure consolidating
 //                    multiple legacy networks into one common internet protocol
 //                    platform.
-//   Source 		: General ICT
+//   SourceID 		: 1  (references the 'SOURCES' table - see sources.go)
 
 package lib
 
@@ -23,9 +24,11 @@ import (
 	"errors"
 	"fmt"
 	"log"
+	"net/url"
 	"os"
 	"path/filepath"
 	"strconv"
+	"strings"
 
 	"github.com/dustin/go-humanize"
 	_ "github.com/mattn/go-sqlite3"
@@ -48,13 +51,6 @@ func OpenDataBase() (err error) {
 		log.Fatal(err)
 	}
 
-	defer func(DB *sql.DB) {
-		err := DB.Close()
-		if err != nil {
-			log.Println("ERROR: unable to close the database.")
-		}
-	}(DB)
-
 	// check the connection to database is ok
 	err = DB.Ping()
 	if err != nil {
@@ -64,6 +60,18 @@ func OpenDataBase() (err error) {
 	return err
 }
 
+// CloseDataBase closes the global database handle once the program
+// has finished with it. It is safe to call if the database was never
+// opened.
+func CloseDataBase() {
+	if DB == nil {
+		return
+	}
+	if err := DB.Close(); err != nil {
+		log.Println("ERROR: unable to close the database.")
+	}
+}
+
 // checkDB is used to verify if a valid database file name and path
 // has been provided by the user.
 //
@@ -147,8 +155,10 @@ func CheckDB() (err error) {
 		// check is a regular file
 		if mode.IsRegular() {
 			// print out some details of the database file:
-			fmt.Printf("Database location: %s\nDatabase permissions: %s     Database size: %s bytes\n\n",
-				filepath.Join(filepath.Dir(DbName), fi.Name()), fi.Mode(), humanize.Comma(fi.Size()))
+			if !Quiet {
+				fmt.Printf("Database location: %s\nDatabase permissions: %s     Database size: %s bytes\n\n",
+					filepath.Join(filepath.Dir(DbName), fi.Name()), fi.Mode(), humanize.Comma(fi.Size()))
+			}
 
 			if DebugSwitch {
 				log.Println("DEBUG: regular file check completed ok - return to main()")
@@ -167,6 +177,72 @@ func CheckDB() (err error) {
 	return err
 }
 
+// busyTimeout is how long, in milliseconds, a connection waits for
+// another program using the same database to release its lock before
+// giving up with a 'database is locked' error.
+const busyTimeout = 5000
+
+// dataSourceName returns the connection string passed to the SQLite
+// driver for the database file 'DbName'. The options set for every
+// new connection in the pool are:
+//
+//	_foreign_keys=on	enforce foreign keys - off by default in SQLite
+//	_busy_timeout		wait for other users' locks - see 'busyTimeout'
+//	_txlock=immediate	take the write lock when a transaction starts
+//	mode=ro		open the database read-only - when 'ReadOnly' is set
+//	immutable=1		never lock or check for changes - when 'Immutable' is set
+//
+// Starting transactions with the write lock already held means two
+// users saving at the same time wait for each other in turn, rather
+// than one of them failing part way through its change.
+func dataSourceName() string {
+	params := url.Values{}
+	params.Set("_foreign_keys", "on")
+	params.Set("_busy_timeout", strconv.Itoa(busyTimeout))
+	if ReadOnly || Immutable {
+		params.Set("mode", "ro")
+		if Immutable {
+			params.Set("immutable", "1")
+		}
+	} else {
+		params.Set("_txlock", "immediate")
+	}
+	return fileURI(DbName) + "?" + params.Encode()
+}
+
+// fileURI returns the database file 'name' as a 'file:' URI. The prefix
+// makes the driver pass 'mode' on to SQLite, so any characters in the
+// file name with a meaning in a URI are escaped.
+func fileURI(name string) string {
+	return "file:" + strings.NewReplacer("%", "%25", "?", "%3f", "#", "%23").Replace(name)
+}
+
+// setJournalMode switches the open database to the journal mode given
+// by the 'journal_mode' setting - 'WAL' unless configured otherwise.
+// In WAL mode users searching the database are never blocked by a
+// user saving a change, and the reverse. Not every file system supports
+// WAL - where it can not be used SQLite keeps its existing mode, and
+// the database still works normally.
+func setJournalMode() {
+	if requireWritable() != nil {
+		return
+	}
+	want := Settings.JournalMode
+	if want == "" {
+		want = "wal"
+	}
+	var mode string
+	// PRAGMA statements do not accept bound parameters - the value has
+	// been checked when the configuration file was read
+	if err := DB.QueryRow(fmt.Sprintf("PRAGMA journal_mode=%s;", want)).Scan(&mode); err != nil {
+		log.Printf("ERROR: unable to set database journal mode to '%s': %v\n", want, err)
+		return
+	}
+	if DebugSwitch {
+		log.Printf("DEBUG: database journal mode is: '%s' (requested: '%s')\n", mode, want)
+	}
+}
+
 // openDB is the function used to open the database and obtain initial
 // information confirming the connection is working, the acronym
 // record count in the database, and the last new acronym record
@@ -184,7 +260,7 @@ func OpenDB() (err error) {
 
 	// open the database - or abort if fails. If successful get the
 	// handle to open database file as 'db' for any future SQL calls
-	DB, err = sql.Open("sqlite3", DbName)
+	DB, err = sql.Open("sqlite3", dataSourceName())
 	if err != nil {
 
 		if DebugSwitch {
@@ -201,12 +277,25 @@ func OpenDB() (err error) {
 	if err != nil {
 		panic(err.Error())
 	}
-	fmt.Println("Database connection status:  √")
+	if !Quiet {
+		fmt.Println("Database connection status:  √")
+	}
+
+	// allow several users to share the database, then bring the
+	// schema up to date before it is used
+	setJournalMode()
+	if err = MigrateDB(); err != nil {
+		return err
+	}
 
+	// obtain the current record count into global var for future use
+	RecCount = CheckCount()
+	if Quiet {
+		return nil
+	}
 	// display the SQLite database version we are compiled with
 	fmt.Printf("SQLite3 Database Version:  %s\n", SqlVersion())
-	// obtain and display the current record count into global var for future use
-	RecCount = CheckCount()
+	// display the current record count
 	fmt.Printf("Current record count is:  %s\n", humanize.Comma(RecCount))
 	// display last acronym entered into the database for info
 	fmt.Printf("Last acronym entered was:  '%s'\n", LastAcronym())
@@ -231,7 +320,7 @@ func PopNewDB() (err error) {
 
 	// open the database - or abort if fails. If successful get the
 	// handle to open database file as 'db' for any future SQL calls
-	DB, err = sql.Open("sqlite3", DbName)
+	DB, err = sql.Open("sqlite3", dataSourceName())
 	if err != nil {
 
 		if DebugSwitch {
@@ -250,6 +339,13 @@ func PopNewDB() (err error) {
 	}
 	fmt.Println("Database connection status:  √")
 
+	// allow several users to share the database, then bring the
+	// schema up to date before it is used
+	setJournalMode()
+	if err = MigrateDB(); err != nil {
+		return err
+	}
+
 	// display the SQLite database version we are compiled with
 	fmt.Printf("SQLite3 Database Version:  %s\n", SqlVersion())
 	// obtain and display the current record count into global var for future use
@@ -293,7 +389,11 @@ func CheckCount() int64 {
 //
 // SQL statement run is:
 //
-//	SELECT Acronym FROM acronyms Order by rowid DESC LIMIT 1;
+//	SELECT Acronym FROM acronyms Order by CreatedAt DESC, AcronymID DESC LIMIT 1;
+//
+// Records added before creation times were kept have a NULL
+// 'CreatedAt', which sorts before any time - so for those the ID
+// order is used as before.
 func LastAcronym() string {
 
 	if DebugSwitch {
@@ -303,7 +403,7 @@ func LastAcronym() string {
 	var lastEntry string
 	// query the database to get last entered acronym - result
 	// returned to variable 'lastEntry'
-	err := DB.QueryRow("SELECT Acronym FROM acronyms Order by rowid DESC LIMIT 1;").Scan(&lastEntry)
+	err := DB.QueryRow("SELECT Acronym FROM acronyms Order by CreatedAt DESC, AcronymID DESC LIMIT 1;").Scan(&lastEntry)
 	if err != nil {
 		log.Printf("ERROR: in function 'LastAcronym()' with SQL  QueryRow (lastEntry): %v\n", err)
 	}
@@ -341,54 +441,116 @@ func SqlVersion() string {
 	return dbVer
 }
 
-// getSources provide the current 'sources' held in the acronym table
-// getSources function takes no parameters. The getSources functions
-// returns a string contain a list of distinct 'source' records such
-// as "General ICT"
-func GetSources() string {
+// GetSources asks the user to pick the source for a new acronym from
+// those held in the SOURCES table. The function takes no parameters
+// and returns the name of the chosen source, such as "General ICT".
+//
+// The user can enter the [#] of a source shown, or type part of a
+// source name to narrow the list down to the sources that match it.
+// Typing a name that matches no existing source, or prefixing a name
+// with '+', offers to create a brand-new source - which the user must
+// confirm. Pressing Enter without typing anything returns an empty
+// name, so the acronym has no source. Any other response simply asks
+// again, so a typing mistake never loses the record being entered.
+//
+// An error is only returned if no more input can be read from the
+// user, such as when they press Ctrl + d.
+func GetSources() (string, error) {
 
 	if DebugSwitch {
 		log.Print("DEBUG: Getting source list function... ")
 	}
-	// create variable to hold returned database source list
-	var sourceList []string
-	// query the database to extract distinct 'source' records -
-	// result out in variable 'sourceList'
-	rows, err := DB.Query("select distinct(source) from acronyms;")
+	// query the database to extract the 'source' records - result out
+	// in variable 'sourceList'
+	sourceList, err := Storage.ListSources()
 	if err != nil {
-		log.Printf("ERROR: in function 'getSources()' with SQL QueryRow (sourceList): %v\n", err)
-	}
-	defer rows.Close()
+		log.Printf("ERROR: in function 'getSources()' with: %v\n", err)
+	}
+
+	// the sources currently offered to the user - narrowed down as
+	// they filter the list
+	choices := sourceList
+	for {
+		switch {
+		case len(sourceList) == 0:
+			fmt.Printf("\nThere are no acronym 'source' choices yet.\n\n")
+		case len(choices) < len(sourceList):
+			fmt.Printf("\n%d of %d acronym 'source' choices match:\n\n", len(choices), len(sourceList))
+		default:
+			fmt.Printf("\nExisting %d acronym 'source' choices:\n\n", len(sourceList))
+		}
+		for idx, source := range choices {
+			fmt.Printf("[%d]: '%s'  ", idx, source.Name)
+		}
+		if len(choices) > 0 {
+			fmt.Printf("\n\n")
+		}
 
-	var source string
+		// ask user to choose one...
+		prompt := "Enter a source [#], part of a name to filter the list, '+name' for a new source, or nothing for no source: "
+		if len(sourceList) == 0 {
+			prompt = "Enter a name for a new source, or nothing for no source: "
+		}
+		input, err := readInput(prompt)
+		if err != nil {
+			return "", fmt.Errorf("ERROR: no source chosen for the new acronym: %v", err)
+		}
+		input = strings.TrimSpace(input)
 
-	for rows.Next() {
-		err = rows.Scan(&source)
-		sourceList = append(sourceList, source)
-		if DebugSwitch {
-			log.Printf("DEBUG: Source extracted: %s\n", source)
+		switch idx, numErr := strconv.Atoi(input); {
+		case input == "":
+			// nothing entered - the acronym has no source
+			return "", nil
+
+		case numErr == nil && len(choices) > 0:
+			// check the number entered is one of those offered
+			if idx >= 0 && idx < len(choices) {
+				return choices[idx].Name, nil
+			}
+			fmt.Printf("\nThe source # you entered '%d' is not one of the choices '0' to '%d' offered - please try again.\n",
+				idx, len(choices)-1)
+
+		case strings.HasPrefix(input, "+"):
+			// explicit request for a new source
+			name := strings.TrimSpace(strings.TrimPrefix(input, "+"))
+			if source, ok := findSource(sourceList, name); ok {
+				fmt.Printf("\nSource '%s' already exists - using it.\n", source.Name)
+				return source.Name, nil
+			}
+			if name != "" && confirmNewSource(name) {
+				return name, nil
+			}
+
+		default:
+			// an existing source typed out in full is used as is
+			if source, ok := findSource(sourceList, input); ok {
+				return source.Name, nil
+			}
+			matches := filterSources(sourceList, input)
+			switch len(matches) {
+			case 0:
+				fmt.Printf("\nNo existing source matches '%s'.\n", input)
+				if confirmNewSource(input) {
+					return input, nil
+				}
+				choices = sourceList
+			case 1:
+				fmt.Printf("\nUse source '%s'?  ", matches[0].Name)
+				if CheckContinue() {
+					return matches[0].Name, nil
+				}
+			default:
+				choices = matches
+			}
 		}
 	}
+}
 
-	fmt.Printf("\nExisting %d acronym 'source' choices:\n\n", len(sourceList))
-	for idx, source := range sourceList {
-		fmt.Printf("[%d]: '%s'  ", idx, source)
-	}
-	fmt.Printf("\n\n")
-	// ask user to choose one...
-	idxChoice := GetInput("Enter a source [#] for the new acronym: ")
-	idxFinal, err := strconv.Atoi(idxChoice)
-	// error - could not convert to Int so just return the string as is...
-	if err != nil {
-		return idxChoice
-	}
-	// check the number entered is not greater or less than it should be
-	if (idxFinal > (len(sourceList) - 1)) || (idxFinal < 0) {
-		// error - entered value is out of range warn user and exit
-		log.Fatalf("\n\nFATAL ERROR: The source # you entered '%d' is greater than choices of '0' to '%d' offered, or less than zero\n\n", idxFinal, len(sourceList)-1)
-	}
-	// return the result
-	return sourceList[idxFinal]
+// confirmNewSource checks with the user before a brand-new source is
+// created for their acronym.
+func confirmNewSource(name string) bool {
+	fmt.Printf("\nCreate a new source named '%s'?  ", name)
+	return CheckContinue()
 }
 
 // addRecord function adds a new record to the acronym table held in
@@ -397,10 +559,9 @@ func GetSources() string {
 // application will exit of there is an error attempting to insert the
 // new record into the database.
 //
-// The SQL insert statement used is:
-//
-//	insert into ACRONYMS(Acronym, Definition, Description, Source)
-//	values(?,?,?,?)
+// The record is inserted by InsertRecord, which also records when it
+// was added and by whom. A source name that is not already held in
+// the SOURCES table is added to it first.
 func AddRecord() {
 
 	if DebugSwitch {
@@ -408,6 +569,10 @@ func AddRecord() {
 	}
 	// update screen for user
 	fmt.Printf("\n\nADD A NEW ACRONYM RECORD\n¯¯¯¯¯¯¯¯¯¯¯¯¯¯¯¯¯¯¯¯¯¯¯¯\n")
+	if err := requireWritable(); err != nil {
+		log.Printf("%v - new acronym not added\n", err)
+		return
+	}
 	fmt.Printf("Note: To abort the input of a new record press keys:  Ctrl + c \n\n")
 	// get new acronym from user
 	acronym := GetInput("Enter the new acronym: ")
@@ -415,48 +580,138 @@ func AddRecord() {
 	definition := GetInput("Enter the expanded version of the new acronym: ")
 	description := GetInput("Enter any description for the new acronym: ")
 	// show list of sources currently used and get one from the user
-	source := GetSources()
+	source, err := GetSources()
+	if err != nil {
+		log.Printf("%v - new acronym not added\n", err)
+		return
+	}
 	// check the user is happy with what has been collected from them...
 	fmt.Printf("\nContinue to add new acronym:\n\tACRONYM: %s\n\tEXPANDED: %s\n\tDESCRIPTION: %s\n\tSOURCE: %s\n",
 		acronym, definition, description, source)
 
-	// get current database record count
-	preInsertCount := CheckCount()
-
 	// see if user wants to continue with the
 	if CheckContinue() {
 		// ok - add record to the database table
-		_, err := DB.Exec("insert into ACRONYMS(Acronym, Definition, Description, Source) values(?,?,?,?)",
-			acronym, definition, description, source)
-		if err != nil {
-			log.Fatalf("FATAL ERROR inserting new acronym record: %v\n", err)
+		record := Record{
+			Acronym:     acronym,
+			Definition:  definition,
+			Description: description,
+			Source:      source,
+		}
+		if err := ValidateRecord(&record); err != nil {
+			log.Printf("%v - new acronym not added\n", err)
+			return
 		}
-		// get new database record count post insert
-		newInsertCount := CheckCount()
-		// inform user of difference in database record counts -
-		// should be 1
-		fmt.Printf("SUCCESS: %d record added to the database\n",
-			newInsertCount-preInsertCount)
-		// inform user of database record counts
-		fmt.Printf("\nDatabase record count is: %s  [was: %s]\n",
-			humanize.Comma(newInsertCount), humanize.Comma(preInsertCount))
+		if err := Storage.InsertRecord(&record); err != nil {
+			log.Printf("%v - new acronym not added\n", err)
+			return
+		}
+		// inform user of the record added - the insert either saved
+		// the whole record or nothing at all
+		fmt.Printf("SUCCESS: 1 record added to the database as acronym ID '%d'\n", record.ID)
 	}
 
 	// function complete
 	return
 }
 
-// searchRecord function obtains a string from the users and search
-// for it in the SQLite acronyms database. It does not take any
-// parameters. It does not return any information, and exits the
-// program on completion. The application will exit of there is an
-// error.
+// EditRecord function changes an existing record in the acronym
+// table held in the SQLite database. The record to change is
+// identified by its ID number or UUID, provided to the function as
+// the string value 'editid'. Each value of the record is shown to the
+// user in turn, and pressing Enter keeps the existing value.
 //
-// The SQL select statement used is:
+// The record is saved by UpdateRecord, which also records when it was
+// changed and by whom. If another user saved a change to the record
+// while it was being edited, the user can merge the two changes,
+// overwrite the other change, or abort. The function returns any error
+// encountered.
+func EditRecord(editid string) (err error) {
+	// start edit of an acronym - update user's screen
+	fmt.Printf("\n\nEDIT AN ACRONYM RECORD\n¯¯¯¯¯¯¯¯¯¯¯¯¯¯¯¯¯¯¯¯¯¯\n")
+	if err = requireWritable(); err != nil {
+		return err
+	}
+	if DebugSwitch {
+		log.Printf("DEBUG: record ID to edit is: %s\n", editid)
+	}
+	record, err := Storage.LookupRecord(editid)
+	if err == sql.ErrNoRows {
+		return fmt.Errorf("ERROR: no acronym with ID: '%s' found in the database", editid)
+	} else if err != nil {
+		return err
+	}
+	id := record.ID
+	// keep the values the edit started from, to merge with any change
+	// another user saves in the meantime
+	base := record
+	fmt.Printf("\nRecord to edit:\n\n")
+	printRecord(record)
+	fmt.Printf("Note: press Enter to keep a value unchanged, or Ctrl + c to abort.\n\n")
+
+	record.Acronym = editValue("acronym", record.Acronym)
+	record.Definition = editValue("expanded version of the acronym", record.Definition)
+	record.Description = editValue("description", record.Description)
+	fmt.Printf("\nChange the source '%s'?  ", record.Source)
+	if CheckContinue() {
+		if record.Source, err = GetSources(); err != nil {
+			return fmt.Errorf("%v - acronym not changed", err)
+		}
+	}
+
+	// check the user is happy with what has been collected from them...
+	fmt.Printf("\nContinue to save acronym ID '%d':\n\tACRONYM: %s\n\tEXPANDED: %s\n\tDESCRIPTION: %s\n\tSOURCE: %s\n",
+		id, record.Acronym, record.Definition, record.Description, record.Source)
+	if !CheckContinue() {
+		fmt.Printf("Edit of Acronym ID '%d' aborted at users request\n", id)
+		return nil
+	}
+	// save the record - if someone else changed it while it was being
+	// edited, ask the user how to combine the two changes and try again
+	for {
+		err = Storage.UpdateRecord(&record)
+		var conflict *ConflictError
+		if !errors.As(err, &conflict) {
+			break
+		}
+		merged, ok := resolveConflict(base, conflict)
+		if !ok {
+			fmt.Printf("Edit of Acronym ID '%d' aborted at users request - their change is kept\n", id)
+			return nil
+		}
+		base, record = conflict.Theirs, merged
+	}
+	if err != nil {
+		return err
+	}
+	fmt.Printf("SUCCESS: acronym ID '%d' updated to version %d\n", id, record.Version)
+	return nil
+}
+
+// editValue asks the user for a new value for the record field
+// described by 'name', returning 'current' if they just press Enter.
+func editValue(name, current string) string {
+	value := GetInput(fmt.Sprintf("Enter the %s [%s]: ", name, current))
+	if value == "" {
+		return current
+	}
+	return value
+}
+
+// searchRecord function searches the SQLite acronyms database for
+// the records described by the SearchQuery 'q' - usually built from
+// the users command line flags. It does not return any information,
+// and exits the program on completion. The application will exit of
+// there is an error.
 //
-//	select rowid,Acronym,Definition,Description,Source from ACRONYMS where
-//	Acronym like ? ORDER BY Source;
-func SearchRecord(searchTerm string) {
+// The SQL select statement used is built by FindRecords, and is of
+// the form:
+//
+//	select a.AcronymID, a.UUID, a.Acronym, a.Definition, a.Description,
+//	s.Name, <tags>, a.CreatedAt, a.UpdatedAt, a.CreatedBy, a.UpdatedBy,
+//	a.Version from ACRONYMS a left join SOURCES s on s.SourceID = a.SourceID
+//	where a.Acronym like ? order by a.Acronym, s.Name;
+func SearchRecord(q SearchQuery) {
 	// start search for an acronym - update user's screen
 	fmt.Printf("\n\nSEARCH FOR AN ACRONYM RECORD\n¯¯¯¯¯¯¯¯¯¯¯¯¯¯¯¯¯¯¯¯¯¯¯¯¯¯¯¯\n")
 	//
@@ -465,46 +720,30 @@ func SearchRecord(searchTerm string) {
 		log.Printf("DEBUG: checking for a search term ... ")
 	}
 	if DebugSwitch {
-		log.Printf("DEBUG: search term provided: %s\n", searchTerm)
+		log.Printf("DEBUG: search term provided: %s  tags: %v\n", q.Term, q.Tags)
 	}
 	// update user that the database is open and acronym we will
 	// search for in how many records:
 	fmt.Printf("\nSearching for:  '%s'  across %s records - please wait...\n",
-		searchTerm, humanize.Comma(RecCount))
+		q.Term, humanize.Comma(RecCount))
+	if len(q.Tags) > 0 {
+		fmt.Printf("Only including records tagged:  %s\n", strings.Join(q.Tags, ", "))
+	}
 
 	// flush any output to the screen
 	_ = os.Stdout.Sync()
 
 	// run a SQL query to find any matching acronyms to that provided
 	// by the user
-	rows, err := DB.Query("select rowid,Acronym,Definition,Description,Source from ACRONYMS where Acronym like ? ORDER BY Source;", searchTerm)
+	records, err := Storage.FindRecords(q)
 	if err != nil {
-		log.Fatal(err)
+		log.Println(err)
+		return
 	}
-	defer rows.Close()
 
 	fmt.Printf("\nMatching results are:\n\n")
-	for rows.Next() {
-		// variables to hold returned database values - use []byte
-		// instead of string to get around NULL values issue error
-		// which states:
-		//
-		// " Scan error on column index 2: unsupported driver -> Scan
-		// pair: <nil> -> *string"
-		var rowid, acronym, definition, description, source []byte
-		err := rows.Scan(&rowid, &acronym, &definition, &description, &source)
-		if err != nil {
-			log.Printf("ERROR: reading database record: %v", err)
-		}
-		// print the current row to screen - need string(...) as
-		// values are bytes
-		fmt.Printf("ID: %s\nACRONYM: '%s' is: %s.\nDESCRIPTION: %s\nSOURCE: %s\n\n",
-			string(rowid), string(acronym), string(definition), string(description), string(source))
-	}
-	// check there were no other error while reading the database rows
-	err = rows.Err()
-	if err != nil {
-		log.Printf("ERROR: reading database row returned: %v", err)
+	for _, r := range records {
+		printRecord(r)
 	}
 	// function complete ok
 	return
@@ -512,32 +751,36 @@ func SearchRecord(searchTerm string) {
 
 // RemoveRecord function is used to remove (ie delete) a record from
 // the Acronyms database. The record to be removed is identified by
-// its 'rowid' number. The record to be removed is first displayed to
+// its ID number or UUID. The record to be removed is first displayed to
 // allow the user to check it is the correct one, and on confirmation
 // the record if removed from the ACRONYMS table.
 //
-// The 'rowid' of the record is obtained from the user via the command
-// line switch '-r'. This 'rowid' is held in the global variable
-// 'rmid'. The 'rowid' is provided to the function when called as a
-// string value named 'rmid'.
+// The ID of the record is obtained from the user via the command
+// line switch '-r'. This ID is held in the global variable 'rmid'.
+// The ID is provided to the function when called as a string value
+// named 'rmid'.
 //
 // The RemoveRecord function returns either 'nil' as an err value or
 // type error, or details of any actual error that occurs when it
 // runs.
 //
-// The SQL delete statement used is:
-//
-//	delete from ACRONYMS where rowid = ?;
+// The record is removed by DeleteRecord, which moves it to the trash -
+// from where it can be restored - and keeps the removed values in the
+// record's history.
 func RemoveRecord(rmid string) (err error) {
 	// start remove for an acronym - update user's screen
 	fmt.Printf("\n\nREMOVE AN ACRONYM RECORD\n¯¯¯¯¯¯¯¯¯¯¯¯¯¯¯¯¯¯¯¯¯¯¯¯\n")
+	if err = requireWritable(); err != nil {
+		log.Printf("%v - acronym not removed\n", err)
+		return err
+	}
 	//
-	// check we have a rowid to remove from the acronyms database table:
+	// check we have an ID to remove from the acronyms database table:
 	if DebugSwitch {
 		log.Printf("DEBUG: checking for a search term ... ")
 	}
 	if DebugSwitch {
-		log.Printf("DEBUG: record 'rowid' to remove is: %s\n", rmid)
+		log.Printf("DEBUG: record ID to remove is: %s\n", rmid)
 	}
 	if rmid == "" {
 		log.Println("ERROR: an 'Acronym ID' for the record to be removed needs to be provided.")
@@ -546,10 +789,10 @@ func RemoveRecord(rmid string) (err error) {
 		return err
 	}
 
-	// validate the rowid is an integer
-	if _, err := strconv.ParseInt(rmid, 10, 64); err != nil {
-		fmt.Printf("\nERROR: acronym ID '%v' is not a valid number.\n", rmid)
-		fmt.Printf("Please provide a acronym 'ID' number for the record you want to delete from the database.\n")
+	// validate the ID is an integer or a UUID
+	if _, err := strconv.ParseInt(rmid, 10, 64); err != nil && !IsUUID(rmid) {
+		fmt.Printf("\nERROR: acronym ID '%v' is not a valid number or UUID.\n", rmid)
+		fmt.Printf("Please provide a acronym 'ID' number or UUID for the record you want to delete from the database.\n")
 		err = errors.New("unable to find integer in acronym ID value: '%s'. Error returned: '%v'")
 		return err
 	}
@@ -561,12 +804,10 @@ func RemoveRecord(rmid string) (err error) {
 	// flush any output to the screen
 	_ = os.Stdout.Sync()
 
-	// run a SQL query to find the matching acronym to the 'rowid'
+	// run a SQL query to find the matching acronym to the ID
 	// provided by the user - should return a single row result or and
-	// error is there is no match to the rowid
-	var rowid, acronym, definition, description, source []byte
-	err = DB.QueryRow("select rowid,Acronym,Definition,Description,Source from ACRONYMS where rowid = ?",
-		rmid).Scan(&rowid, &acronym, &definition, &description, &source)
+	// error is there is no match to the ID
+	record, err := Storage.LookupRecord(rmid)
 	// check the results obtained are good
 	switch {
 	// no match found
@@ -580,9 +821,8 @@ func RemoveRecord(rmid string) (err error) {
 		// match found so print out results
 	default:
 		fmt.Printf("\nRecord match found:\n\n")
-		fmt.Printf("ID: %s\nACRONYM: '%s' is: %s.\nDESCRIPTION: %s\nSOURCE: %s\n\n",
-			string(rowid), string(acronym), string(definition), string(description), string(source))
-		fmt.Printf("\nRemove record ID '%s' for acronym: '%s'.    ", rmid, string(acronym))
+		printRecord(record)
+		fmt.Printf("\nRemove record ID '%s' for acronym: '%s'.    ", rmid, record.Acronym)
 	}
 
 	// Check with the user that the record shown above is the one they
@@ -594,23 +834,18 @@ func RemoveRecord(rmid string) (err error) {
 	}
 
 	fmt.Printf("Removing Acronym ID '%s' ...\n", rmid)
-	// get current database record count
-	preInsertCount := CheckCount()
 
 	// ok - remove record to the database table
-	_, err = DB.Exec("delete from ACRONYMS where rowid = ?", rmid)
+	trashID, err := Storage.DeleteRecord(record.ID)
 	if err != nil {
-		log.Fatalf("FATAL ERROR removing acronym record: %v\n", err)
-	}
-	// get new database record count post insert
-	newInsertCount := CheckCount()
-	// inform user of difference in database record counts -
-	// should be 1
-	fmt.Printf("SUCCESS: %d record removed to the database\n",
-		preInsertCount-newInsertCount)
-	// inform user of database record counts
-	fmt.Printf("\nDatabase record count is: %s  [was: %s]\n",
-		humanize.Comma(newInsertCount), humanize.Comma(preInsertCount))
+		fmt.Printf("\nAcronym ID '%s' was not removed\n", rmid)
+		return err
+	}
+	// inform user of the record removed - the delete either removed
+	// the whole record or nothing at all
+	fmt.Printf("SUCCESS: 1 record removed from the database - acronym ID '%d'\n", record.ID)
+	fmt.Printf("The record has been moved to the trash - restore it with:  %s restore %d\n",
+		Appname, trashID)
 
 	// function complete
 	return err
@@ -0,0 +1,208 @@
+// amt - program to access an SQLite database and lookup acronyms
+//
+// author:	Simon Rowe <simon@wiremoons.com>
+// license: open-source released under The MIT License (MIT).
+//
+// Package used to export the acronyms as a StarDict dictionary for
+// application 'amt'
+//
+// StarDict dictionaries are read by GoldenDict and many other offline
+// dictionary programs. A dictionary is made of three files sharing a
+// name: the '.ifo' file describing it, the '.idx' index of headwords,
+// and the '.dict.dz' file holding the articles - compressed with
+// dictzip, so a reader can find an article without unpacking the whole
+// file. Nothing in the files depends on when they were written, so an
+// unchanged database always gives the same dictionary.
+
+package lib
+
+import (
+	"bytes"
+	"compress/flate"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// dictzipChunkSize is the amount of article text compressed in each
+// dictzip chunk - the size used by the dictzip program itself.
+const dictzipChunkSize = 58315
+
+// stardictEntry holds a single headword and its article.
+type stardictEntry struct {
+	word    string
+	article string
+}
+
+// stardictBase returns the name shared by the files of the dictionary
+// 'name' - without any '.ifo' extension.
+func stardictBase(name string) string {
+	return strings.TrimSuffix(name, filepath.Ext(name))
+}
+
+// stardictLess returns true if the headword 'a' sorts before 'b' in a
+// StarDict index - ignoring the case of ASCII letters, then by bytes.
+func stardictLess(a, b string) bool {
+	if c := asciiCaseCompare(a, b); c != 0 {
+		return c < 0
+	}
+	return a < b
+}
+
+// asciiCaseCompare compares 'a' and 'b' byte by byte, ignoring the case
+// of ASCII letters only - as g_ascii_strcasecmp does for StarDict.
+func asciiCaseCompare(a, b string) int {
+	lower := func(c byte) byte {
+		if c >= 'A' && c <= 'Z' {
+			return c + 'a' - 'A'
+		}
+		return c
+	}
+	for i := 0; i < len(a) && i < len(b); i++ {
+		if ca, cb := lower(a[i]), lower(b[i]); ca != cb {
+			return int(ca) - int(cb)
+		}
+	}
+	return len(a) - len(b)
+}
+
+// stardictEntries returns a headword and article for each acronym in
+// 'records', in StarDict index order. Records with the same acronym
+// share a single article listing each meaning.
+func stardictEntries(records []Record) []stardictEntry {
+	meanings := make(map[string][]Record)
+	var words []string
+	for _, r := range records {
+		word := strings.Join(strings.Fields(r.Acronym), " ")
+		if _, ok := meanings[word]; !ok {
+			words = append(words, word)
+		}
+		meanings[word] = append(meanings[word], r)
+	}
+	sort.Slice(words, func(i, j int) bool { return stardictLess(words[i], words[j]) })
+
+	entries := make([]stardictEntry, len(words))
+	for i, word := range words {
+		var article strings.Builder
+		for n, r := range meanings[word] {
+			if n > 0 {
+				article.WriteString("\n\n")
+			}
+			if len(meanings[word]) > 1 {
+				fmt.Fprintf(&article, "%d. ", n+1)
+			}
+			article.WriteString(r.Definition)
+			if r.Description != "" {
+				article.WriteString("\n" + r.Description)
+			}
+			if r.Source != "" {
+				article.WriteString("\nSource: " + r.Source)
+			}
+		}
+		entries[i] = stardictEntry{word: word, article: article.String()}
+	}
+	return entries
+}
+
+// writeGlossaryStarDict writes the acronym records in the glossary 'g'
+// as a StarDict dictionary. 'name' is the '.ifo' file, and the '.idx'
+// and '.dict.dz' files are written alongside it. Each acronym is a
+// headword, with its expanded version, description and source as the
+// article.
+func writeGlossaryStarDict(name string, g *Glossary) error {
+	base := stardictBase(name)
+	entries := stardictEntries(g.Records)
+
+	var dict, idx bytes.Buffer
+	for _, e := range entries {
+		idx.WriteString(e.word)
+		idx.WriteByte(0)
+		binary.Write(&idx, binary.BigEndian, uint32(dict.Len()))
+		binary.Write(&idx, binary.BigEndian, uint32(len(e.article)))
+		dict.WriteString(e.article)
+	}
+
+	err := writeFileAtomic(base+".dict.dz", func(w io.Writer) error {
+		return writeDictzip(w, dict.Bytes())
+	})
+	if err != nil {
+		return err
+	}
+	err = writeFileAtomic(base+".idx", func(w io.Writer) error {
+		_, err := w.Write(idx.Bytes())
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	// the '.ifo' file is written last, so a reader never finds a
+	// dictionary whose index is missing
+	return writeFileAtomic(base+".ifo", func(w io.Writer) error {
+		_, err := fmt.Fprintf(w, "StarDict's dict ifo file\nversion=2.4.2\nbookname=Acronyms\nwordcount=%d\nidxfilesize=%d\n"+
+			"sametypesequence=m\ndescription=Acronyms exported from %s\n", len(entries), idx.Len(), Appname)
+		return err
+	})
+}
+
+// writeDictzip writes 'data' to 'w' compressed in the dictzip format -
+// a gzip file whose contents are compressed in separate chunks, listed
+// in its header, so any part can be read without unpacking what comes
+// before it. The file can still be read by gzip.
+func writeDictzip(w io.Writer, data []byte) error {
+	// compress each chunk on its own, so it does not refer back to
+	// an earlier one
+	var body bytes.Buffer
+	var sizes []uint16
+	for start := 0; start < len(data) || start == 0; start += dictzipChunkSize {
+		end := start + dictzipChunkSize
+		if end > len(data) {
+			end = len(data)
+		}
+		before := body.Len()
+		fw, err := flate.NewWriter(&body, flate.BestCompression)
+		if err != nil {
+			return err
+		}
+		if _, err = fw.Write(data[start:end]); err != nil {
+			return err
+		}
+		if err = fw.Flush(); err != nil {
+			return err
+		}
+		if end == len(data) {
+			// an empty final block ends the compressed data
+			body.Write([]byte{0x01, 0x00, 0x00, 0xff, 0xff})
+		}
+		sizes = append(sizes, uint16(body.Len()-before))
+		if end == len(data) {
+			break
+		}
+	}
+	if 10+2*len(sizes) > 0xffff {
+		return fmt.Errorf("the dictionary is too large to compress with dictzip")
+	}
+
+	var header bytes.Buffer
+	// gzip header: magic, deflate, FEXTRA flag, no time, unix
+	header.Write([]byte{0x1f, 0x8b, 8, 0x04, 0, 0, 0, 0, 0, 3})
+	binary.Write(&header, binary.LittleEndian, uint16(10+2*len(sizes)))
+	// the 'RA' random access field listing the size of every chunk
+	header.Write([]byte{'R', 'A'})
+	binary.Write(&header, binary.LittleEndian, uint16(6+2*len(sizes)))
+	binary.Write(&header, binary.LittleEndian, []uint16{1, dictzipChunkSize, uint16(len(sizes))})
+	binary.Write(&header, binary.LittleEndian, sizes)
+
+	var trailer bytes.Buffer
+	binary.Write(&trailer, binary.LittleEndian, []uint32{crc32.ChecksumIEEE(data), uint32(len(data))})
+
+	for _, b := range [][]byte{header.Bytes(), body.Bytes(), trailer.Bytes()} {
+		if _, err := w.Write(b); err != nil {
+			return err
+		}
+	}
+	return nil
+}
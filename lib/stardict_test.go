@@ -0,0 +1,82 @@
+// amt - program to access an SQLite database and lookup acronyms
+//
+// author:	Simon Rowe <simon@wiremoons.com>
+// license: open-source released under The MIT License (MIT).
+//
+// Package used to test the StarDict dictionary export for application
+// 'amt'
+
+package lib
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"math/rand"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestWriteDictzip(t *testing.T) {
+	random := make([]byte, 3*dictzipChunkSize+17)
+	rand.New(rand.NewSource(1)).Read(random)
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"empty", nil},
+		{"short", []byte("Server Name Indication\n")},
+		{"one chunk", []byte(strings.Repeat("x", dictzipChunkSize))},
+		{"chunk and a byte", []byte(strings.Repeat("y", dictzipChunkSize+1))},
+		{"text chunks", []byte(strings.Repeat("Three Letter Acronym - ", 10000))},
+		{"random chunks", random},
+	}
+	gzipPath, gzipErr := exec.LookPath("gzip")
+	dir := t.TempDir()
+	for _, tt := range tests {
+		var buf bytes.Buffer
+		if err := writeDictzip(&buf, tt.data); err != nil {
+			t.Errorf("%s: writeDictzip() error = %v", tt.name, err)
+			continue
+		}
+
+		zr, err := gzip.NewReader(bytes.NewReader(buf.Bytes()))
+		if err != nil {
+			t.Errorf("%s: gzip header not valid: %v", tt.name, err)
+			continue
+		}
+		got, err := io.ReadAll(zr)
+		if err != nil {
+			t.Errorf("%s: unable to decompress: %v", tt.name, err)
+			continue
+		}
+		if !bytes.Equal(got, tt.data) {
+			t.Errorf("%s: decompressed %d bytes that differ from the %d written", tt.name, len(got), len(tt.data))
+		}
+		// the 'RA' field lists one chunk for every dictzipChunkSize bytes
+		chunks := (len(tt.data) + dictzipChunkSize - 1) / dictzipChunkSize
+		if chunks == 0 {
+			chunks = 1
+		}
+		if ra := zr.Header.Extra; len(ra) != 10+2*chunks || string(ra[:2]) != "RA" {
+			t.Errorf("%s: random access field = %q, want 'RA' listing %d chunks", tt.name, ra, chunks)
+		}
+
+		if gzipErr != nil {
+			continue
+		}
+		name := filepath.Join(dir, strings.ReplaceAll(tt.name, " ", "-")+".dict.dz")
+		if err = os.WriteFile(name, buf.Bytes(), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		if out, err := exec.Command(gzipPath, "-t", name).CombinedOutput(); err != nil {
+			t.Errorf("%s: gzip -t failed: %v\n%s", tt.name, err, out)
+		}
+	}
+	if gzipErr != nil {
+		t.Logf("gzip not found - 'gzip -t' check skipped: %v", gzipErr)
+	}
+}
@@ -0,0 +1,108 @@
+// amt - program to access an SQLite database and lookup acronyms
+//
+// author:	Simon Rowe <simon@wiremoons.com>
+// license: open-source released under The MIT License (MIT).
+//
+// Package used to summarise the contents of the acronym database for
+// application 'amt'
+
+package lib
+
+import (
+	"database/sql"
+	"fmt"
+
+	"github.com/dustin/go-humanize"
+)
+
+// SourceCount holds the number of acronym records that reference a
+// single source. Records without a source are counted under an empty
+// 'Name'.
+type SourceCount struct {
+	Name  string `json:"name"`
+	Count int64  `json:"count"`
+}
+
+// Stats holds a summary of the contents of the acronym database.
+type Stats struct {
+	Records       int64         `json:"records"`
+	Acronyms      int64         `json:"acronyms"`
+	Untagged      int64         `json:"untagged"`
+	Sources       []SourceCount `json:"sources"`
+	Tags          []Tag         `json:"tags"`
+	LastAcronym   string        `json:"last_acronym"`
+	SchemaVersion int           `json:"schema_version"`
+}
+
+// GetStats gathers a summary of the contents of the acronym database:
+// the total number of records, the number of distinct acronyms, the
+// number of records for each source and each tag, and the acronym most
+// recently added.
+func GetStats() (Stats, error) {
+	var st Stats
+	err := DB.QueryRow(`select count(*), count(distinct upper(Acronym)),
+		count(*) - (select count(distinct AcronymID) from ACRONYM_TAGS)
+		from ACRONYMS;`).Scan(&st.Records, &st.Acronyms, &st.Untagged)
+	if err != nil {
+		return st, fmt.Errorf("ERROR: unable to count acronym records: %v", err)
+	}
+
+	rows, err := DB.Query(`select coalesce(s.Name, ''), count(*) from ACRONYMS a
+		left join SOURCES s on s.SourceID = a.SourceID
+		group by a.SourceID order by s.Name;`)
+	if err != nil {
+		return st, fmt.Errorf("ERROR: unable to count records by source: %v", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var sc SourceCount
+		if err = rows.Scan(&sc.Name, &sc.Count); err != nil {
+			return st, fmt.Errorf("ERROR: reading source count: %v", err)
+		}
+		st.Sources = append(st.Sources, sc)
+	}
+	if err = rows.Err(); err != nil {
+		return st, err
+	}
+
+	if st.Tags, err = ListTags(); err != nil {
+		return st, err
+	}
+	if st.SchemaVersion, err = SchemaVersion(); err != nil {
+		return st, fmt.Errorf("ERROR: unable to read database schema version: %v", err)
+	}
+	err = DB.QueryRow("select coalesce(Acronym, '') from ACRONYMS order by CreatedAt desc, AcronymID desc limit 1;").
+		Scan(&st.LastAcronym)
+	if err == sql.ErrNoRows {
+		err = nil
+	}
+	return st, err
+}
+
+// ShowStats displays a summary of the contents of the acronym
+// database on stdout.
+func ShowStats() error {
+	st, err := Storage.GetStats()
+	if err != nil {
+		return err
+	}
+	fmt.Printf("\n\nACRONYM DATABASE STATISTICS\n¯¯¯¯¯¯¯¯¯¯¯¯¯¯¯¯¯¯¯¯¯¯¯¯¯¯¯\n")
+	fmt.Printf("\nTotal acronym records:  %s\n", humanize.Comma(st.Records))
+	fmt.Printf("Distinct acronyms:  %s\n", humanize.Comma(st.Acronyms))
+
+	fmt.Printf("\nRecords by source (%d sources):\n", len(st.Sources))
+	for _, sc := range st.Sources {
+		name := sc.Name
+		if name == "" {
+			name = "(no source)"
+		}
+		fmt.Printf("  %-30s %s\n", name, humanize.Comma(sc.Count))
+	}
+
+	fmt.Printf("\nRecords by tag (%d tags):\n", len(st.Tags))
+	for _, t := range st.Tags {
+		fmt.Printf("  %-30s %s\n", t.Name, humanize.Comma(t.Count))
+	}
+	fmt.Printf("  %-30s %s\n", "(untagged)", humanize.Comma(st.Untagged))
+	return nil
+}
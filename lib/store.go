@@ -0,0 +1,105 @@
+// amt - program to access an SQLite database and lookup acronyms
+//
+// author:	Simon Rowe <simon@wiremoons.com>
+// license: open-source released under The MIT License (MIT).
+//
+// Package used to choose where the acronym records are held for
+// application 'amt'
+//
+// The command line reads and changes acronym records through 'Storage',
+// which is either the local SQLite database or an amt HTTP server - so
+// searching, adding, changing, tagging and removing acronyms, their
+// history and exports work, and look, the same with either.
+
+package lib
+
+// Store holds the acronym records used by the command line.
+type Store interface {
+	// FindRecords returns every acronym record matching the SearchQuery
+	// 'q', ordered by acronym and then source.
+	FindRecords(q SearchQuery) ([]Record, error)
+	// LookupRecord returns the acronym record with the ID or UUID
+	// 'value', or 'sql.ErrNoRows' if there is no such record.
+	LookupRecord(value string) (Record, error)
+	// InsertRecord adds the new acronym record 'r', filling in its ID,
+	// UUID, version and when it was added and by whom.
+	InsertRecord(r *Record) error
+	// UpdateRecord saves the changes made to the acronym record 'r',
+	// returning a *ConflictError if it is no longer at 'r.Version'.
+	UpdateRecord(r *Record) error
+	// DeleteRecord moves the acronym record with the ID 'id' to the
+	// trash, returning the ID of its trash entry.
+	DeleteRecord(id int64) (trashID int64, err error)
+	// ListTrash returns the removed acronym records, most recently
+	// removed first, and RestoreRecord brings one back.
+	ListTrash() ([]TrashItem, error)
+	RestoreRecord(trashID int64) (Record, error)
+	// RecordHistory returns the ID of the acronym record with the ID or
+	// UUID 'value', which may since have been removed, and every change
+	// made to it, oldest first.
+	RecordHistory(value string) (int64, []AuditEntry, error)
+	// TagRecord and UntagRecord add and remove the tags 'tags' on the
+	// acronym record with the ID 'id', returning the number changed.
+	TagRecord(id int64, tags []string) (int64, error)
+	UntagRecord(id int64, tags []string) (int64, error)
+	// ListSources, ListTags and GetStats describe the records held.
+	ListSources() ([]Source, error)
+	ListTags() ([]Tag, error)
+	GetStats() (Stats, error)
+}
+
+// Storage is where the command line reads and changes acronym records -
+// the local SQLite database unless OpenServer has been called.
+var Storage Store = localStore{}
+
+// localStore holds the acronym records in the local SQLite database,
+// opened by OpenDataBase.
+type localStore struct{}
+
+func (localStore) FindRecords(q SearchQuery) ([]Record, error) { return FindRecords(q) }
+func (localStore) LookupRecord(value string) (Record, error)   { return LookupRecord(value) }
+func (localStore) InsertRecord(r *Record) error                { return InsertRecord(r, CurrentUser()) }
+func (localStore) UpdateRecord(r *Record) error                { return UpdateRecord(r, CurrentUser()) }
+func (localStore) DeleteRecord(id int64) (int64, error)        { return DeleteRecord(id, CurrentUser()) }
+func (localStore) ListTrash() ([]TrashItem, error)             { return ListTrash() }
+func (localStore) RestoreRecord(trashID int64) (Record, error) {
+	return RestoreRecord(trashID, CurrentUser())
+}
+func (localStore) RecordHistory(value string) (int64, []AuditEntry, error) {
+	return FindHistory(value)
+}
+func (localStore) TagRecord(id int64, tags []string) (int64, error) {
+	return TagRecord(id, tags, CurrentUser())
+}
+func (localStore) UntagRecord(id int64, tags []string) (int64, error) {
+	return UntagRecord(id, tags, CurrentUser())
+}
+func (localStore) ListSources() ([]Source, error) { return ListSources() }
+func (localStore) ListTags() ([]Tag, error)       { return ListTags() }
+func (localStore) GetStats() (Stats, error)       { return GetStats() }
+
+// Remote returns true if the acronym records are held by an amt HTTP
+// server, rather than the local database.
+func Remote() bool {
+	_, ok := Storage.(*remoteStore)
+	return ok
+}
+
+// openQuiet opens the acronym records used by 'Storage' without printing
+// any of their details - the amt server at 'ServerURL' if one is set,
+// or otherwise the local database. If only 'searches' will be made, an
+// amt daemon running for the database is used in its place. It is used
+// by the modes whose output is read by other programs.
+func openQuiet(searches bool) error {
+	Quiet = true
+	if ServerURL != "" {
+		return OpenServer()
+	}
+	if err := CheckDB(); err != nil {
+		return err
+	}
+	if searches && OpenDaemon() == nil {
+		return nil
+	}
+	return OpenDataBase()
+}
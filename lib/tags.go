@@ -0,0 +1,225 @@
+// amt - program to access an SQLite database and lookup acronyms
+//
+// author:	Simon Rowe <simon@wiremoons.com>
+// license: open-source released under The MIT License (MIT).
+//
+// Package used to manage the tags held on acronym records for
+// application 'amt'
+//
+// Tags are short labels such as 'networking' or 'security'. Any
+// number of tags can be held on each acronym record, and they are
+// stored in the 'TAGS' table and linked to records through the
+// 'ACRONYM_TAGS' join table.
+
+package lib
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// Tag holds a single tag name and the number of acronym records it is
+// currently held on.
+type Tag struct {
+	ID    int64  `json:"-"`
+	Name  string `json:"name"`
+	Count int64  `json:"count"`
+}
+
+// normaliseTag returns the tag name 'name' in the form it is stored
+// in the database - trimmed and in lower case.
+func normaliseTag(name string) string {
+	return strings.ToLower(strings.TrimSpace(name))
+}
+
+// ValidateTag checks the tag name 'name' can be stored. Tags are
+// single words, so they must not contain any spaces, commas or
+// semicolons - the characters used to separate lists of tags.
+func ValidateTag(name string) error {
+	name = normaliseTag(name)
+	if name == "" {
+		return fmt.Errorf("ERROR: tag names can not be empty")
+	}
+	if strings.IndexFunc(name, func(r rune) bool {
+		return unicode.IsSpace(r) || unicode.IsControl(r) || r == ',' || r == ';'
+	}) >= 0 {
+		return fmt.Errorf("ERROR: tag '%s' must be a single word without spaces, commas or semicolons", name)
+	}
+	return nil
+}
+
+// SplitTags turns a list of tags separated by commas or semicolons -
+// as typed on the command line or held in an import file - into the
+// individual tag names.
+func SplitTags(list string) []string {
+	var tags []string
+	for _, tag := range strings.FieldsFunc(list, func(r rune) bool { return r == ',' || r == ';' }) {
+		if tag = normaliseTag(tag); tag != "" {
+			tags = append(tags, tag)
+		}
+	}
+	return tags
+}
+
+// TagRecord adds each of the tags in 'tags' to the acronym record
+// with the ID 'id'. New tag names are added to the TAGS table as
+// required, and tags the record already carries are ignored. If any
+// tag is added the record's updated time and author are set to now and
+// 'author', and the change is added to the record's history. The
+// number of tags added to the record is returned.
+func TagRecord(id int64, tags []string, author string) (changed int64, err error) {
+	err = withTx(author, func(tx *dbTx) error {
+		old, err := getRecord(tx, id)
+		if err != nil {
+			return err
+		}
+		if changed, err = addTags(tx, id, tags); err != nil || changed == 0 {
+			return err
+		}
+		if err = touchRecord(tx, id); err != nil {
+			return err
+		}
+		return auditChange(tx, auditUpdate, id, &old)
+	})
+	return changed, err
+}
+
+// addTags links each of the tags in 'tags' to the acronym record with
+// the ID 'id' within the transaction 'tx', and returns the number of
+// tags newly added.
+func addTags(tx *dbTx, id int64, tags []string) (changed int64, err error) {
+	for _, tag := range tags {
+		if err := ValidateTag(tag); err != nil {
+			return 0, err
+		}
+	}
+	for _, tag := range tags {
+		tag = normaliseTag(tag)
+		if DebugSwitch {
+			log.Printf("DEBUG: adding tag '%s' to record ID: %d\n", tag, id)
+		}
+		if _, err := tx.Exec("insert or ignore into TAGS(Name) values(?);", tag); err != nil {
+			return changed, fmt.Errorf("ERROR: unable to add new tag '%s': %v", tag, err)
+		}
+		result, err := tx.Exec(`insert or ignore into ACRONYM_TAGS(AcronymID, TagID)
+			select ?, TagID from TAGS where Name = ?;`, id, tag)
+		if err != nil {
+			return changed, fmt.Errorf("ERROR: unable to add tag '%s' to record ID '%d': %v", tag, id, err)
+		}
+		n, err := result.RowsAffected()
+		if err != nil {
+			return changed, fmt.Errorf("ERROR: unable to add tag '%s' to record ID '%d': %v", tag, id, err)
+		}
+		changed += n
+	}
+	return changed, nil
+}
+
+// UntagRecord removes each of the tags in 'tags' from the acronym
+// record with the ID 'id'. Tags that are no longer held on any
+// record are then removed from the TAGS table. If any tag is removed
+// the record's updated time and author are set to now and 'author',
+// and the change is added to the record's history. The number of tags
+// removed from the record is returned.
+func UntagRecord(id int64, tags []string, author string) (changed int64, err error) {
+	err = withTx(author, func(tx *dbTx) error {
+		changed = 0
+		old, err := getRecord(tx, id)
+		if err != nil {
+			return err
+		}
+		for _, tag := range tags {
+			tag = normaliseTag(tag)
+			if DebugSwitch {
+				log.Printf("DEBUG: removing tag '%s' from record ID: %d\n", tag, id)
+			}
+			result, err := tx.Exec(`delete from ACRONYM_TAGS where AcronymID = ?
+				and TagID in (select TagID from TAGS where Name = ?);`, id, tag)
+			if err != nil {
+				return fmt.Errorf("ERROR: unable to remove tag '%s' from record ID '%d': %v", tag, id, err)
+			}
+			n, err := result.RowsAffected()
+			if err != nil {
+				return fmt.Errorf("ERROR: unable to remove tag '%s' from record ID '%d': %v", tag, id, err)
+			}
+			changed += n
+		}
+		if changed == 0 {
+			return nil
+		}
+		if err = pruneTags(tx); err != nil {
+			return err
+		}
+		if err = touchRecord(tx, id); err != nil {
+			return err
+		}
+		return auditChange(tx, auditUpdate, id, &old)
+	})
+	return changed, err
+}
+
+// setTags replaces the tags held on the acronym record with the ID 'id'
+// with those in 'tags', within the transaction 'tx', and reports
+// whether they were changed. Tags no longer held on any record are
+// removed from the TAGS table.
+func setTags(tx *dbTx, id int64, tags []string) (changed bool, err error) {
+	current, err := getRecord(tx, id)
+	if err != nil {
+		return false, err
+	}
+	want := make([]string, 0, len(tags))
+	for _, tag := range tags {
+		want = append(want, normaliseTag(tag))
+	}
+	sort.Strings(want)
+	if strings.Join(want, tagSeparator) == strings.Join(current.Tags, tagSeparator) {
+		return false, nil
+	}
+	if _, err = tx.Exec("delete from ACRONYM_TAGS where AcronymID = ?;", id); err != nil {
+		return false, fmt.Errorf("ERROR: unable to replace the tags of record ID '%d': %v", id, err)
+	}
+	if _, err = addTags(tx, id, want); err != nil {
+		return false, err
+	}
+	return true, pruneTags(tx)
+}
+
+// pruneTags removes the tags that are no longer held on any acronym
+// record from the TAGS table, within the transaction 'tx'.
+func pruneTags(tx *dbTx) error {
+	if _, err := tx.Exec("delete from TAGS where TagID not in (select TagID from ACRONYM_TAGS);"); err != nil {
+		return fmt.Errorf("ERROR: unable to tidy up unused tags: %v", err)
+	}
+	return nil
+}
+
+// ListTags returns every tag held in the TAGS table along with the
+// number of acronym records carrying it, ordered by name.
+//
+// The SQL select statement used is:
+//
+//	select t.TagID, t.Name, count(at.AcronymID) from TAGS t
+//	left join ACRONYM_TAGS at on at.TagID = t.TagID
+//	group by t.TagID order by t.Name;
+func ListTags() ([]Tag, error) {
+	rows, err := DB.Query(`select t.TagID, t.Name, count(at.AcronymID) from TAGS t
+		left join ACRONYM_TAGS at on at.TagID = t.TagID
+		group by t.TagID order by t.Name;`)
+	if err != nil {
+		return nil, fmt.Errorf("ERROR: unable to read the list of tags: %v", err)
+	}
+	defer rows.Close()
+
+	var tags []Tag
+	for rows.Next() {
+		var t Tag
+		if err = rows.Scan(&t.ID, &t.Name, &t.Count); err != nil {
+			return nil, fmt.Errorf("ERROR: reading tag record: %v", err)
+		}
+		tags = append(tags, t)
+	}
+	return tags, rows.Err()
+}
@@ -0,0 +1,255 @@
+// amt - program to access an SQLite database and lookup acronyms
+//
+// author:	Simon Rowe <simon@wiremoons.com>
+// license: open-source released under The MIT License (MIT).
+//
+// Package used to exchange acronyms with terminology tools as TBX for
+// application 'amt'
+//
+// TBX (TermBase eXchange, ISO 30042) is the XML format used by most
+// terminology and translation tools. Acronyms are exported as TBX-Basic
+// concept entries, each holding the acronym and its full form as two
+// terms of the same concept. Term entries in either TBX v3 or the older
+// v2 ('martif') layout can be imported - each abbreviation paired with
+// a full form becomes an acronym record.
+
+package lib
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// tbxNamespace is the XML namespace of TBX v3 documents.
+const tbxNamespace = "urn:iso:std:iso:30042:ed-2"
+
+// tbxLanguage is the language the acronyms are exported as - the
+// database does not record one.
+const tbxLanguage = "en"
+
+// tbxIDPrefix starts the ID given to each exported concept entry, which
+// is followed by the record UUID - an XML ID can not start with a digit.
+const tbxIDPrefix = "amt-"
+
+// tbxAbbreviations lists the TBX term types that are imported as the
+// acronym of a record.
+var tbxAbbreviations = map[string]bool{
+	"acronym":      true,
+	"abbreviation": true,
+	"initialism":   true,
+	"shortForm":    true,
+}
+
+// tbxDocument holds a TBX v3 document, as written by the export.
+type tbxDocument struct {
+	XMLName xml.Name         `xml:"tbx"`
+	Xmlns   string           `xml:"xmlns,attr"`
+	Type    string           `xml:"type,attr"`
+	Style   string           `xml:"style,attr"`
+	Lang    string           `xml:"http://www.w3.org/XML/1998/namespace lang,attr"`
+	Source  string           `xml:"tbxHeader>fileDesc>sourceDesc>p"`
+	Entries []tbxExportEntry `xml:"text>body>conceptEntry"`
+}
+
+// tbxExportEntry holds a single concept entry written by the export,
+// with the terms for it in 'LangSecs'.
+type tbxExportEntry struct {
+	ID       string             `xml:"id,attr"`
+	Descrips []tbxValue         `xml:"descrip"`
+	Admins   []tbxValue         `xml:"admin"`
+	LangSecs []tbxExportLangSec `xml:"langSec"`
+}
+
+// tbxExportLangSec holds the terms for a concept written by the export.
+type tbxExportLangSec struct {
+	Lang     string       `xml:"http://www.w3.org/XML/1998/namespace lang,attr"`
+	Descrips []tbxValue   `xml:"descrip"`
+	TermSecs []tbxTermSec `xml:"termSec"`
+}
+
+// tbxConceptEntry holds a single concept read from a TBX file - a
+// 'conceptEntry' in TBX v3 or a 'termEntry' in TBX v2 - with the terms
+// used for it in each language.
+type tbxConceptEntry struct {
+	ID          string       `xml:"id,attr"`
+	Descrips    []tbxValue   `xml:"descrip"`
+	DescripGrps []tbxValue   `xml:"descripGrp>descrip"`
+	Admins      []tbxValue   `xml:"admin"`
+	LangSecs    []tbxLangSec `xml:"langSec"`
+	LangSets    []tbxLangSec `xml:"langSet"`
+}
+
+// tbxLangSec holds the terms for a concept in one language read from a
+// TBX file - a 'langSec' in TBX v3 or a 'langSet' in TBX v2.
+type tbxLangSec struct {
+	Lang        string       `xml:"http://www.w3.org/XML/1998/namespace lang,attr"`
+	Descrips    []tbxValue   `xml:"descrip"`
+	DescripGrps []tbxValue   `xml:"descripGrp>descrip"`
+	TermSecs    []tbxTermSec `xml:"termSec"`
+	Tigs        []tbxTermSec `xml:"tig"`
+	Ntigs       []tbxTermSec `xml:"ntig>termGrp"`
+}
+
+// tbxTermSec holds a single term and the notes about it - a 'termSec'
+// in TBX v3 or a 'tig' or 'ntig' in TBX v2.
+type tbxTermSec struct {
+	Term  string     `xml:"term"`
+	Notes []tbxValue `xml:"termNote"`
+}
+
+// tbxValue holds a data category element, such as:
+//
+//	<descrip type="subjectField">General ICT</descrip>
+type tbxValue struct {
+	Type  string `xml:"type,attr"`
+	Value string `xml:",chardata"`
+}
+
+// tbxFind returns the value of the first of 'values' with the type 't'.
+func tbxFind(t string, values ...[]tbxValue) string {
+	for _, list := range values {
+		for _, v := range list {
+			if v.Type == t {
+				return strings.TrimSpace(v.Value)
+			}
+		}
+	}
+	return ""
+}
+
+// writeGlossaryTBX writes the acronym records in the glossary 'g' to 'w'
+// as a TBX-Basic document. Each record becomes a concept entry holding
+// the acronym and its full form as terms, with the source as its subject
+// field, the description as its definition and the tags as project
+// subsets.
+func writeGlossaryTBX(w io.Writer, g *Glossary) error {
+	doc := tbxDocument{
+		Xmlns:  tbxNamespace,
+		Type:   "TBX-Basic",
+		Style:  "dca",
+		Lang:   tbxLanguage,
+		Source: "Acronyms exported from " + Appname,
+	}
+	for _, r := range g.Records {
+		e := tbxExportEntry{ID: tbxIDPrefix + r.UUID}
+		if r.Source != "" {
+			e.Descrips = append(e.Descrips, tbxValue{Type: "subjectField", Value: r.Source})
+		}
+		for _, tag := range r.Tags {
+			e.Admins = append(e.Admins, tbxValue{Type: "projectSubset", Value: tag})
+		}
+		lang := tbxExportLangSec{Lang: tbxLanguage}
+		if r.Description != "" {
+			lang.Descrips = append(lang.Descrips, tbxValue{Type: "definition", Value: r.Description})
+		}
+		lang.TermSecs = []tbxTermSec{
+			{Term: r.Acronym, Notes: []tbxValue{{Type: "termType", Value: "acronym"}}},
+			{Term: r.Definition, Notes: []tbxValue{{Type: "termType", Value: "fullForm"}}},
+		}
+		e.LangSecs = append(e.LangSecs, lang)
+		doc.Entries = append(doc.Entries, e)
+	}
+
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	if err := enc.Encode(doc); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
+
+// ReadTBX reads the acronyms held in the TBX document 'r'. Each term
+// entry holding an abbreviation and a full form - the term types
+// 'acronym', 'abbreviation', 'initialism' or 'shortForm', and
+// 'fullForm' - is returned as a record, with the subject field as its
+// source, the definition as its description and any project subsets
+// as tags. An entry without such a pair is returned with its 'Err' set,
+// so it is reported as rejected. Rows are numbered by their term entry
+// in the file.
+func ReadTBX(r io.Reader) ([]ImportRow, error) {
+	var rows []ImportRow
+	dec := xml.NewDecoder(r)
+	entryNo := 0
+	for {
+		tok, err := dec.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return rows, fmt.Errorf("ERROR: unable to read the TBX file: %v", err)
+		}
+		start, ok := tok.(xml.StartElement)
+		if !ok || (start.Name.Local != "conceptEntry" && start.Name.Local != "termEntry") {
+			continue
+		}
+		entryNo++
+		var e tbxConceptEntry
+		if err = dec.DecodeElement(&e, &start); err != nil {
+			return rows, fmt.Errorf("ERROR: unable to read term entry %d of the TBX file: %v", entryNo, err)
+		}
+		rows = append(rows, tbxRecords(entryNo, e)...)
+	}
+	if entryNo == 0 {
+		return nil, fmt.Errorf("ERROR: no term entries were found in the TBX file")
+	}
+	return rows, nil
+}
+
+// tbxRecords returns the acronym records held in the TBX term entry
+// 'e', which is entry 'entryNo' of the file.
+func tbxRecords(entryNo int, e tbxConceptEntry) []ImportRow {
+	base := Record{
+		Source: tbxFind("subjectField", e.Descrips, e.DescripGrps),
+	}
+	for _, a := range e.Admins {
+		if a.Type == "projectSubset" {
+			base.Tags = append(base.Tags, strings.TrimSpace(a.Value))
+		}
+	}
+
+	var rows []ImportRow
+	for _, lang := range append(e.LangSecs, e.LangSets...) {
+		var abbreviations []string
+		var fullForm, untyped string
+		for _, t := range append(append(lang.TermSecs, lang.Tigs...), lang.Ntigs...) {
+			term := strings.Join(strings.Fields(t.Term), " ")
+			termType := tbxFind("termType", t.Notes)
+			switch {
+			case term == "":
+			case tbxAbbreviations[termType]:
+				abbreviations = append(abbreviations, term)
+			case termType == "fullForm" && fullForm == "":
+				fullForm = term
+			case termType == "" && untyped == "":
+				untyped = term
+			}
+		}
+		if fullForm == "" {
+			fullForm = untyped
+		}
+		if fullForm == "" {
+			continue
+		}
+		for _, a := range abbreviations {
+			r := base
+			r.Acronym, r.Definition = a, fullForm
+			r.Description = tbxFind("definition", lang.Descrips, lang.DescripGrps, e.Descrips, e.DescripGrps)
+			rows = append(rows, ImportRow{Row: entryNo, Record: r})
+		}
+	}
+	if len(rows) == 0 {
+		return []ImportRow{{Row: entryNo, Err: fmt.Errorf("term entry '%s' has no abbreviation paired with a full form", e.ID)}}
+	}
+	// an entry exported by amt keeps its record UUID, so importing it
+	// again matches the same record
+	if id := strings.TrimPrefix(e.ID, tbxIDPrefix); len(rows) == 1 && id != e.ID && IsUUID(id) {
+		rows[0].Record.UUID = id
+	}
+	return rows
+}
@@ -0,0 +1,182 @@
+// amt - program to access an SQLite database and lookup acronyms
+//
+// author:	Simon Rowe <simon@wiremoons.com>
+// license: open-source released under The MIT License (MIT).
+//
+// Package used to manage the API tokens accepted by the HTTP server for
+// application 'amt'
+//
+// Each token has a name, recorded as the author of the changes made
+// with it, and a role: 'reader' tokens can only look up acronyms,
+// 'editor' tokens can also add, change and remove them, and 'admin'
+// tokens can also issue and revoke tokens. A token is only shown when it
+// is issued - the 'TOKENS' table holds a hash of it.
+
+package lib
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+)
+
+// The roles that can be given to an API token, from the least to the
+// most allowed.
+const (
+	RoleReader = "reader"
+	RoleEditor = "editor"
+	RoleAdmin  = "admin"
+)
+
+// roleRank orders the roles, so a role can do anything a lower ranked
+// role can.
+var roleRank = map[string]int{
+	RoleReader: 1,
+	RoleEditor: 2,
+	RoleAdmin:  3,
+}
+
+// tokenPrefix starts every API token, so one is easy to recognise.
+const tokenPrefix = "amt_"
+
+// maxTokenNameLen is the longest name an API token can be given.
+const maxTokenNameLen = 64
+
+// Token holds a single API token, without the token itself.
+type Token struct {
+	ID        int64     `json:"-"`
+	Name      string    `json:"name"`
+	Role      string    `json:"role"`
+	CreatedAt time.Time `json:"created_at"`
+	CreatedBy string    `json:"created_by"`
+}
+
+// tokenQuery is the select statement used to read the tokens that have
+// not been revoked from the TOKENS table. Callers append their own
+// conditions and 'order by' clause.
+const tokenQuery = "select TokenID, Name, Role, CreatedAt, CreatedBy from TOKENS where RevokedAt is null"
+
+// scanToken reads a single row returned by 'tokenQuery' into a Token.
+func scanToken(row interface{ Scan(...interface{}) error }) (Token, error) {
+	var t Token
+	var createdAt sql.NullString
+	err := row.Scan(&t.ID, &t.Name, &t.Role, &createdAt, &t.CreatedBy)
+	t.CreatedAt = parseDBTime(createdAt)
+	return t, err
+}
+
+// hashToken returns the hash of the API token 'secret' held in the
+// database.
+func hashToken(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+// ValidRole returns an error if 'role' is not one of the roles an API
+// token can be given.
+func ValidRole(role string) error {
+	if _, ok := roleRank[role]; !ok {
+		return fmt.Errorf("ERROR: role '%s' is not known - use one of: %s, %s or %s", role, RoleReader, RoleEditor, RoleAdmin)
+	}
+	return nil
+}
+
+// IssueToken adds a new API token called 'name' with the role 'role',
+// issued by 'author', and returns the token. It can not be read back later, so must be
+// given to its user straight away.
+func IssueToken(name, role, author string) (secret string, err error) {
+	name = strings.TrimSpace(name)
+	switch {
+	case name == "":
+		return "", fmt.Errorf("ERROR: an API token must be given a name")
+	case utf8.RuneCountInString(name) > maxTokenNameLen:
+		return "", fmt.Errorf("ERROR: API token name '%s' is longer than %d characters", name, maxTokenNameLen)
+	case strings.IndexFunc(name, unicode.IsControl) >= 0 || !utf8.ValidString(name):
+		return "", fmt.Errorf("ERROR: API token name '%s' contains characters that can not be used", name)
+	}
+	if err = ValidRole(role); err != nil {
+		return "", err
+	}
+
+	var b [32]byte
+	if _, err = rand.Read(b[:]); err != nil {
+		return "", fmt.Errorf("ERROR: unable to make a new API token: %v", err)
+	}
+	secret = tokenPrefix + hex.EncodeToString(b[:])
+
+	err = withTx(author, func(tx *dbTx) error {
+		var n int
+		if err := tx.QueryRow("select count(*) from TOKENS where Name = ? and RevokedAt is null;", name).Scan(&n); err != nil {
+			return err
+		}
+		if n > 0 {
+			return fmt.Errorf("ERROR: an API token called '%s' already exists - revoke it first to replace it", name)
+		}
+		_, err := tx.Exec("insert into TOKENS(Name, Role, Hash, CreatedAt, CreatedBy) values(?,?,?,?,?);",
+			name, role, hashToken(secret), dbTime(time.Now()), tx.author)
+		return err
+	})
+	if err != nil {
+		return "", err
+	}
+	return secret, nil
+}
+
+// RevokeToken stops the API token called 'name' from being accepted.
+// The error 'sql.ErrNoRows' is returned if there is no such token.
+func RevokeToken(name, author string) error {
+	return withTx(author, func(tx *dbTx) error {
+		result, err := tx.Exec("update TOKENS set RevokedAt = ? where Name = ? and RevokedAt is null;",
+			dbTime(time.Now()), strings.TrimSpace(name))
+		if err != nil {
+			return fmt.Errorf("ERROR: unable to revoke API token '%s': %v", name, err)
+		}
+		if n, err := result.RowsAffected(); err != nil || n == 0 {
+			return sql.ErrNoRows
+		}
+		return nil
+	})
+}
+
+// ListTokens returns every API token that has not been revoked, in name
+// order.
+func ListTokens() ([]Token, error) {
+	rows, err := DB.Query(tokenQuery + " order by Name;")
+	if err != nil {
+		return nil, fmt.Errorf("ERROR: unable to read the API tokens: %v", err)
+	}
+	defer rows.Close()
+
+	var tokens []Token
+	for rows.Next() {
+		t, err := scanToken(rows)
+		if err != nil {
+			return nil, fmt.Errorf("ERROR: reading API token: %v", err)
+		}
+		tokens = append(tokens, t)
+	}
+	return tokens, rows.Err()
+}
+
+// findToken returns the API token 'secret', if it has been issued and
+// not revoked. The error 'sql.ErrNoRows' is returned if it has not.
+func findToken(secret string) (Token, error) {
+	if !strings.HasPrefix(secret, tokenPrefix) {
+		return Token{}, sql.ErrNoRows
+	}
+	return scanToken(DB.QueryRow(tokenQuery+" and Hash = ?;", hashToken(secret)))
+}
+
+// hasTokens returns true if any API tokens have been issued and not
+// revoked.
+func hasTokens() (bool, error) {
+	var n int
+	err := DB.QueryRow("select count(*) from TOKENS where RevokedAt is null;").Scan(&n)
+	return n > 0, err
+}
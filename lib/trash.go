@@ -0,0 +1,219 @@
+// amt - program to access an SQLite database and lookup acronyms
+//
+// author:	Simon Rowe <simon@wiremoons.com>
+// license: open-source released under The MIT License (MIT).
+//
+// Package used to manage removed acronym records, and to undo changes,
+// for application 'amt'
+//
+// Removed records are moved to the 'TRASH' table rather than being
+// deleted outright. From there they can be restored, or purged once
+// they are no longer wanted.
+
+package lib
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// TrashItem holds a single removed acronym record, as held in the
+// TRASH table, along with when it was removed and by whom.
+type TrashItem struct {
+	ID        int64     `json:"id"`
+	Record    Record    `json:"record"`
+	DeletedAt time.Time `json:"deleted_at"`
+	DeletedBy string    `json:"deleted_by"`
+}
+
+// trashQuery is the select statement used to read items from the
+// TRASH table. Callers append their own 'where' and 'order by'
+// clauses.
+const trashQuery = "select TrashID, RecordValues, DeletedAt, DeletedBy from TRASH"
+
+// scanTrashItem reads a single row returned by 'trashQuery' into a
+// TrashItem.
+func scanTrashItem(row interface{ Scan(...interface{}) error }) (TrashItem, error) {
+	var item TrashItem
+	var values, deletedAt sql.NullString
+	if err := row.Scan(&item.ID, &values, &deletedAt, &item.DeletedBy); err != nil {
+		return item, err
+	}
+	item.DeletedAt = parseDBTime(deletedAt)
+	r, err := parseRecordJSON(values)
+	if err != nil {
+		return item, fmt.Errorf("ERROR: reading trash ID '%d': %v", item.ID, err)
+	}
+	if r != nil {
+		item.Record = *r
+	}
+	return item, nil
+}
+
+// ListTrash returns every removed acronym record held in the TRASH
+// table, most recently removed first.
+func ListTrash() ([]TrashItem, error) {
+	rows, err := DB.Query(trashQuery + " order by TrashID desc;")
+	if err != nil {
+		return nil, fmt.Errorf("ERROR: unable to read the trash: %v", err)
+	}
+	defer rows.Close()
+
+	var items []TrashItem
+	for rows.Next() {
+		item, err := scanTrashItem(rows)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, item)
+	}
+	return items, rows.Err()
+}
+
+// RestoreRecord brings the removed acronym record with the trash ID
+// 'trashID' back into the ACRONYMS table, and returns it. The record
+// keeps its old ID unless another record has since taken it, and the
+// restore is recorded as made by 'author'. The error 'sql.ErrNoRows' is
+// returned if there is no such trash item.
+func RestoreRecord(trashID int64, author string) (r Record, err error) {
+	err = withTx(author, func(tx *dbTx) error {
+		r, err = restoreRecord(tx, trashID, auditRestore)
+		return err
+	})
+	return r, err
+}
+
+// restoreRecord brings back a removed acronym record within the
+// transaction 'tx', adding the change to its history as 'action'.
+func restoreRecord(tx *dbTx, trashID int64, action string) (Record, error) {
+	item, err := scanTrashItem(tx.QueryRow(trashQuery+" where TrashID = ?;", trashID))
+	if err != nil {
+		return Record{}, err
+	}
+	r := item.Record
+	// the version carries on from the removed record, so a copy of it
+	// taken before it was removed is still seen as out of date
+	r.Version++
+	if err = storeRecord(tx, &r); err != nil {
+		return r, err
+	}
+	result, err := tx.Exec("delete from TRASH where TrashID = ?;", trashID)
+	if err != nil {
+		return r, fmt.Errorf("ERROR: removing trash ID '%d': %v", trashID, err)
+	}
+	if err = checkRowsAffected(result, 1); err != nil {
+		return r, fmt.Errorf("ERROR: removing trash ID '%d': %v", trashID, err)
+	}
+	return r, auditChange(tx, action, r.ID, nil)
+}
+
+// PurgeTrash permanently deletes the removed acronym records that have
+// been in the trash for longer than 'age', and returns how many were
+// deleted. Their change history is kept.
+func PurgeTrash(age time.Duration) (purged int64, err error) {
+	err = withTx(CurrentUser(), func(tx *dbTx) error {
+		result, err := tx.Exec("delete from TRASH where DeletedAt < ?;", dbTime(time.Now().Add(-age)))
+		if err != nil {
+			return fmt.Errorf("ERROR: unable to purge the trash: %v", err)
+		}
+		purged, err = result.RowsAffected()
+		return err
+	})
+	return purged, err
+}
+
+// UndoLast reverses the most recent change made by 'author' that has
+// not already been undone, and returns a description of what
+// was done. Calling it again steps further back through the user's
+// changes.
+//
+// A change is only undone if nobody has changed the same record since,
+// so an undo never overwrites the work of another user. The undo is
+// saved in a single transaction.
+func UndoLast(author string) (done string, err error) {
+	err = withTx(author, func(tx *dbTx) error {
+		done, err = undoLast(tx)
+		return err
+	})
+	return done, err
+}
+
+// undoLast reverses the most recent change made by the author of the
+// transaction 'tx', within it.
+func undoLast(tx *dbTx) (string, error) {
+	user := tx.author
+	var e AuditEntry
+	var oldValues sql.NullString
+	err := tx.QueryRow(`select AuditID, AcronymID, Action, OldValues from AUDIT
+		where ChangedBy = ? and Undone = 0 order by AuditID desc limit 1;`, user).
+		Scan(&e.ID, &e.AcronymID, &e.Action, &oldValues)
+	if err == sql.ErrNoRows {
+		return "", fmt.Errorf("ERROR: there are no changes made by '%s' left to undo", user)
+	}
+	if err != nil {
+		return "", fmt.Errorf("ERROR: unable to find the last change to undo: %v", err)
+	}
+	if e.Old, err = parseRecordJSON(oldValues); err != nil {
+		return "", fmt.Errorf("ERROR: reading history record %d: %v", e.ID, err)
+	}
+
+	var later int
+	err = tx.QueryRow("select count(*) from AUDIT where AcronymID = ? and AuditID > ? and Undone = 0;",
+		e.AcronymID, e.ID).Scan(&later)
+	if err != nil {
+		return "", fmt.Errorf("ERROR: checking for later changes to acronym ID '%d': %v", e.AcronymID, err)
+	}
+	if later > 0 {
+		return "", fmt.Errorf("ERROR: the last change by '%s' (%s of acronym ID '%d') can not be undone as the record has been changed since\nrun '%s history %d' to see the changes made",
+			user, e.Action, e.AcronymID, Appname, e.AcronymID)
+	}
+
+	var done string
+	switch e.Action {
+	case auditInsert, auditRestore:
+		trashID, err := deleteRecord(tx, e.AcronymID, auditUndo)
+		if err != nil {
+			return "", err
+		}
+		done = fmt.Sprintf("acronym ID '%d' removed again - moved to trash ID '%d'", e.AcronymID, trashID)
+	case auditUpdate:
+		current, err := getRecord(tx, e.AcronymID)
+		if err != nil {
+			return "", err
+		}
+		if err = revertRecord(tx, e.Old); err != nil {
+			return "", err
+		}
+		if err = auditChange(tx, auditUndo, e.AcronymID, &current); err != nil {
+			return "", err
+		}
+		done = fmt.Sprintf("acronym ID '%d' changed back to its earlier values", e.AcronymID)
+	case auditDelete:
+		var trashID int64
+		err := tx.QueryRow("select TrashID from TRASH where AcronymID = ? order by TrashID desc limit 1;",
+			e.AcronymID).Scan(&trashID)
+		if err == sql.ErrNoRows {
+			return "", fmt.Errorf("ERROR: acronym ID '%d' can not be restored as it is no longer held in the trash", e.AcronymID)
+		}
+		if err != nil {
+			return "", err
+		}
+		r, err := restoreRecord(tx, trashID, auditUndo)
+		if err != nil {
+			return "", err
+		}
+		done = fmt.Sprintf("acronym '%s' restored from the trash as ID '%d'", r.Acronym, r.ID)
+	default:
+		return "", fmt.Errorf("ERROR: unable to undo a '%s' change", e.Action)
+	}
+
+	result, err := tx.Exec("update AUDIT set Undone = 1 where AuditID = ?;", e.ID)
+	if err == nil {
+		err = checkRowsAffected(result, 1)
+	}
+	if err != nil {
+		return "", fmt.Errorf("ERROR: unable to mark change %d as undone: %v", e.ID, err)
+	}
+	return done, nil
+}
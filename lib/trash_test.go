@@ -0,0 +1,134 @@
+// amt - program to access an SQLite database and lookup acronyms
+//
+// author:	Simon Rowe <simon@wiremoons.com>
+// license: open-source released under The MIT License (MIT).
+//
+// Package used to test the trash and undo of acronym record changes
+// for application 'amt'
+
+package lib
+
+import (
+	"database/sql"
+	"reflect"
+	"testing"
+)
+
+func TestRestoreRecord(t *testing.T) {
+	migrateTestDB(t)
+	r := Record{
+		Acronym:     "SNI",
+		Definition:  "Server Name Indication",
+		Description: "TLS extension",
+		Source:      "Networking",
+		Tags:        []string{"tls"},
+	}
+	if err := InsertRecord(&r, "alice"); err != nil {
+		t.Fatal(err)
+	}
+	r, err := GetRecord(r.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	trashID, err := DeleteRecord(r.ID, "alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = GetRecord(r.ID); err != sql.ErrNoRows {
+		t.Fatalf("GetRecord() of a removed record error = %v, want %v", err, sql.ErrNoRows)
+	}
+
+	restored, err := RestoreRecord(trashID, "alice")
+	if err != nil {
+		t.Fatalf("RestoreRecord() error = %v", err)
+	}
+	got, err := GetRecord(r.ID)
+	if err != nil {
+		t.Fatalf("GetRecord() of a restored record error = %v", err)
+	}
+	// the restore is a change to the record, so moves on its version
+	want := r
+	want.Version++
+	if !reflect.DeepEqual(got, want) || !reflect.DeepEqual(restored, want) {
+		t.Errorf("RestoreRecord() = %+v, read back as %+v, want %+v", restored, got, want)
+	}
+	items, err := ListTrash()
+	if err != nil || len(items) != 0 {
+		t.Errorf("ListTrash() after restore = %+v, %v, want an empty trash", items, err)
+	}
+	if _, err = RestoreRecord(trashID, "alice"); err != sql.ErrNoRows {
+		t.Errorf("RestoreRecord() of a restored record error = %v, want %v", err, sql.ErrNoRows)
+	}
+}
+
+func TestUndoLast(t *testing.T) {
+	migrateTestDB(t)
+	r := Record{Acronym: "SNI", Definition: "Server Name Indication"}
+	if err := InsertRecord(&r, "alice"); err != nil {
+		t.Fatal(err)
+	}
+	r, err := GetRecord(r.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.Definition = "Server Name Identification"
+	if err := UpdateRecord(&r, "alice"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := DeleteRecord(r.ID, "alice"); err != nil {
+		t.Fatal(err)
+	}
+
+	// each undo steps one change further back
+	steps := []struct {
+		undone     string
+		exists     bool
+		definition string
+	}{
+		{undone: "removal", exists: true, definition: "Server Name Identification"},
+		{undone: "change", exists: true, definition: "Server Name Indication"},
+		{undone: "addition", exists: false},
+	}
+	for _, step := range steps {
+		if _, err := UndoLast("alice"); err != nil {
+			t.Fatalf("UndoLast() of the %s error = %v", step.undone, err)
+		}
+		got, err := GetRecord(r.ID)
+		switch {
+		case !step.exists && err != sql.ErrNoRows:
+			t.Errorf("after undo of the %s GetRecord() = %+v, %v, want %v", step.undone, got, err, sql.ErrNoRows)
+		case step.exists && err != nil:
+			t.Errorf("after undo of the %s GetRecord() error = %v", step.undone, err)
+		case step.exists && got.Definition != step.definition:
+			t.Errorf("after undo of the %s definition = %q, want %q", step.undone, got.Definition, step.definition)
+		}
+	}
+	if done, err := UndoLast("alice"); err == nil {
+		t.Errorf("UndoLast() with no changes left = %q, want an error", done)
+	}
+}
+
+func TestUndoLastChangedSince(t *testing.T) {
+	migrateTestDB(t)
+	r := Record{Acronym: "TLA", Definition: "Three Letter Acronym"}
+	if err := InsertRecord(&r, "alice"); err != nil {
+		t.Fatal(err)
+	}
+	r, err := GetRecord(r.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.Definition = "Three Letter Abbreviation"
+	if err := UpdateRecord(&r, "bob"); err != nil {
+		t.Fatal(err)
+	}
+
+	// alice's addition is not undone, as bob has changed the record since
+	if done, err := UndoLast("alice"); err == nil {
+		t.Errorf("UndoLast() of a record changed since = %q, want an error", done)
+	}
+	got, err := GetRecord(r.ID)
+	if err != nil || got.Definition != r.Definition {
+		t.Errorf("GetRecord() after refused undo = %+v, %v, want definition %q", got, err, r.Definition)
+	}
+}
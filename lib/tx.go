@@ -0,0 +1,201 @@
+// amt - program to access an SQLite database and lookup acronyms
+//
+// author:	Simon Rowe <simon@wiremoons.com>
+// license: open-source released under The MIT License (MIT).
+//
+// Package used to run database changes within transactions for
+// application 'amt'
+//
+// Every change to the acronym records - including its tags, history
+// and trash entries - is made within a single transaction, so a
+// change is either saved completely or not at all.
+
+package lib
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"time"
+
+	"github.com/mattn/go-sqlite3"
+)
+
+// ErrInterrupted is returned when the user presses Ctrl + c while a
+// change is being saved. The transaction is rolled back, so none of
+// the change is kept.
+var ErrInterrupted = errors.New("ERROR: interrupted - no changes were saved to the database")
+
+// The number of times a change is attempted while another user holds
+// the database lock, and the delay before the first retry - which
+// doubles on each further attempt.
+const (
+	maxTxAttempts = 5
+	txRetryDelay  = 200 * time.Millisecond
+)
+
+// ErrReadOnly is returned when a change is attempted while the
+// database is open read-only.
+var ErrReadOnly = errors.New("ERROR: the database is open read-only - no changes can be made")
+
+// requireWritable returns 'ErrReadOnly' if the database is open
+// read-only. It is checked before the user is asked for the details of
+// a change, so they are not asked for anything that can not be saved.
+func requireWritable() error {
+	if ReadOnly || Immutable {
+		return ErrReadOnly
+	}
+	return nil
+}
+
+// dbQuerier is satisfied by both '*sql.DB' and '*sql.Tx', so the
+// functions that read records can be used either on their own or
+// within a transaction.
+type dbQuerier interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+// dbTx is a database transaction, along with the name recorded as the
+// author of the changes made within it.
+type dbTx struct {
+	*sql.Tx
+	author string
+}
+
+// withTx runs 'fn' within a new database transaction, recording the
+// changes it makes as made by 'author'. The transaction
+// is committed if 'fn' succeeds, and rolled back if it returns an
+// error or the user presses Ctrl + c before it is committed - in which
+// case 'ErrInterrupted' is returned.
+//
+// If the database is still locked by another user once the busy
+// timeout has passed, the whole transaction is tried again after a
+// growing delay, up to 'maxTxAttempts' times. As 'fn' may be run more
+// than once it must not keep any state from an earlier attempt.
+func withTx(author string, fn func(tx *dbTx) error) error {
+	if err := requireWritable(); err != nil {
+		return err
+	}
+	delay := txRetryDelay
+	for attempt := 1; ; attempt++ {
+		err := runTx(author, fn)
+		if err != errBusy {
+			return err
+		}
+		if attempt == maxTxAttempts {
+			return fmt.Errorf("ERROR: the database is in use by another user - no changes were saved, please try again")
+		}
+		if DebugSwitch {
+			log.Printf("DEBUG: database locked - retrying change in %v (attempt %d of %d)\n", delay, attempt+1, maxTxAttempts)
+		}
+		time.Sleep(delay)
+		delay *= 2
+	}
+}
+
+// errDryRun is returned within a dry run transaction, so that
+// everything done in it is rolled back.
+var errDryRun = errors.New("dry run - changes rolled back")
+
+// dryRunTx runs 'fn' within a database transaction that is always
+// rolled back, so a dry run can make its changes to report their
+// outcome while saving nothing. A database open read-only can not be
+// changed even within a transaction, so a temporary copy of it is used
+// in its place.
+func dryRunTx(author string, fn func(tx *dbTx) error) error {
+	dryRun := func(tx *dbTx) error {
+		if err := fn(tx); err != nil {
+			return err
+		}
+		return errDryRun
+	}
+	if requireWritable() == nil {
+		if err := withTx(author, dryRun); err != errDryRun {
+			return err
+		}
+		return nil
+	}
+
+	dir, err := os.MkdirTemp("", Appname)
+	if err != nil {
+		return fmt.Errorf("ERROR: unable to make a copy of the database for the dry run: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	name := filepath.Join(dir, "dry-run.db")
+	if DebugSwitch {
+		log.Printf("DEBUG: database is read-only - copying it to '%s' for the dry run\n", name)
+	}
+	if _, err = DB.Exec("VACUUM INTO ?;", name); err != nil {
+		return fmt.Errorf("ERROR: unable to make a copy of the database for the dry run: %v", err)
+	}
+	db, err := sql.Open("sqlite3", fileURI(name)+"?_foreign_keys=on")
+	if err != nil {
+		return fmt.Errorf("ERROR: unable to open the copy of the database for the dry run: %v", err)
+	}
+	defer db.Close()
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("ERROR: unable to start a database transaction: %v", err)
+	}
+	defer tx.Rollback()
+	if err = dryRun(&dbTx{Tx: tx, author: author}); err != errDryRun {
+		return err
+	}
+	return nil
+}
+
+// errBusy is returned by runTx when the transaction could not be
+// started or committed because another user holds the database lock.
+var errBusy = errors.New("database is locked")
+
+// isBusy reports whether 'err' was caused by another user holding the
+// database lock.
+func isBusy(err error) bool {
+	var sqliteErr sqlite3.Error
+	return errors.As(err, &sqliteErr) &&
+		(sqliteErr.Code == sqlite3.ErrBusy || sqliteErr.Code == sqlite3.ErrLocked)
+}
+
+// runTx makes a single attempt to run 'fn' within a transaction.
+func runTx(author string, fn func(tx *dbTx) error) error {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	// transactions start with the write lock - see 'dataSourceName' -
+	// so once begun no other user can block the change part way through
+	tx, err := DB.BeginTx(ctx, nil)
+	if isBusy(err) {
+		return errBusy
+	}
+	if err != nil {
+		return fmt.Errorf("ERROR: unable to start a database transaction: %v", err)
+	}
+	err = fn(&dbTx{Tx: tx, author: author})
+	if ctx.Err() != nil {
+		_ = tx.Rollback()
+		return ErrInterrupted
+	}
+	if err != nil {
+		if rbErr := tx.Rollback(); rbErr != nil && DebugSwitch {
+			log.Printf("DEBUG: rolling back transaction: %v\n", rbErr)
+		}
+		return err
+	}
+	if err = tx.Commit(); err != nil {
+		if ctx.Err() != nil {
+			return ErrInterrupted
+		}
+		if isBusy(err) {
+			return errBusy
+		}
+		return fmt.Errorf("ERROR: unable to save changes to the database: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,158 @@
+// amt - program to access an SQLite database and lookup acronyms
+//
+// author:	Simon Rowe <simon@wiremoons.com>
+// license: open-source released under The MIT License (MIT).
+//
+// Package used to test the database transactions for application 'amt'
+
+package lib
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestWithTx(t *testing.T) {
+	openTestDB(t)
+	if _, err := DB.Exec("CREATE TABLE T (N INTEGER);"); err != nil {
+		t.Fatal(err)
+	}
+	failed := errors.New("failed")
+	tests := []struct {
+		name       string
+		statements []string
+		fnErr      error
+		wantErr    bool
+		wantRows   int
+	}{
+		{
+			name:       "commit",
+			statements: []string{"INSERT INTO T VALUES (1);", "INSERT INTO T VALUES (2);"},
+			wantRows:   2,
+		},
+		{
+			name:       "failed statement",
+			statements: []string{"INSERT INTO T VALUES (1);", "INSERT INTO MISSING VALUES (2);"},
+			wantErr:    true,
+		},
+		{
+			name:       "error returned",
+			statements: []string{"INSERT INTO T VALUES (1);", "INSERT INTO T VALUES (2);"},
+			fnErr:      failed,
+			wantErr:    true,
+		},
+	}
+	for _, tt := range tests {
+		if _, err := DB.Exec("DELETE FROM T;"); err != nil {
+			t.Fatal(err)
+		}
+		err := withTx("alice", func(tx *dbTx) error {
+			for _, s := range tt.statements {
+				if _, err := tx.Exec(s); err != nil {
+					return err
+				}
+			}
+			return tt.fnErr
+		})
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: withTx() error = %v, want error %v", tt.name, err, tt.wantErr)
+		}
+		if tt.fnErr != nil && err != tt.fnErr {
+			t.Errorf("%s: withTx() error = %v, want %v", tt.name, err, tt.fnErr)
+		}
+		// a failed transaction leaves none of its changes behind
+		var rows int
+		if err = DB.QueryRow("SELECT count(*) FROM T;").Scan(&rows); err != nil {
+			t.Fatal(err)
+		}
+		if rows != tt.wantRows {
+			t.Errorf("%s: table holds %d rows after withTx(), want %d", tt.name, rows, tt.wantRows)
+		}
+	}
+}
+
+func TestWithTxRetry(t *testing.T) {
+	openTestDB(t)
+	if _, err := DB.Exec("CREATE TABLE T (N INTEGER);"); err != nil {
+		t.Fatal(err)
+	}
+	// fail at once when the database is locked, rather than after the
+	// busy timeout, so each attempt made by withTx can be seen
+	DB.Close()
+	var err error
+	if DB, err = sql.Open("sqlite3", DbName+"?_busy_timeout=0&_txlock=immediate"); err != nil {
+		t.Fatal(err)
+	}
+	other, err := sql.Open("sqlite3", DbName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer other.Close()
+
+	tests := []struct {
+		name     string
+		held     bool // lock held by another user until every attempt is made
+		wantErr  bool
+		wantRuns int
+	}{
+		{name: "lock released", held: false, wantRuns: 1},
+		{name: "lock held", held: true, wantErr: true, wantRuns: 0},
+	}
+	for _, tt := range tests {
+		ctx := context.Background()
+		conn, err := other.Conn(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err = conn.ExecContext(ctx, "BEGIN IMMEDIATE;"); err != nil {
+			t.Fatal(err)
+		}
+		release := func() {
+			if _, err := conn.ExecContext(ctx, "ROLLBACK;"); err != nil {
+				t.Error(err)
+			}
+			conn.Close()
+		}
+		released := make(chan struct{})
+		if !tt.held {
+			// released in time for the third attempt
+			go func() {
+				time.Sleep(2 * txRetryDelay)
+				release()
+				close(released)
+			}()
+		}
+
+		runs := 0
+		err = withTx("alice", func(tx *dbTx) error {
+			runs++
+			_, err := tx.Exec("INSERT INTO T VALUES (1);")
+			return err
+		})
+		if tt.held {
+			release()
+		} else {
+			<-released
+		}
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: withTx() error = %v, want error %v", tt.name, err, tt.wantErr)
+		}
+		if err == errBusy {
+			t.Errorf("%s: withTx() error = %v, want a message for the user", tt.name, err)
+		}
+		if runs != tt.wantRuns {
+			t.Errorf("%s: change made %d times, want %d", tt.name, runs, tt.wantRuns)
+		}
+	}
+
+	var rows int
+	if err = DB.QueryRow("SELECT count(*) FROM T;").Scan(&rows); err != nil {
+		t.Fatal(err)
+	}
+	if rows != 1 {
+		t.Errorf("table holds %d rows, want 1", rows)
+	}
+}
@@ -0,0 +1,436 @@
+// amt - program to access an SQLite database and lookup acronyms
+//
+// author:	Simon Rowe <simon@wiremoons.com>
+// license: open-source released under The MIT License (MIT).
+//
+// Package used to offer web pages to browse and change the acronyms
+// for application 'amt'
+//
+// The pages are served by 'amt serve' alongside the HTTP API, so the
+// acronyms can be looked up and kept up to date from a web browser. The
+// search shows results as they are typed, using the API, and each
+// acronym has its own page with forms to change or remove it. Changes
+// are checked and saved by the same functions as the command line. The
+// templates and other files are held within the program, in the 'ui'
+// directory.
+
+package lib
+
+import (
+	"bytes"
+	"crypto/subtle"
+	"embed"
+	"errors"
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// uiFiles holds the page templates and other files used by the web
+// pages.
+//
+//go:embed ui
+var uiFiles embed.FS
+
+// uiTemplates holds the templates for every web page.
+var uiTemplates = template.Must(template.New("ui").Funcs(template.FuncMap{
+	"date": func(t time.Time) string { return t.Local().Format("2 Jan 2006 15:04") },
+	"join": strings.Join,
+}).ParseFS(uiFiles, "ui/*.html", "ui/*.tmpl"))
+
+// uiAssets lists the files served unchanged under '/ui/', and where
+// each is held - the style of the exported glossary site is shared.
+var uiAssets = map[string]struct {
+	files *embed.FS
+	name  string
+}{
+	"style.css": {&siteFiles, "site/style.css"},
+	"ui.css":    {&uiFiles, "ui/ui.css"},
+	"ui.js":     {&uiFiles, "ui/ui.js"},
+}
+
+// uiMessages holds the messages shown after a change, named by the
+// 'msg' query parameter of the page the browser is sent to.
+var uiMessages = map[string]string{
+	"added":   "The new acronym has been added.",
+	"saved":   "The changes have been saved.",
+	"deleted": "The acronym has been moved to the trash.",
+}
+
+// uiToken is sent with every form and must be returned with it, so a
+// form on another web site can not make changes. A new one is made
+// each time the server starts.
+var uiToken string
+
+// uiPage holds everything shown on a web page.
+type uiPage struct {
+	Title    string
+	App      string
+	ReadOnly bool
+	CanEdit  bool
+	Token    string
+	Message  string
+	Error    string
+	Sources  []Source
+	// the search page
+	Query   string
+	Source  string
+	Summary string
+	Records []Record
+	Prev    string
+	Next    string
+	// the acronym and form pages
+	Record   Record
+	Conflict *Record
+	Action   string
+	Cancel   string
+}
+
+// newUIPage returns a page with the title 'title'.
+func newUIPage(title string) *uiPage {
+	return &uiPage{
+		Title:    title,
+		App:      Appname,
+		ReadOnly: ReadOnly || Immutable,
+		Token:    uiToken,
+	}
+}
+
+// uiAsset sends one of the style sheet or script files.
+func uiAsset(w http.ResponseWriter, r *http.Request) {
+	asset, ok := uiAssets[strings.TrimPrefix(r.URL.Path, "/ui/")]
+	if !ok {
+		uiError(w, http.StatusNotFound, "The page '"+r.URL.Path+"' does not exist.")
+		return
+	}
+	content, err := asset.files.ReadFile(asset.name)
+	if err != nil {
+		uiError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	w.Header().Set("Cache-Control", "no-cache")
+	http.ServeContent(w, r, asset.name, time.Time{}, bytes.NewReader(content))
+}
+
+// uiSearch shows the search page, listing the acronyms matching the
+// 'q' and 'source' query parameters a page at a time.
+func uiSearch(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/" {
+		uiError(w, http.StatusNotFound, "The page '"+r.URL.Path+"' does not exist.")
+		return
+	}
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		uiError(w, http.StatusMethodNotAllowed, "The page can not be used with "+r.Method+".")
+		return
+	}
+	params := r.URL.Query()
+	page := newUIPage("Search")
+	page.Query = params.Get("q")
+	page.Source = params.Get("source")
+	page.Message = uiMessages[params.Get("msg")]
+	limit, offset, err := parsePage(params)
+	if err != nil {
+		uiError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if page.Sources, err = ListSources(); err != nil {
+		uiFail(w, err)
+		return
+	}
+	records, err := FindRecords(SearchQuery{Term: page.Query, Wild: true, Source: page.Source})
+	if err != nil {
+		uiFail(w, err)
+		return
+	}
+
+	if len(records) == 1 {
+		page.Summary = "1 acronym found"
+	} else {
+		page.Summary = fmt.Sprintf("%d acronyms found", len(records))
+	}
+	if offset < len(records) {
+		end := offset + limit
+		if end > len(records) {
+			end = len(records)
+		}
+		page.Records = records[offset:end]
+		if offset > 0 || end < len(records) {
+			page.Summary += fmt.Sprintf(" - showing %d to %d", offset+1, end)
+		}
+	}
+	// the message is not repeated on the other pages of results
+	params.Del("msg")
+	u := *r.URL
+	u.RawQuery = params.Encode()
+	if offset+limit < len(records) {
+		page.Next = pageURL(&u, limit, offset+limit)
+	}
+	if offset > 0 {
+		prev := offset - limit
+		if prev < 0 {
+			prev = 0
+		}
+		page.Prev = pageURL(&u, limit, prev)
+	}
+	uiRender(w, r, http.StatusOK, "index.html", page)
+}
+
+// uiAcronym answers the pages for a single acronym record:
+//
+//	/acronyms/new          the form to add an acronym
+//	/acronyms/<id>         the acronym record
+//	/acronyms/<id>/edit    the form to change it
+//	/acronyms/<id>/delete  moves it to the trash
+func uiAcronym(w http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/acronyms/"), "/")
+	if parts[0] == "new" && len(parts) == 1 {
+		uiCreate(w, r)
+		return
+	}
+	if len(parts) > 2 || (!IsUUID(parts[0]) && !isNumber(parts[0])) {
+		uiError(w, http.StatusNotFound, "The page '"+r.URL.Path+"' does not exist.")
+		return
+	}
+	rec, err := LookupRecord(parts[0])
+	if err != nil {
+		uiFail(w, err)
+		return
+	}
+	action := ""
+	if len(parts) == 2 {
+		action = parts[1]
+	}
+	switch {
+	case action == "" && (r.Method == http.MethodGet || r.Method == http.MethodHead):
+		page := newUIPage(rec.Acronym)
+		page.Record = rec
+		page.Message = uiMessages[r.URL.Query().Get("msg")]
+		uiRender(w, r, http.StatusOK, "record.html", page)
+	case action == "edit":
+		uiUpdate(w, r, rec)
+	case action == "delete" && r.Method == http.MethodPost:
+		uiDelete(w, r, rec)
+	case action == "delete":
+		w.Header().Set("Allow", "POST")
+		uiError(w, http.StatusMethodNotAllowed, "The page can not be used with "+r.Method+".")
+	case action == "":
+		w.Header().Set("Allow", "GET, HEAD")
+		uiError(w, http.StatusMethodNotAllowed, "The page can not be used with "+r.Method+".")
+	default:
+		uiError(w, http.StatusNotFound, "The page '"+r.URL.Path+"' does not exist.")
+	}
+}
+
+// isNumber returns true if 'value' is a whole number.
+func isNumber(value string) bool {
+	_, err := strconv.ParseInt(value, 10, 64)
+	return err == nil
+}
+
+// uiCreate shows the form to add an acronym, and adds the acronym when
+// the form is sent.
+func uiCreate(w http.ResponseWriter, r *http.Request) {
+	page := newUIPage("Add an acronym")
+	page.Action = "/acronyms/new"
+	page.Cancel = "/"
+	if r.Method != http.MethodPost {
+		uiForm(w, r, http.StatusOK, page)
+		return
+	}
+	if !uiCheckPost(w, r) {
+		return
+	}
+	uiFormValues(r).apply(&page.Record)
+	if err := ValidateRecord(&page.Record); err != nil {
+		page.Error = strings.TrimPrefix(err.Error(), "ERROR: ")
+		uiForm(w, r, http.StatusUnprocessableEntity, page)
+		return
+	}
+	if err := InsertRecord(&page.Record, requestUser(r)); err != nil {
+		uiFail(w, err)
+		return
+	}
+	http.Redirect(w, r, fmt.Sprintf("/acronyms/%d?msg=added", page.Record.ID), http.StatusSeeOther)
+}
+
+// uiUpdate shows the form to change the acronym record 'rec', and saves
+// the changes when the form is sent. If someone else has saved a change
+// to the record since the form was shown, the form is shown again with
+// the record as it is now.
+func uiUpdate(w http.ResponseWriter, r *http.Request, rec Record) {
+	page := newUIPage(fmt.Sprintf("Change acronym '%s'", rec.Acronym))
+	page.Action = fmt.Sprintf("/acronyms/%d/edit", rec.ID)
+	page.Cancel = fmt.Sprintf("/acronyms/%d", rec.ID)
+	page.Record = rec
+	if r.Method != http.MethodPost {
+		uiForm(w, r, http.StatusOK, page)
+		return
+	}
+	if !uiCheckPost(w, r) {
+		return
+	}
+	version, err := strconv.ParseInt(r.PostFormValue("version"), 10, 64)
+	if err != nil {
+		uiError(w, http.StatusBadRequest, "The form did not include the version of the acronym being changed.")
+		return
+	}
+	uiFormValues(r).apply(&page.Record)
+	page.Record.Version = version
+	if err = ValidateRecord(&page.Record); err != nil {
+		page.Error = strings.TrimPrefix(err.Error(), "ERROR: ")
+		uiForm(w, r, http.StatusUnprocessableEntity, page)
+		return
+	}
+	err = UpdateRecord(&page.Record, requestUser(r))
+	var conflict *ConflictError
+	if errors.As(err, &conflict) {
+		page.Error = strings.TrimPrefix(err.Error(), "ERROR: ")
+		page.Conflict = &conflict.Theirs
+		page.Record.Version = conflict.Theirs.Version
+		uiForm(w, r, http.StatusConflict, page)
+		return
+	}
+	if err != nil {
+		uiFail(w, err)
+		return
+	}
+	http.Redirect(w, r, page.Cancel+"?msg=saved", http.StatusSeeOther)
+}
+
+// uiDelete moves the acronym record 'rec' to the trash - unless someone
+// else has changed it since it was shown, when it is shown again as it
+// is now.
+func uiDelete(w http.ResponseWriter, r *http.Request, rec Record) {
+	if !uiCheckPost(w, r) {
+		return
+	}
+	version, err := strconv.ParseInt(r.PostFormValue("version"), 10, 64)
+	if err != nil {
+		uiError(w, http.StatusBadRequest, "The form did not include the version of the acronym being removed.")
+		return
+	}
+	rec.Version = version
+	_, err = DeleteRecordVersion(rec, requestUser(r))
+	var conflict *ConflictError
+	if errors.As(err, &conflict) {
+		page := newUIPage(conflict.Theirs.Acronym)
+		page.Record = conflict.Theirs
+		page.Error = strings.TrimPrefix(err.Error(), "ERROR: ") + " - check the changes before removing it."
+		uiRender(w, r, http.StatusConflict, "record.html", page)
+		return
+	}
+	if err != nil {
+		uiFail(w, err)
+		return
+	}
+	http.Redirect(w, r, "/?msg=deleted", http.StatusSeeOther)
+}
+
+// uiFormValues returns the acronym values sent by the form in the
+// request 'r'.
+func uiFormValues(r *http.Request) *apiRecordInput {
+	value := func(name string) *string {
+		if _, ok := r.PostForm[name]; !ok {
+			return nil
+		}
+		v := r.PostForm.Get(name)
+		return &v
+	}
+	in := &apiRecordInput{
+		Acronym:     value("acronym"),
+		Definition:  value("definition"),
+		Description: value("description"),
+		Source:      value("source"),
+	}
+	if tags := value("tags"); tags != nil {
+		in.Tags = &[]string{*tags}
+	}
+	return in
+}
+
+// uiCheckPost reads the form sent with the request 'r', returning false
+// after showing an error if changes can not be made or the form did not
+// come from one of these pages.
+func uiCheckPost(w http.ResponseWriter, r *http.Request) bool {
+	if err := requireWritable(); err != nil {
+		uiFail(w, err)
+		return false
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, apiMaxBody)
+	if err := r.ParseForm(); err != nil {
+		uiError(w, http.StatusBadRequest, "Unable to read the form: "+err.Error())
+		return false
+	}
+	// the scheme is not compared, as a TLS proxy may sit in front of the
+	// server
+	if origin := r.Header.Get("Origin"); origin != "" {
+		if u, err := url.Parse(origin); err != nil || !strings.EqualFold(u.Host, r.Host) {
+			uiError(w, http.StatusForbidden, "Changes can only be made from these pages.")
+			return false
+		}
+	}
+	if subtle.ConstantTimeCompare([]byte(r.PostFormValue("token")), []byte(uiToken)) != 1 {
+		uiError(w, http.StatusForbidden, "The form has expired - go back, reload the page and try again.")
+		return false
+	}
+	return true
+}
+
+// uiForm shows the form to add or change an acronym on 'page'.
+func uiForm(w http.ResponseWriter, r *http.Request, status int, page *uiPage) {
+	if page.ReadOnly {
+		uiFail(w, ErrReadOnly)
+		return
+	}
+	if !tokenAllows(r, RoleEditor) {
+		uiError(w, http.StatusForbidden, "Your API token can not change acronyms.")
+		return
+	}
+	var err error
+	if page.Sources, err = ListSources(); err != nil {
+		uiFail(w, err)
+		return
+	}
+	uiRender(w, r, status, "form.html", page)
+}
+
+// uiRender sends the page built from the template 'name' and 'page' to
+// the browser with the status 'status'.
+func uiRender(w http.ResponseWriter, r *http.Request, status int, name string, page *uiPage) {
+	page.CanEdit = !page.ReadOnly && tokenAllows(r, RoleEditor)
+	var body bytes.Buffer
+	if err := uiTemplates.ExecuteTemplate(&body, name, page); err != nil {
+		log.Printf("ERROR: unable to show web page '%s': %v\n", name, err)
+		http.Error(w, "unable to show the page", http.StatusInternalServerError)
+		return
+	}
+	h := w.Header()
+	h.Set("Content-Type", "text/html; charset=utf-8")
+	h.Set("Content-Security-Policy", "default-src 'self'; frame-ancestors 'none'")
+	h.Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	if r.Method != http.MethodHead {
+		w.Write(body.Bytes())
+	}
+}
+
+// uiError shows a page with the error message 'message' and the status
+// 'status'.
+func uiError(w http.ResponseWriter, status int, message string) {
+	page := newUIPage(http.StatusText(status))
+	page.Error = strings.TrimPrefix(message, "ERROR: ")
+	uiRender(w, &http.Request{Method: http.MethodGet}, status, "error.html", page)
+}
+
+// uiFail shows a page with the error 'err' returned by the database
+// functions, with the status that best describes it.
+func uiFail(w http.ResponseWriter, err error) {
+	status, message := errorStatus(err)
+	uiError(w, status, message)
+}
@@ -0,0 +1,122 @@
+// Instant search and delete confirmation for the pages served by
+// 'amt serve'. The pages still work without it - the search form is
+// then sent to the server as usual.
+(function () {
+  "use strict";
+
+  // ask before sending any form marked with 'data-confirm'
+  document.querySelectorAll("form[data-confirm]").forEach(function (form) {
+    form.addEventListener("submit", function (event) {
+      if (!window.confirm(form.getAttribute("data-confirm"))) {
+        event.preventDefault();
+      }
+    });
+  });
+
+  var form = document.getElementById("search-form");
+  if (!form) {
+    return;
+  }
+  var input = document.getElementById("search");
+  var source = document.getElementById("source");
+  var summary = document.getElementById("summary");
+  var results = document.getElementById("results");
+  var pages = document.getElementById("pages");
+  var limit = 50;
+  var timer = null;
+  var latest = 0;
+
+  function params() {
+    var p = new URLSearchParams();
+    if (input.value.trim() !== "") {
+      p.set("q", input.value.trim());
+    }
+    if (source.value !== "") {
+      p.set("source", source.value);
+    }
+    return p;
+  }
+
+  function span(className, text) {
+    var s = document.createElement("span");
+    s.className = className;
+    s.textContent = text;
+    return s;
+  }
+
+  function show(found, p) {
+    results.textContent = "";
+    found.records.forEach(function (r) {
+      var dt = document.createElement("dt");
+      var a = document.createElement("a");
+      a.href = "/acronyms/" + r.id;
+      a.textContent = r.acronym;
+      dt.appendChild(a);
+      var dd = document.createElement("dd");
+      dd.appendChild(span("definition", r.definition));
+      if (r.source) {
+        dd.appendChild(document.createTextNode(" "));
+        dd.appendChild(span("source", r.source));
+      }
+      (r.tags || []).forEach(function (tag) {
+        dd.appendChild(document.createTextNode(" "));
+        dd.appendChild(span("tag", tag));
+      });
+      results.appendChild(dt);
+      results.appendChild(dd);
+    });
+
+    summary.textContent = found.total === 1 ? "1 acronym found" : found.total + " acronyms found";
+    pages.textContent = "";
+    if (found.total > found.records.length) {
+      var next = new URLSearchParams(p);
+      next.set("offset", found.records.length);
+      var more = document.createElement("a");
+      more.href = "/?" + next.toString();
+      more.rel = "next";
+      more.textContent = "Next";
+      pages.appendChild(more);
+    }
+  }
+
+  function search() {
+    var p = params();
+    var query = new URLSearchParams(p);
+    query.set("wild", "true");
+    query.set("limit", limit);
+    var id = ++latest;
+    fetch("/api/acronyms?" + query.toString())
+      .then(function (response) {
+        return response.json().then(function (body) {
+          if (!response.ok) {
+            throw new Error(body.error || response.statusText);
+          }
+          return body;
+        });
+      })
+      .then(function (found) {
+        // ignore replies to searches that have since been replaced
+        if (id === latest) {
+          show(found, p);
+          var qs = p.toString();
+          history.replaceState(null, "", qs ? "/?" + qs : "/");
+        }
+      })
+      .catch(function (err) {
+        if (id === latest) {
+          summary.textContent = "Search failed: " + err.message;
+        }
+      });
+  }
+
+  input.addEventListener("input", function () {
+    clearTimeout(timer);
+    timer = setTimeout(search, 150);
+  });
+  source.addEventListener("change", search);
+  form.addEventListener("submit", function (event) {
+    event.preventDefault();
+    clearTimeout(timer);
+    search();
+  });
+})();
@@ -6,3 +6,20 @@ var DbName string
 var Appversion string
 var Appname string
 var RecCount int64
+
+// ReadOnly opens the database so that it can only be read - no
+// changes can be made and no write locks are ever taken on it.
+var ReadOnly bool
+
+// Immutable opens the database read-only and tells SQLite the file can
+// not change while it is open - such as on read-only media - so no
+// locking is done at all.
+var Immutable bool
+
+// Quiet stops the details of the database being printed as it is
+// opened - so the output holds only the answers, as when run as 'wtf'.
+var Quiet bool
+
+// ServerURL is the address of an amt HTTP server holding the acronyms,
+// used in place of a local database when set.
+var ServerURL string
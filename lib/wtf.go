@@ -0,0 +1,143 @@
+// amt - program to access an SQLite database and lookup acronyms
+//
+// author:	Simon Rowe <simon@wiremoons.com>
+// license: open-source released under The MIT License (MIT).
+//
+// Package used to work with the BSD wtf(6) acronym files for
+// application 'amt'
+//
+// Many systems ship files such as '/usr/share/misc/acronyms' for the
+// 'wtf' command. Each line holds an acronym and its meaning separated
+// by a tab. These files can be imported and the database exported in
+// the same format - and when the program is run with the name 'wtf' it
+// answers questions the same way the 'wtf' command does.
+
+package lib
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// wtfFileName is the name of the acronym files used by wtf(6), which
+// may carry a suffix - such as 'acronyms.comp'.
+const wtfFileName = "acronyms"
+
+// isWtfFile returns true if the file called 'name' is named like one of
+// the acronym files used by wtf(6).
+func isWtfFile(name string) bool {
+	base := filepath.Base(name)
+	return base == wtfFileName || strings.HasPrefix(base, wtfFileName+".")
+}
+
+// ReadWtf reads the acronyms held in the wtf(6) file 'r'. Every record
+// is given the source 'source' - normally the name of the file. Blank
+// lines and comments, which start with '#' or '$', are skipped. A line
+// without a tab between the acronym and its meaning is returned with
+// its 'Err' set, so it is reported as rejected. Rows are numbered by
+// their line in the file.
+func ReadWtf(r io.Reader, source string) ([]ImportRow, error) {
+	var rows []ImportRow
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		line := strings.TrimRight(scanner.Text(), "\r")
+		if lineNo == 1 {
+			line = strings.TrimPrefix(line, "\ufeff")
+		}
+		if strings.TrimSpace(line) == "" || strings.HasPrefix(line, "#") || strings.HasPrefix(line, "$") {
+			continue
+		}
+		row := ImportRow{Row: lineNo}
+		i := strings.Index(line, "\t")
+		if i < 0 {
+			row.Err = fmt.Errorf("no tab between the acronym and its meaning")
+		} else {
+			row.Record = Record{
+				Acronym:    strings.TrimSpace(line[:i]),
+				Definition: strings.TrimSpace(line[i+1:]),
+				Source:     source,
+			}
+		}
+		rows = append(rows, row)
+	}
+	if err := scanner.Err(); err != nil {
+		return rows, fmt.Errorf("ERROR: unable to read the wtf acronyms file: %v", err)
+	}
+	return rows, nil
+}
+
+// writeGlossaryWtf writes the acronym records in the glossary 'g' to 'w'
+// as a wtf(6) acronyms file. The lines are sorted by acronym without
+// regard to case, as wtf(6) searches the file with look(1).
+func writeGlossaryWtf(w io.Writer, g *Glossary) error {
+	lines := make([]string, 0, len(g.Records))
+	for _, r := range g.Records {
+		// a tab or line break would end the meaning early
+		lines = append(lines, strings.Join(strings.Fields(r.Acronym), " ")+"\t"+
+			strings.Join(strings.Fields(r.Definition), " "))
+	}
+	sort.SliceStable(lines, func(i, j int) bool {
+		return strings.ToUpper(lines[i]) < strings.ToUpper(lines[j])
+	})
+	bw := bufio.NewWriter(w)
+	for _, line := range lines {
+		if _, err := fmt.Fprintln(bw, line); err != nil {
+			return err
+		}
+	}
+	return bw.Flush()
+}
+
+// WtfMode returns true if the program was run with the name 'wtf', so it
+// should behave like the wtf(6) command.
+func WtfMode() bool {
+	return strings.TrimSuffix(strings.ToLower(Appname), ".exe") == "wtf"
+}
+
+// RunWtf answers 'wtf [is] <acronym>...' in the same way as wtf(6) -
+// printing each meaning of every acronym given as 'ACRONYM: meaning',
+// or a message if it is not known. Only records carrying every tag in
+// 'tags' are used. Nothing else is printed, so the output can be used
+// by scripts. The exit status for the program is returned: 1 if any
+// acronym was not found or the database could not be read.
+func RunWtf(args []string, tags []string) int {
+	if len(args) > 1 && strings.EqualFold(args[0], "is") {
+		args = args[1:]
+	}
+	if len(args) == 0 {
+		fmt.Fprintf(os.Stderr, "usage: %s [-t tags] [is] <acronym> ...\n", Appname)
+		return 1
+	}
+
+	defer CloseDataBase()
+	if err := openQuiet(true); err != nil {
+		fmt.Fprintln(os.Stderr, strings.TrimSpace(err.Error()))
+		return 1
+	}
+
+	status := 0
+	for _, term := range args {
+		// allow questions such as: wtf is WYSIWYG?
+		term = strings.TrimRight(term, "?")
+		records, err := Storage.FindRecords(SearchQuery{Term: term, Tags: tags})
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		if len(records) == 0 {
+			fmt.Fprintf(os.Stderr, "Gee...  I don't know what %s means...\n", term)
+			status = 1
+			continue
+		}
+		for _, r := range records {
+			fmt.Printf("%s: %s\n", r.Acronym, r.Definition)
+		}
+	}
+	return status
+}
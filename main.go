@@ -31,6 +31,12 @@ var helpMe bool
 var addNew bool
 var showVer bool
 var rmid string
+var tagFilter string
+var addedSince string
+var changedSince string
+var authorFilter string
+var readOnly bool
+var serverURL string
 
 // used to keep track of database record count
 var RecCount int64
@@ -48,11 +54,17 @@ func init() {
 	flag.StringVar(&DbName, "f", "", "\tprovide SQLite database `filename` and path")
 	flag.StringVar(&searchTerm, "s", "", "\t`acronym` to search for")
 	flag.StringVar(&rmid, "r", "", "\t`acronym id` to remove")
+	flag.StringVar(&tagFilter, "t", "", "\tonly find acronyms with these `tags`")
+	flag.StringVar(&addedSince, "added", "", "\tonly find acronyms added within this `age` or since this date")
+	flag.StringVar(&changedSince, "changed", "", "\tonly find acronyms changed within this `age` or since this date")
+	flag.StringVar(&authorFilter, "author", "", "\tonly find acronyms added or changed by this `user`")
 	flag.BoolVar(&wildLookUp, "w", false, "\tsearch for any similar matches")
 	flag.BoolVar(&DebugSwitch, "d", false, "\tshow debug output")
 	flag.BoolVar(&helpMe, "h", false, "\tdisplay help for this program")
 	flag.BoolVar(&showVer, "v", false, "\tdisplay program version")
 	flag.BoolVar(&addNew, "n", false, "\tadd a new acronym record")
+	flag.BoolVar(&readOnly, "ro", false, "\topen the database read-only")
+	flag.StringVar(&serverURL, "server", "", "\tuse the acronyms held by the amt server at this `url`")
 	// get the command line args passed to the program
 	flag.Parse()
 	// get the name of the application as called from the command line
@@ -78,11 +90,17 @@ func main() {
 		log.Println("\t\tDatabase name to use via command line:", DbName)
 		log.Println("\t\tAcronym to search for:", searchTerm)
 		log.Println("\t\tAcronym to remove:", rmid)
+		log.Println("\t\tTags to filter search by:", tagFilter)
+		log.Println("\t\tAdded since filter:", addedSince)
+		log.Println("\t\tChanged since filter:", changedSince)
+		log.Println("\t\tAuthor filter:", authorFilter)
 		log.Println("\t\tLook for similar matches:", strconv.FormatBool(wildLookUp))
 		log.Println("\t\tDisplay additional debug output when run:", strconv.FormatBool(DebugSwitch))
 		log.Println("\t\tDisplay additional help information:", strconv.FormatBool(helpMe))
 		log.Println("\t\tAdd a new acronym record:", strconv.FormatBool(addNew))
 		log.Println("\t\tShow the applications version:", strconv.FormatBool(showVer))
+		log.Println("\t\tOpen the database read-only:", strconv.FormatBool(readOnly))
+		log.Println("\t\tamt server to use via command line:", serverURL)
 	}
 
 	// a function that will run at the end of the program
@@ -100,48 +118,104 @@ func main() {
 		lib.MyUsage()
 	}
 
-	// print out start up banner
+	// read any settings from the optional configuration file
+	if err = lib.LoadConfig(); err != nil {
+		log.Fatal(err)
+	}
+	// read-only mode can be set from either the command line or the
+	// configuration file
+	lib.ReadOnly = readOnly || lib.Settings.ReadOnly
+	lib.Immutable = lib.Settings.Immutable
+	// an amt server can be set from either the command line or the
+	// configuration file - the command line taking priority
+	lib.ServerURL = serverURL
+	if lib.ServerURL == "" {
+		lib.ServerURL = lib.Settings.Server
+	}
 	if DebugSwitch {
-		log.Println("DEBUG: Calling 'printBanner()'")
+		log.Printf("DEBUG: read-only mode: %v  immutable: %v\n", lib.ReadOnly, lib.Immutable)
 	}
-	lib.PrintBanner()
 
-	// check if a valid database file is available on the system
-	if DebugSwitch {
-		log.Println("DEBUG: Calling 'checkDB()'")
+	// when run as 'wtf' behave like the wtf(6) command - printing
+	// only the meaning of each acronym asked about
+	if lib.WtfMode() {
+		os.Exit(lib.RunWtf(flag.Args(), lib.SplitTags(tagFilter)))
 	}
 
-	err = lib.CheckDB()
-	if err != nil {
-		log.Println(err)
-		// no database found - offer to create one
-		fmt.Printf("\nCreate a new database and add a few example acronyms?")
-		if !lib.CheckContinue() {
-			// no database available - exit application
-			log.Fatal("ERROR: unable to continue without a valid acronym database.\n")
-		}
-		// user wants a new database - so attempt to create it in the same directory as the
-		// program executable using the file named: 'amt-db.db' - set location here then attempt to open it
-		DbName = filepath.Join(filepath.Dir(os.Args[0]), "amt-db.db")
+	// when run as 'amt lsp' an editor talks to the program over stdin
+	// and stdout - so nothing else may be printed there
+	if flag.Arg(0) == "lsp" {
+		os.Exit(lib.RunLsp(flag.Args()[1:]))
 	}
-	// Setup and open the database ready for use
+
+	// print out start up banner
 	if DebugSwitch {
-		log.Println("DEBUG: database found - attempting to open with 'OpenDataBase()'")
-	}
-	err = lib.OpenDataBase()
-	if err != nil {
-		log.Println(err)
+		log.Println("DEBUG: Calling 'printBanner()'")
 	}
+	lib.PrintBanner()
 
-	// attempt to populate the database with some example records if it
-	// is empty - ask user first
-	if (lib.CheckCount()) == 0 {
-		fmt.Println("\nWould you like to add some initial records to your empty acronyms database?")
-		if lib.CheckContinue() {
-			err = lib.PopNewDB()
+	// see if a search is the only work to be done
+	searching := !helpMe && !showVer && flag.NArg() == 0 && !addNew &&
+		(len(searchTerm) > 0 || len(tagFilter) > 0 || len(addedSince) > 0 ||
+			len(changedSince) > 0 || len(authorFilter) > 0)
+
+	// use the acronyms held by an amt server if one is set, or
+	// otherwise the local database
+	if lib.ServerURL != "" {
+		if DebugSwitch {
+			log.Println("DEBUG: Calling 'OpenServer()'")
+		}
+		if err = lib.OpenServer(); err != nil {
+			log.Fatal(err)
+		}
+	} else {
+		// check if a valid database file is available on the system
+		if DebugSwitch {
+			log.Println("DEBUG: Calling 'checkDB()'")
+		}
+
+		err = lib.CheckDB()
+		if err != nil {
+			log.Println(err)
+			// a read-only database can not be created
+			if lib.ReadOnly {
+				log.Fatal("ERROR: unable to continue without a valid acronym database - a new one can not be created in read-only mode.\n")
+			}
+			// no database found - offer to create one
+			fmt.Printf("\nCreate a new database and add a few example acronyms?")
+			if !lib.CheckContinue() {
+				// no database available - exit application
+				log.Fatal("ERROR: unable to continue without a valid acronym database.\n")
+			}
+			// user wants a new database - so attempt to create it in the same directory as the
+			// program executable using the file named: 'amt-db.db' - set location here then attempt to open it
+			DbName = filepath.Join(filepath.Dir(os.Args[0]), "amt-db.db")
+			lib.DbName = DbName
+		}
+		// a search is answered by an amt daemon if one is running for
+		// the database, so the database need not be opened here
+		if !(searching && err == nil && lib.OpenDaemon() == nil) {
+			// Setup and open the database ready for use
+			if DebugSwitch {
+				log.Println("DEBUG: database found - attempting to open with 'OpenDataBase()'")
+			}
+			err = lib.OpenDataBase()
 			if err != nil {
-				// records could not be added - exit application
-				log.Fatalf("ERROR: aborting program with error: %v\n", err)
+				log.Println(err)
+			}
+			defer lib.CloseDataBase()
+
+			// attempt to populate the database with some example records if it
+			// is empty - ask user first
+			if !lib.ReadOnly && lib.CheckCount() == 0 {
+				fmt.Println("\nWould you like to add some initial records to your empty acronyms database?")
+				if lib.CheckContinue() {
+					err = lib.PopNewDB()
+					if err != nil {
+						// records could not be added - exit application
+						log.Fatalf("ERROR: aborting program with error: %v\n", err)
+					}
+				}
 			}
 		}
 	}
@@ -164,17 +238,42 @@ func main() {
 		}
 		lib.VersionInfo()
 
+	case flag.NArg() > 0:
+		if DebugSwitch {
+			log.Printf("DEBUG: command '%s' switch statement called", flag.Arg(0))
+		}
+		if err = lib.RunCommand(flag.Args()); err != nil {
+			log.Println(err)
+		}
+
 	case addNew:
 		if DebugSwitch {
 			log.Println("DEBUG: 'addNew' switch statement called")
 		}
 		lib.AddRecord()
 
-	case len(searchTerm) > 0:
+	case len(searchTerm) > 0 || len(tagFilter) > 0 || len(addedSince) > 0 ||
+		len(changedSince) > 0 || len(authorFilter) > 0:
 		if DebugSwitch {
 			log.Println("DEBUG: search switch statement called")
 		}
-		lib.SearchRecord(searchTerm)
+		query := lib.SearchQuery{
+			Term:   searchTerm,
+			Wild:   wildLookUp,
+			Tags:   lib.SplitTags(tagFilter),
+			Author: authorFilter,
+		}
+		if len(addedSince) > 0 {
+			if query.AddedSince, err = lib.ParseSince(addedSince); err != nil {
+				log.Fatal(err)
+			}
+		}
+		if len(changedSince) > 0 {
+			if query.ChangedSince, err = lib.ParseSince(changedSince); err != nil {
+				log.Fatal(err)
+			}
+		}
+		lib.SearchRecord(query)
 
 	case len(rmid) > 0:
 		if DebugSwitch {